@@ -0,0 +1,272 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/requestid"
+	"github.com/premkit/premkit/utils"
+)
+
+const (
+	// FormatJSON writes every entry as a line of JSON.
+	FormatJSON = "json"
+
+	// FormatCommon writes entries in the Common Log Format.
+	FormatCommon = "common"
+
+	// FormatCombined writes entries in the Combined Log Format, which adds the referer and user
+	// agent to the Common Log Format.
+	FormatCombined = "combined"
+
+	// FormatTemplate writes entries with a text/template executed on the Entry.
+	FormatTemplate = "template"
+)
+
+// Stdout is the target of Open that writes to standard output.
+const Stdout = "stdout"
+
+// clfTimeFormat is the time format of the Common Log Format.
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// Entry is one request, logged once it is answered.
+type Entry struct {
+	Time      time.Time
+	ClientIP  string
+	Method    string
+	Host      string
+	Path      string
+	Protocol  string
+	Referer   string
+	UserAgent string
+
+	// RequestID is the ID of the request, returned in its X-Request-Id header.
+	RequestID string
+
+	// Service and Upstream are the service the request matched and the upstream it was
+	// forwarded to, when it was.
+	Service  string
+	Upstream string
+
+	Status int
+	Bytes  int64
+
+	// UpstreamLatency is the time the upstream took to answer, and TotalLatency the time premkit
+	// took, including the upstream.
+	UpstreamLatency time.Duration
+	TotalLatency    time.Duration
+}
+
+// jsonEntry is an Entry as written in FormatJSON.
+type jsonEntry struct {
+	Time            time.Time `json:"time"`
+	ClientIP        string    `json:"client_ip"`
+	Method          string    `json:"method"`
+	Host            string    `json:"host"`
+	Path            string    `json:"path"`
+	Protocol        string    `json:"protocol"`
+	Referer         string    `json:"referer,omitempty"`
+	UserAgent       string    `json:"user_agent,omitempty"`
+	RequestID       string    `json:"request_id,omitempty"`
+	Service         string    `json:"service,omitempty"`
+	Upstream        string    `json:"upstream,omitempty"`
+	Status          int       `json:"status"`
+	Bytes           int64     `json:"bytes"`
+	UpstreamLatency float64   `json:"upstream_latency_ms"`
+	TotalLatency    float64   `json:"total_latency_ms"`
+}
+
+// Logger writes an entry for every request to a writer.  It is safe for concurrent use.
+type Logger struct {
+	format   string
+	template *template.Template
+
+	mu     sync.Mutex
+	writer io.Writer
+	closer io.Closer
+}
+
+// New returns a logger that writes entries to writer in format.  tmpl is the template of
+// FormatTemplate, and is ignored otherwise.
+func New(writer io.Writer, format string, tmpl string) (*Logger, error) {
+	l := &Logger{
+		format: format,
+		writer: writer,
+	}
+
+	switch format {
+	case FormatJSON, FormatCommon, FormatCombined:
+
+	case FormatTemplate:
+		t, err := template.New("access").Parse(tmpl)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		l.template = t
+
+	default:
+		err := fmt.Errorf("Unknown access log format %q", format)
+		log.Error(err)
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Open returns a logger that writes to standard output when target is Stdout, or to the file at
+// target, which is rotated when it grows past maxSize bytes.
+func Open(target string, format string, tmpl string, maxSize int64, maxBackups int) (*Logger, error) {
+	if target == Stdout {
+		return New(os.Stdout, format, tmpl)
+	}
+
+	file, err := utils.OpenRotatingFile(target, maxSize, maxBackups)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	l, err := New(file, format, tmpl)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	l.closer = file
+
+	return l, nil
+}
+
+// Close closes the file the logger writes to, if it opened one.
+func (l *Logger) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+
+	return l.closer.Close()
+}
+
+// Log writes the entry.  Failures are logged, and do not fail the request.
+func (l *Logger) Log(entry *Entry) {
+	line, err := l.line(entry)
+	if err != nil {
+		log.Errorf("Failed to format access log entry: %v", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.writer.Write(line); err != nil {
+		log.Errorf("Failed to write access log entry: %v", err)
+	}
+}
+
+// line formats the entry as a line of the log.
+func (l *Logger) line(entry *Entry) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch l.format {
+	case FormatJSON:
+		b, err := json.Marshal(jsonEntry{
+			Time:            entry.Time,
+			ClientIP:        entry.ClientIP,
+			Method:          entry.Method,
+			Host:            entry.Host,
+			Path:            entry.Path,
+			Protocol:        entry.Protocol,
+			Referer:         entry.Referer,
+			UserAgent:       entry.UserAgent,
+			RequestID:       entry.RequestID,
+			Service:         entry.Service,
+			Upstream:        entry.Upstream,
+			Status:          entry.Status,
+			Bytes:           entry.Bytes,
+			UpstreamLatency: milliseconds(entry.UpstreamLatency),
+			TotalLatency:    milliseconds(entry.TotalLatency),
+		})
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+
+	case FormatCommon, FormatCombined:
+		size := "-"
+		if entry.Bytes > 0 {
+			size = fmt.Sprintf("%d", entry.Bytes)
+		}
+		fmt.Fprintf(&buf, "%s - - [%s] \"%s %s %s\" %d %s",
+			entry.ClientIP, entry.Time.Format(clfTimeFormat), entry.Method, entry.Path, entry.Protocol, entry.Status, size)
+
+		if l.format == FormatCombined {
+			fmt.Fprintf(&buf, " %q %q", entry.Referer, entry.UserAgent)
+		}
+
+	case FormatTemplate:
+		if err := l.template.Execute(&buf, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// entryKey is the context key of the *Entry of a request.
+type entryKey struct{}
+
+// FromContext returns the entry of the request with the context, so handlers can add the service
+// and upstream, or nil when requests are not logged.
+func FromContext(ctx context.Context) *Entry {
+	entry, _ := ctx.Value(entryKey{}).(*Entry)
+	return entry
+}
+
+// Handler logs every request to next, or returns next when there is no logger.
+func (l *Logger) Handler(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+
+		entry := &Entry{
+			Time:      start,
+			ClientIP:  request.RemoteAddr,
+			Method:    request.Method,
+			Host:      request.Host,
+			Path:      request.URL.RequestURI(),
+			Protocol:  request.Proto,
+			Referer:   request.Referer(),
+			UserAgent: request.UserAgent(),
+			RequestID: requestid.FromContext(request.Context()),
+		}
+		if host, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+			entry.ClientIP = host
+		}
+
+		recorder := utils.NewResponseRecorder(response)
+		next.ServeHTTP(recorder, request.WithContext(context.WithValue(request.Context(), entryKey{}, entry)))
+
+		entry.Status = recorder.Status
+		entry.Bytes = recorder.Bytes
+		entry.TotalLatency = time.Since(start)
+
+		l.Log(entry)
+	})
+}
@@ -0,0 +1,137 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEntry() *Entry {
+	return &Entry{
+		Time:            time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC),
+		ClientIP:        "10.0.0.1",
+		Method:          "GET",
+		Host:            "example.com",
+		Path:            "/app/page?a=b",
+		Protocol:        "HTTP/1.1",
+		Referer:         "https://example.com/",
+		UserAgent:       "curl/8.0",
+		RequestID:       "abc-123",
+		Service:         "app",
+		Upstream:        "http://localhost:3000",
+		Status:          200,
+		Bytes:           512,
+		UpstreamLatency: 1500 * time.Microsecond,
+		TotalLatency:    2 * time.Millisecond,
+	}
+}
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		format   string
+		tmpl     string
+		expected string
+	}{
+		{
+			format:   FormatCommon,
+			expected: `10.0.0.1 - - [04/Mar/2026:05:06:07 +0000] "GET /app/page?a=b HTTP/1.1" 200 512` + "\n",
+		},
+		{
+			format:   FormatCombined,
+			expected: `10.0.0.1 - - [04/Mar/2026:05:06:07 +0000] "GET /app/page?a=b HTTP/1.1" 200 512 "https://example.com/" "curl/8.0"` + "\n",
+		},
+		{
+			format:   FormatTemplate,
+			tmpl:     "{{.Service}} {{.Upstream}} {{.Status}} {{.TotalLatency}}",
+			expected: "app http://localhost:3000 200 2ms\n",
+		},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		logger, err := New(&buf, test.format, test.tmpl)
+		require.NoError(t, err)
+
+		logger.Log(testEntry())
+		assert.Equal(t, test.expected, buf.String(), test.format)
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, FormatJSON, "")
+	require.NoError(t, err)
+
+	logger.Log(testEntry())
+
+	entry := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "10.0.0.1", entry["client_ip"])
+	assert.Equal(t, "abc-123", entry["request_id"])
+	assert.Equal(t, "app", entry["service"])
+	assert.Equal(t, "http://localhost:3000", entry["upstream"])
+	assert.Equal(t, 200.0, entry["status"])
+	assert.Equal(t, 512.0, entry["bytes"])
+	assert.Equal(t, 1.5, entry["upstream_latency_ms"])
+	assert.Equal(t, 2.0, entry["total_latency_ms"])
+}
+
+func TestInvalidFormat(t *testing.T) {
+	_, err := New(ioutil.Discard, "apache", "")
+	assert.Error(t, err)
+
+	_, err = New(ioutil.Discard, FormatTemplate, "{{.Status")
+	assert.Error(t, err)
+}
+
+func TestHandler(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "access.log")
+	logger, err := Open(filePath, FormatJSON, "", 0, 0)
+	require.NoError(t, err)
+
+	handler := logger.Handler(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		entry := FromContext(request.Context())
+		require.NotNil(t, entry)
+		entry.Service = "app"
+
+		// Handlers may change the request url when forwarding
+		request.URL.Path = "/page"
+
+		response.WriteHeader(http.StatusTeapot)
+		response.Write([]byte("short and stout"))
+	}))
+
+	request := httptest.NewRequest("POST", "http://example.com/app/page", nil)
+	request.RemoteAddr = "10.0.0.2:5000"
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	require.NoError(t, logger.Close())
+
+	contents, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+
+	entry := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(contents, &entry))
+	assert.Equal(t, "10.0.0.2", entry["client_ip"])
+	assert.Equal(t, "POST", entry["method"])
+	assert.Equal(t, "example.com", entry["host"])
+	assert.Equal(t, "/app/page", entry["path"])
+	assert.Equal(t, "app", entry["service"])
+	assert.Equal(t, 418.0, entry["status"])
+	assert.Equal(t, 15.0, entry["bytes"])
+
+	assert.Nil(t, FromContext(request.Context()))
+}
+
+func TestHandlerDisabled(t *testing.T) {
+	var logger *Logger
+	next := http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {})
+	assert.NotNil(t, logger.Handler(next))
+}
@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/utils"
+)
+
+const (
+	// ResultSuccess is the result of a call that returned a 2xx or 3xx status.
+	ResultSuccess = "success"
+
+	// ResultFailure is the result of a call that returned any other status.
+	ResultFailure = "failure"
+)
+
+// unixPrefix selects the unix socket sink in the target passed to Open.
+const unixPrefix = "unix:"
+
+// Entry is one call to the admin API, written as a line of JSON.
+type Entry struct {
+	Time time.Time `json:"time"`
+
+	// Caller is the common name of the verified client certificate of the call, ClaimedActor is
+	// the actor the caller claimed in the actor header, and SourceIP is the address it came from.
+	Caller       string `json:"caller,omitempty"`
+	ClaimedActor string `json:"claimed_actor,omitempty"`
+	SourceIP     string `json:"source_ip"`
+
+	// RequestID is the ID of the request of the call, returned in its X-Request-Id header.
+	RequestID string `json:"request_id,omitempty"`
+
+	Operation string `json:"operation"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Service   string `json:"service,omitempty"`
+	Upstream  string `json:"upstream,omitempty"`
+
+	Status     int    `json:"status"`
+	Result     string `json:"result"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Logger writes entries to a sink.  It is safe for concurrent use.
+type Logger struct {
+	sink io.WriteCloser
+}
+
+// New returns a logger that writes entries to sink.  The sink must be safe for concurrent use, as
+// the rotating file and the socket are, and write each entry whole.
+func New(sink io.WriteCloser) *Logger {
+	return &Logger{sink: sink}
+}
+
+// Open returns a logger that writes to the unix socket at target, when it starts with "unix:", or
+// to the file at target, which is rotated when it grows past maxSize bytes.
+func Open(target string, maxSize int64, maxBackups int) (*Logger, error) {
+	if strings.HasPrefix(target, unixPrefix) {
+		return New(newSocketSink(strings.TrimPrefix(target, unixPrefix))), nil
+	}
+
+	file, err := utils.OpenRotatingFile(target, maxSize, maxBackups)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return New(file), nil
+}
+
+// Log writes the entry.  Failures are logged, and do not fail the call that was audited.
+func (l *Logger) Log(entry *Entry) {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	b = append(b, '\n')
+
+	if _, err := l.sink.Write(b); err != nil {
+		log.Errorf("Failed to write audit entry for %s: %v", entry.Operation, err)
+	}
+}
+
+// Close closes the sink.
+func (l *Logger) Close() error {
+	return l.sink.Close()
+}
@@ -0,0 +1,137 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogToFile(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "audit.log")
+
+	logger, err := Open(filePath, 0, 0)
+	require.NoError(t, err)
+
+	logger.Log(&Entry{Operation: "registerService", Service: "a", Status: 201, Result: ResultSuccess})
+	logger.Log(&Entry{Operation: "deregisterService", Service: "b", Status: 404, Result: ResultFailure})
+	require.NoError(t, logger.Close())
+
+	contents, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	require.Equal(t, 2, len(lines))
+
+	entry := Entry{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, "deregisterService", entry.Operation)
+	assert.Equal(t, "b", entry.Service)
+	assert.Equal(t, 404, entry.Status)
+	assert.Equal(t, ResultFailure, entry.Result)
+}
+
+func TestLogToSocket(t *testing.T) {
+	// Unix socket paths are limited in length, so avoid the long test temp dir
+	dirName, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dirName)
+	socketPath := path.Join(dirName, "audit.sock")
+
+	logger, err := Open("unix:"+socketPath, 0, 0)
+	require.NoError(t, err)
+	defer logger.Close()
+
+	// Entries written while nothing is listening are dropped
+	logger.Log(&Entry{Operation: "dropped"})
+
+	received := make(chan string, 2)
+	conns := make(chan net.Conn, 2)
+	listen := func() net.Listener {
+		listener, err := net.Listen("unix", socketPath)
+		require.NoError(t, err)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				received <- scanner.Text()
+			}
+		}()
+		return listener
+	}
+
+	listener := listen()
+	logger.Log(&Entry{Operation: "first"})
+	assert.Contains(t, <-received, `"operation":"first"`)
+	listener.Close()
+	(<-conns).Close()
+
+	// The logger should reconnect after the listener restarts
+	os.Remove(socketPath)
+	listener = listen()
+	defer listener.Close()
+
+	logger.Log(&Entry{Operation: "second"})
+	assert.Contains(t, <-received, `"operation":"second"`)
+	(<-conns).Close()
+}
+
+func TestLogToSocketConcurrently(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dirName)
+	socketPath := path.Join(dirName, "audit.sock")
+
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					received <- scanner.Text()
+				}
+			}()
+		}
+	}()
+
+	logger, err := Open("unix:"+socketPath, 0, 0)
+	require.NoError(t, err)
+	defer logger.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logger.Log(&Entry{Operation: strings.Repeat("x", 1000)})
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 50; i++ {
+		entry := Entry{}
+		require.NoError(t, json.Unmarshal([]byte(<-received), &entry), "entries should not be interleaved")
+		assert.Equal(t, 1000, len(entry.Operation))
+	}
+}
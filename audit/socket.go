@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// socketTimeout bounds how long writing an entry to the socket can delay the audited call.
+const socketTimeout = time.Second
+
+// socketSink writes to a unix socket, connecting when the first entry is written and again after
+// a write fails, so entries are not lost while the listener restarts.  It is safe for concurrent
+// use, and dials without holding its lock, so a listener that is down only delays the calls that
+// are audited while it is dialed.
+type socketSink struct {
+	path string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newSocketSink(path string) *socketSink {
+	return &socketSink{path: path}
+}
+
+func (s *socketSink) Write(p []byte) (n int, err error) {
+	// The listener may have restarted since the last entry, so try once more on a new connection
+	for attempt := 0; attempt < 2; attempt++ {
+		var conn net.Conn
+		if conn, err = s.connect(); err != nil {
+			continue
+		}
+
+		// Writes to a connection are not interleaved, so entries written concurrently stay whole
+		conn.SetWriteDeadline(time.Now().Add(socketTimeout))
+		if n, err = conn.Write(p); err == nil {
+			return n, nil
+		}
+		s.drop(conn)
+	}
+
+	return n, err
+}
+
+// connect returns the connection to the socket, dialing a new one when there is none.
+func (s *socketSink) connect() (net.Conn, error) {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	if conn != nil {
+		return conn, nil
+	}
+
+	conn, err := net.DialTimeout("unix", s.path, socketTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Keep the connection of an entry that connected first
+	if s.conn != nil {
+		conn.Close()
+		return s.conn, nil
+	}
+	s.conn = conn
+	return conn, nil
+}
+
+// drop closes conn after a write to it failed, so the next entry connects again.
+func (s *socketSink) drop(conn net.Conn) {
+	s.mu.Lock()
+	if s.conn == conn {
+		s.conn = nil
+	}
+	s.mu.Unlock()
+
+	conn.Close()
+}
+
+func (s *socketSink) Close() error {
+	s.mu.Lock()
+	conn := s.conn
+	s.conn = nil
+	s.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
@@ -0,0 +1,222 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/premkit/premkit/events"
+	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/metrics"
+	"github.com/premkit/premkit/models"
+)
+
+// unhealthyPeriod is how long an endpoint is skipped after a request to it failed.
+const unhealthyPeriod = 10 * time.Second
+
+// ErrNoEndpoints is returned when none of the upstreams have an endpoint to forward to.
+var ErrNoEndpoints = errors.New("No upstreams are available")
+
+// Target is the endpoint chosen for a request.
+type Target struct {
+	Service  string
+	Upstream *models.Upstream
+
+	// Endpoint is the url to forward to.  This is the upstream url, unless the upstream was
+	// resolved into separate endpoints.
+	Endpoint string
+}
+
+// endpointKey identifies the health of an endpoint of an upstream of a service, like the labels of
+// the upstream health metric, so services that share an endpoint do not affect each other.
+type endpointKey struct {
+	service  string
+	upstream string
+	endpoint string
+}
+
+func (t *Target) key() endpointKey {
+	return endpointKey{service: t.Service, upstream: t.Upstream.URL, endpoint: t.Endpoint}
+}
+
+// Balancer spreads requests for a service over the endpoints of its upstreams, skipping endpoints
+// that recently failed.
+type Balancer struct {
+	resolver        *Resolver
+	events          *events.Bus
+	unhealthyPeriod time.Duration
+
+	mu        sync.Mutex
+	next      map[string]int
+	unhealthy map[endpointKey]*unhealthyEndpoint
+}
+
+// unhealthyEndpoint is an endpoint that is skipped until a request to it succeeds, or until the
+// time passes.  timer marks it healthy again once it does.
+type unhealthyEndpoint struct {
+	target *Target
+	until  time.Time
+	timer  *time.Timer
+}
+
+// New creates a balancer that expands upstreams into endpoints with resolver, and publishes the
+// health changes of endpoints on bus, which is the events bus of the registry.
+func New(resolver *Resolver, bus *events.Bus) *Balancer {
+	return &Balancer{
+		resolver:        resolver,
+		events:          bus,
+		unhealthyPeriod: unhealthyPeriod,
+		next:            make(map[string]int),
+		unhealthy:       make(map[endpointKey]*unhealthyEndpoint),
+	}
+}
+
+// Pick chooses the endpoint for the next request to the service, in round robin order.  Endpoints
+// that are unhealthy are only chosen when every endpoint is unhealthy.
+func (b *Balancer) Pick(service *models.Service, upstreams []*models.Upstream) (*Target, error) {
+	targets := make([]*Target, 0, 0)
+	for _, upstream := range upstreams {
+		endpoints, err := b.resolver.Endpoints(service.Name, upstream)
+		if err != nil {
+			log.Errorf("Failed to resolve upstream %q: %v", upstream.URL, err)
+			continue
+		}
+
+		for _, endpoint := range endpoints {
+			targets = append(targets, &Target{
+				Service:  service.Name,
+				Upstream: upstream,
+				Endpoint: endpoint,
+			})
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, ErrNoEndpoints
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	start := b.next[service.Name]
+	b.next[service.Name] = start + 1
+
+	now := time.Now()
+	for i := range targets {
+		target := targets[(start+i)%len(targets)]
+		if entry, ok := b.unhealthy[target.key()]; ok && now.Before(entry.until) {
+			continue
+		}
+		return target, nil
+	}
+
+	// Everything is unhealthy, so try anyway rather than failing the request
+	return targets[start%len(targets)], nil
+}
+
+// Report records whether a request to the target succeeded.  A failed request marks the endpoint
+// unhealthy for a while, and a successful one marks it healthy again.
+func (b *Balancer) Report(target *Target, ok bool) {
+	key := target.key()
+
+	b.mu.Lock()
+	entry, wasUnhealthy := b.unhealthy[key]
+	switch {
+	case ok && wasUnhealthy:
+		entry.timer.Stop()
+		delete(b.unhealthy, key)
+
+	case !ok && wasUnhealthy:
+		entry.until = time.Now().Add(b.unhealthyPeriod)
+
+	case !ok:
+		entry = &unhealthyEndpoint{target: target, until: time.Now().Add(b.unhealthyPeriod)}
+		entry.timer = time.AfterFunc(b.unhealthyPeriod, func() {
+			b.recover(key, entry)
+		})
+		b.unhealthy[key] = entry
+	}
+	b.mu.Unlock()
+
+	metrics.SetUpstreamHealth(target.Service, target.Upstream.URL, target.Endpoint, ok)
+
+	if wasUnhealthy == !ok {
+		return
+	}
+
+	b.healthChanged(target, ok)
+}
+
+// recover marks the endpoint healthy again once the unhealthy period since its last failure has
+// passed.
+func (b *Balancer) recover(key endpointKey, entry *unhealthyEndpoint) {
+	b.mu.Lock()
+	if b.unhealthy[key] != entry {
+		b.mu.Unlock()
+		return
+	}
+	if remaining := time.Until(entry.until); remaining > 0 {
+		entry.timer.Reset(remaining)
+		b.mu.Unlock()
+		return
+	}
+	delete(b.unhealthy, key)
+	b.mu.Unlock()
+
+	b.healthChanged(entry.target, true)
+}
+
+func (b *Balancer) healthChanged(target *Target, healthy bool) {
+	if healthy {
+		log.Infof("Endpoint %q of service %q is healthy", target.Endpoint, target.Service)
+	} else {
+		log.Warningf("Endpoint %q of service %q is unhealthy", target.Endpoint, target.Service)
+	}
+
+	b.events.Publish(&events.Event{
+		Type:     events.HealthChanged,
+		Service:  target.Service,
+		Upstream: target.Endpoint,
+		Healthy:  &healthy,
+	})
+}
+
+// Healthy returns false if a request to the endpoint of the target recently failed.
+func (b *Balancer) Healthy(target *Target) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry, ok := b.unhealthy[target.key()]
+	return !ok || time.Now().After(entry.until)
+}
+
+// Forget removes what the balancer knows of service, or only of its upstream with url when url is
+// not empty, once it is removed from the registry.
+func (b *Balancer) Forget(service string, url string) {
+	b.mu.Lock()
+	if url == "" {
+		delete(b.next, service)
+	}
+	for key, entry := range b.unhealthy {
+		if key.service == service && (url == "" || key.upstream == url) {
+			entry.timer.Stop()
+			delete(b.unhealthy, key)
+		}
+	}
+	b.mu.Unlock()
+
+	b.resolver.Forget(service, url)
+}
+
+// Run forgets services and upstreams as they are removed from the registry, until ctx is done.
+func (b *Balancer) Run(ctx context.Context) {
+	b.events.Watch(ctx, func(event *events.Event) {
+		switch event.Type {
+		case events.ServiceRemoved:
+			b.Forget(event.Service, "")
+		case events.UpstreamRemoved:
+			b.Forget(event.Service, event.Upstream)
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/premkit/premkit/events"
+	"github.com/premkit/premkit/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPickRoundRobin(t *testing.T) {
+	b := New(NewResolver(nil), events.NewBus())
+
+	service := &models.Service{Name: "service"}
+	upstreams := []*models.Upstream{
+		&models.Upstream{URL: "http://a"},
+		&models.Upstream{URL: "http://b"},
+	}
+
+	picked := make([]string, 0, 0)
+	for i := 0; i < 4; i++ {
+		target, err := b.Pick(service, upstreams)
+		require.NoError(t, err)
+		picked = append(picked, target.Endpoint)
+	}
+
+	assert.Equal(t, []string{"http://a", "http://b", "http://a", "http://b"}, picked)
+}
+
+func TestPickSkipsUnhealthy(t *testing.T) {
+	b := New(NewResolver(nil), events.NewBus())
+
+	service := &models.Service{Name: "service"}
+	upstreams := []*models.Upstream{
+		&models.Upstream{URL: "http://a"},
+		&models.Upstream{URL: "http://b"},
+	}
+
+	ch, cancel := b.events.Subscribe()
+	defer cancel()
+
+	a := &Target{Service: "service", Upstream: upstreams[0], Endpoint: "http://a"}
+	b.Report(a, false)
+	assert.False(t, b.Healthy(a))
+
+	event := <-ch
+	assert.Equal(t, events.HealthChanged, event.Type)
+	assert.Equal(t, "http://a", event.Upstream)
+	require.NotNil(t, event.Healthy)
+	assert.False(t, *event.Healthy)
+
+	for i := 0; i < 3; i++ {
+		target, err := b.Pick(service, upstreams)
+		require.NoError(t, err)
+		assert.Equal(t, "http://b", target.Endpoint)
+	}
+
+	// When everything is unhealthy, requests are still sent somewhere
+	b.Report(&Target{Service: "service", Upstream: upstreams[1], Endpoint: "http://b"}, false)
+	target, err := b.Pick(service, upstreams)
+	require.NoError(t, err)
+	assert.NotNil(t, target)
+
+	b.Report(a, true)
+	assert.True(t, b.Healthy(a))
+}
+
+func TestHealthyAfterUnhealthyPeriod(t *testing.T) {
+	b := New(NewResolver(nil), events.NewBus())
+	b.unhealthyPeriod = 20 * time.Millisecond
+
+	ch, cancel := b.events.Subscribe()
+	defer cancel()
+
+	target := &Target{Service: "recovering", Upstream: &models.Upstream{URL: "http://a"}, Endpoint: "http://a"}
+	b.Report(target, false)
+	b.Report(target, false)
+
+	event := <-ch
+	require.NotNil(t, event.Healthy)
+	assert.False(t, *event.Healthy)
+
+	// The endpoint is healthy again once the period since the last failure has passed
+	event = <-ch
+	assert.Equal(t, events.HealthChanged, event.Type)
+	assert.Equal(t, "recovering", event.Service)
+	require.NotNil(t, event.Healthy)
+	assert.True(t, *event.Healthy)
+	assert.True(t, b.Healthy(target))
+}
+
+func TestForget(t *testing.T) {
+	b := New(NewResolver(nil), events.NewBus())
+
+	service := &models.Service{Name: "removed"}
+	upstreams := []*models.Upstream{&models.Upstream{URL: "http://a"}, &models.Upstream{URL: "http://b"}}
+	_, err := b.Pick(service, upstreams)
+	require.NoError(t, err)
+	a := &Target{Service: "removed", Upstream: upstreams[0], Endpoint: "http://a"}
+	b.Report(a, false)
+	bTarget := &Target{Service: "removed", Upstream: upstreams[1], Endpoint: "http://b"}
+	b.Report(bTarget, false)
+
+	// Another service with the same endpoint is not forgotten with it
+	other := &Target{Service: "kept", Upstream: upstreams[0], Endpoint: "http://a"}
+	b.Report(other, false)
+
+	b.Forget("removed", "http://a")
+	assert.True(t, b.Healthy(a))
+	assert.False(t, b.Healthy(bTarget))
+	assert.False(t, b.Healthy(other))
+
+	b.Forget("removed", "")
+	assert.True(t, b.Healthy(bTarget))
+	assert.False(t, b.Healthy(other))
+	b.Forget("kept", "")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	assert.Empty(t, b.next)
+	assert.Empty(t, b.unhealthy)
+}
+
+func TestSharedEndpoint(t *testing.T) {
+	b := New(NewResolver(nil), events.NewBus())
+
+	// Two services forward to the same endpoint, and only one of them fails
+	upstream := &models.Upstream{URL: "http://shared"}
+	failing := &Target{Service: "failing", Upstream: upstream, Endpoint: "http://shared"}
+	healthy := &Target{Service: "healthy", Upstream: upstream, Endpoint: "http://shared"}
+
+	b.Report(failing, false)
+	assert.False(t, b.Healthy(failing))
+	assert.True(t, b.Healthy(healthy))
+
+	b.Forget("failing", "")
+}
+
+func TestPickNoEndpoints(t *testing.T) {
+	b := New(NewResolver(nil), events.NewBus())
+
+	_, err := b.Pick(&models.Service{Name: "service"}, nil)
+	assert.Equal(t, ErrNoEndpoints, err)
+}
@@ -0,0 +1,208 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/models"
+)
+
+const (
+	// defaultResolveInterval is how often upstreams are re-resolved when they do not set
+	// ResolveInterval.
+	defaultResolveInterval = 30 * time.Second
+
+	// resolveTimeout limits how long a single resolution can take.
+	resolveTimeout = 5 * time.Second
+)
+
+// Resolver expands upstreams that set Resolve into the individual endpoints their hostname
+// resolves to.  Endpoints are cached for each service, and re-resolved in the background once
+// they are older than the resolve interval of the upstream.
+type Resolver struct {
+	resolver *net.Resolver
+
+	mu      sync.Mutex
+	entries map[resolverKey]*resolverEntry
+}
+
+// resolverKey identifies the endpoints of an upstream of a service, so they can be forgotten when
+// the service or upstream is removed.
+type resolverKey struct {
+	service string
+	resolve string
+	url     string
+}
+
+type resolverEntry struct {
+	endpoints []string
+	resolved  time.Time
+	resolving bool
+}
+
+// NewResolver creates a resolver that uses r for lookups, or the default resolver if r is nil.
+func NewResolver(r *net.Resolver) *Resolver {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+
+	return &Resolver{
+		resolver: r,
+		entries:  make(map[resolverKey]*resolverEntry),
+	}
+}
+
+// Endpoints returns the endpoint urls of the upstream of service.  Upstreams that do not set
+// Resolve have a single endpoint, the upstream url.
+func (r *Resolver) Endpoints(service string, upstream *models.Upstream) ([]string, error) {
+	if upstream.Resolve == "" {
+		return []string{upstream.URL}, nil
+	}
+
+	key := resolverKey{service: service, resolve: upstream.Resolve, url: upstream.URL}
+	interval := defaultResolveInterval
+	if upstream.ResolveInterval > 0 {
+		interval = time.Duration(upstream.ResolveInterval) * time.Second
+	}
+
+	r.mu.Lock()
+	entry, ok := r.entries[key]
+	if ok {
+		if !entry.resolving && time.Since(entry.resolved) > interval {
+			entry.resolving = true
+			go r.refresh(key, upstream)
+		}
+		endpoints := entry.endpoints
+		r.mu.Unlock()
+		return endpoints, nil
+	}
+	r.mu.Unlock()
+
+	// The first request for an upstream has to wait for it to be resolved
+	endpoints, err := r.resolve(upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.entries[key] = &resolverEntry{
+		endpoints: endpoints,
+		resolved:  time.Now(),
+	}
+	r.mu.Unlock()
+
+	log.Infof("Upstream %q resolved to %v", upstream.URL, endpoints)
+	return endpoints, nil
+}
+
+// Forget removes the endpoints of the upstreams of service, or only of the upstream with url when
+// it is not empty.
+func (r *Resolver) Forget(service string, url string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key := range r.entries {
+		if key.service == service && (url == "" || key.url == url) {
+			delete(r.entries, key)
+		}
+	}
+}
+
+func (r *Resolver) refresh(key resolverKey, upstream *models.Upstream) {
+	endpoints, err := r.resolve(upstream)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The upstream may have been removed while it was resolved
+	entry, ok := r.entries[key]
+	if !ok {
+		return
+	}
+	entry.resolving = false
+
+	if err != nil {
+		// Keep using the last known endpoints until the name resolves again
+		log.Errorf("Failed to re-resolve upstream %q: %v", upstream.URL, err)
+		return
+	}
+
+	if strings.Join(endpoints, ",") != strings.Join(entry.endpoints, ",") {
+		log.Infof("Upstream %q now resolves to %v (was %v)", upstream.URL, endpoints, entry.endpoints)
+	}
+	entry.endpoints = endpoints
+	entry.resolved = time.Now()
+}
+
+func (r *Resolver) resolve(upstream *models.Upstream) ([]string, error) {
+	u, err := url.Parse(upstream.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	hosts := make([]string, 0, 0)
+
+	switch upstream.Resolve {
+	case models.ResolveDNS:
+		addrs, err := r.resolver.LookupHost(ctx, u.Hostname())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, addr := range addrs {
+			if u.Port() == "" {
+				hosts = append(hosts, hostLiteral(addr))
+			} else {
+				hosts = append(hosts, net.JoinHostPort(addr, u.Port()))
+			}
+		}
+
+	case models.ResolveSRV:
+		_, records, err := r.resolver.LookupSRV(ctx, "", "", u.Hostname())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range records {
+			target := strings.TrimSuffix(record.Target, ".")
+			hosts = append(hosts, net.JoinHostPort(target, strconv.Itoa(int(record.Port))))
+		}
+
+	default:
+		return nil, fmt.Errorf("Unknown resolve mode %q", upstream.Resolve)
+	}
+
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("%s did not resolve to any addresses", u.Hostname())
+	}
+
+	sort.Strings(hosts)
+
+	endpoints := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		endpoint := *u
+		endpoint.Host = host
+		endpoints = append(endpoints, endpoint.String())
+	}
+
+	return endpoints, nil
+}
+
+// hostLiteral returns the address as it can be used as the host of a url.
+func hostLiteral(addr string) string {
+	if strings.Contains(addr, ":") {
+		return "[" + addr + "]"
+	}
+	return addr
+}
@@ -0,0 +1,191 @@
+package balancer
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/premkit/premkit/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsStub is a local DNS server that answers A and SRV questions from fixed records.
+type dnsStub struct {
+	conn net.PacketConn
+
+	mu  sync.Mutex
+	a   map[string][]net.IP
+	srv map[string][]net.SRV
+}
+
+func startDNSStub(t *testing.T) *dnsStub {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	stub := &dnsStub{
+		conn: conn,
+		a:    make(map[string][]net.IP),
+		srv:  make(map[string][]net.SRV),
+	}
+	go stub.serve()
+
+	return stub
+}
+
+func (s *dnsStub) setA(name string, ips ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.a[name] = nil
+	for _, ip := range ips {
+		s.a[name] = append(s.a[name], net.ParseIP(ip))
+	}
+}
+
+func (s *dnsStub) setSRV(name string, records ...net.SRV) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.srv[name] = records
+}
+
+func (s *dnsStub) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "udp", s.conn.LocalAddr().String())
+		},
+	}
+}
+
+func (s *dnsStub) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		var parser dnsmessage.Parser
+		header, err := parser.Start(buf[:n])
+		if err != nil {
+			continue
+		}
+		question, err := parser.Question()
+		if err != nil {
+			continue
+		}
+
+		builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: header.ID, Response: true, Authoritative: true})
+		builder.EnableCompression()
+		builder.StartQuestions()
+		builder.Question(question)
+		builder.StartAnswers()
+
+		name := strings.TrimSuffix(question.Name.String(), ".")
+		answer := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 1}
+
+		s.mu.Lock()
+		switch question.Type {
+		case dnsmessage.TypeA:
+			for _, ip := range s.a[name] {
+				var a [4]byte
+				copy(a[:], ip.To4())
+				builder.AResource(answer, dnsmessage.AResource{A: a})
+			}
+		case dnsmessage.TypeSRV:
+			for _, record := range s.srv[name] {
+				builder.SRVResource(answer, dnsmessage.SRVResource{
+					Priority: record.Priority,
+					Weight:   record.Weight,
+					Port:     record.Port,
+					Target:   dnsmessage.MustNewName(record.Target),
+				})
+			}
+		}
+		s.mu.Unlock()
+
+		msg, err := builder.Finish()
+		if err != nil {
+			continue
+		}
+		s.conn.WriteTo(msg, addr)
+	}
+}
+
+func TestResolverNoResolve(t *testing.T) {
+	resolver := NewResolver(nil)
+
+	endpoints, err := resolver.Endpoints("service", &models.Upstream{URL: "http://localhost:3000"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://localhost:3000"}, endpoints)
+}
+
+func TestResolverDNS(t *testing.T) {
+	stub := startDNSStub(t)
+	stub.setA("ui.local", "10.0.0.2", "10.0.0.1")
+
+	resolver := NewResolver(stub.resolver())
+	upstream := &models.Upstream{URL: "http://ui.local:3000/base", Resolve: models.ResolveDNS}
+
+	endpoints, err := resolver.Endpoints("service", upstream)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://10.0.0.1:3000/base", "http://10.0.0.2:3000/base"}, endpoints)
+
+	_, err = resolver.Endpoints("service", &models.Upstream{URL: "http://missing.local", Resolve: models.ResolveDNS})
+	assert.Error(t, err)
+}
+
+func TestResolverSRV(t *testing.T) {
+	stub := startDNSStub(t)
+	stub.setSRV("_http._tcp.ui.local",
+		net.SRV{Target: "ui-1.local.", Port: 3001, Priority: 1, Weight: 1},
+		net.SRV{Target: "ui-2.local.", Port: 3002, Priority: 1, Weight: 1},
+	)
+
+	resolver := NewResolver(stub.resolver())
+	upstream := &models.Upstream{URL: "http://_http._tcp.ui.local", Resolve: models.ResolveSRV}
+
+	endpoints, err := resolver.Endpoints("service", upstream)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://ui-1.local:3001", "http://ui-2.local:3002"}, endpoints)
+}
+
+func TestResolverRefresh(t *testing.T) {
+	stub := startDNSStub(t)
+	stub.setA("ui.local", "10.0.0.1")
+
+	resolver := NewResolver(stub.resolver())
+	upstream := &models.Upstream{URL: "http://ui.local", Resolve: models.ResolveDNS, ResolveInterval: 1}
+
+	endpoints, err := resolver.Endpoints("service", upstream)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://10.0.0.1"}, endpoints)
+
+	stub.setA("ui.local", "10.0.0.1", "10.0.0.3")
+
+	// Make the cached entry stale, and refresh it the way a request would
+	key := resolverKey{service: "service", resolve: upstream.Resolve, url: upstream.URL}
+	resolver.mu.Lock()
+	resolver.entries[key].resolving = true
+	resolver.entries[key].resolved = resolver.entries[key].resolved.Add(-2 * defaultResolveInterval)
+	resolver.mu.Unlock()
+	resolver.refresh(key, upstream)
+
+	endpoints, err = resolver.Endpoints("service", upstream)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://10.0.0.1", "http://10.0.0.3"}, endpoints)
+
+	// A refresh of an upstream that was removed meanwhile is dropped
+	resolver.Forget("service", upstream.URL)
+	resolver.refresh(key, upstream)
+	resolver.mu.Lock()
+	assert.Empty(t, resolver.entries)
+	resolver.mu.Unlock()
+}
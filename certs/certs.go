@@ -154,3 +154,22 @@ func ParseKeyPair(keyFile string, certFile string) error {
 	}
 	return nil
 }
+
+// LoadCertPool reads the PEM certificates in file into a pool, to verify client certificates
+// with.  An error is returned if the file has no certificate.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		err := fmt.Errorf("No certificate found in %s", file)
+		log.Error(err)
+		return nil, err
+	}
+
+	return pool, nil
+}
@@ -109,3 +109,23 @@ func TestParseKeyPair(t *testing.T) {
 	err = ParseKeyPair(path.Join(dirName, "key"), path.Join(dirName, "cert"))
 	require.NoError(t, err)
 }
+
+func TestLoadCertPool(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "premkit-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dirName)
+
+	err = ioutil.WriteFile(path.Join(dirName, "ca"), []byte(testCert), 0644)
+	require.NoError(t, err)
+	pool, err := LoadCertPool(path.Join(dirName, "ca"))
+	require.NoError(t, err)
+	assert.NotNil(t, pool)
+
+	err = ioutil.WriteFile(path.Join(dirName, "empty"), []byte("not a certificate"), 0644)
+	require.NoError(t, err)
+	_, err = LoadCertPool(path.Join(dirName, "empty"))
+	assert.Error(t, err)
+
+	_, err = LoadCertPool(path.Join(dirName, "missing"))
+	assert.Error(t, err)
+}
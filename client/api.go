@@ -0,0 +1,33 @@
+package client
+
+import (
+	"github.com/premkit/premkit/models"
+)
+
+// The bodies of the /premkit/v1 requests and responses.  They are declared here, rather than
+// shared with the handlers, so services that use the client do not depend on the daemon.
+
+type registerServiceRequest struct {
+	Service         *models.Service `json:"service"`
+	ReplaceExisting bool            `json:"replace_existing"`
+}
+
+type addUpstreamRequest struct {
+	Upstream *models.Upstream `json:"upstream"`
+}
+
+type rollbackRequest struct {
+	Revision int `json:"revision"`
+}
+
+type serviceResponse struct {
+	Service *models.Service `json:"service"`
+}
+
+type servicesResponse struct {
+	Services []*models.Service `json:"services"`
+}
+
+type revisionsResponse struct {
+	Revisions []*models.Revision `json:"revisions"`
+}
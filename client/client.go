@@ -0,0 +1,381 @@
+// Package client is a Go client for the premkit /premkit/v1 API, for services that register
+// themselves with premkit.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/premkit/premkit/models"
+)
+
+const (
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+
+	// deregisterTimeout limits how long Run waits to deregister after its context is done.
+	deregisterTimeout = 5 * time.Second
+)
+
+// ErrNotFound is returned when the service or upstream is not registered.
+var ErrNotFound = errors.New("Service not found")
+
+// Error is returned when premkit answers a request with an error.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("premkit returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Client calls the API of a premkit daemon.  Requests are retried with exponential back-off,
+// between MinBackoff and MaxBackoff, until premkit is reachable or the context is done.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+
+	// Actor, when set, identifies who is making changes in the history of the services.
+	Actor string
+
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// New creates a client for the premkit daemon at address, such as http://localhost:80.  An error
+// is returned if address is not an http or https url, which would never be reachable.
+func New(address string) (*Client, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid premkit address %q: %v", address, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Invalid premkit address %q, expected a url such as http://localhost:80", address)
+	}
+
+	return &Client{
+		BaseURL:    strings.TrimSuffix(address, "/") + "/premkit/v1",
+		HTTPClient: http.DefaultClient,
+		MinBackoff: defaultMinBackoff,
+		MaxBackoff: defaultMaxBackoff,
+	}, nil
+}
+
+// Register registers the service, merging its upstreams into an existing service with the same
+// name unless replaceExisting is set.
+func (c *Client) Register(ctx context.Context, service *models.Service, replaceExisting bool) (*models.Service, error) {
+	params := registerServiceRequest{
+		Service:         service,
+		ReplaceExisting: replaceExisting,
+	}
+
+	registerServiceResponse := serviceResponse{}
+	if err := c.do(ctx, "POST", "/service", params, &registerServiceResponse); err != nil {
+		return nil, err
+	}
+
+	return registerServiceResponse.Service, nil
+}
+
+// Renew renews the lease of the service, or only of the upstream when upstream is not empty.
+// ErrNotFound is returned if the lease already expired.
+func (c *Client) Renew(ctx context.Context, name string, upstream string) (*models.Service, error) {
+	heartbeatResponse := serviceResponse{}
+	if err := c.do(ctx, "PUT", servicePath(name, "/heartbeat", upstream), nil, &heartbeatResponse); err != nil {
+		return nil, err
+	}
+
+	return heartbeatResponse.Service, nil
+}
+
+// List returns all registered services.
+func (c *Client) List(ctx context.Context) ([]*models.Service, error) {
+	listServicesResponse := servicesResponse{}
+	if err := c.do(ctx, "GET", "/service", nil, &listServicesResponse); err != nil {
+		return nil, err
+	}
+
+	return listServicesResponse.Services, nil
+}
+
+// Get returns the service with the name, or nil if there is no such service.
+func (c *Client) Get(ctx context.Context, name string) (*models.Service, error) {
+	getServiceResponse := serviceResponse{}
+	err := c.do(ctx, "GET", servicePath(name, "", ""), nil, &getServiceResponse)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return getServiceResponse.Service, nil
+}
+
+// Deregister removes the service, or only the upstream when upstream is not empty.
+func (c *Client) Deregister(ctx context.Context, name string, upstream string) error {
+	return c.do(ctx, "DELETE", servicePath(name, "", upstream), nil, nil)
+}
+
+// Drain stops forwarding requests to the upstreams of the service, or only to the upstream when
+// upstream is not empty.  When undo is set, forwarding is resumed.
+func (c *Client) Drain(ctx context.Context, name string, upstream string, undo bool) (*models.Service, error) {
+	p := servicePath(name, "/drain", upstream)
+	if undo {
+		if upstream == "" {
+			p += "?undo=true"
+		} else {
+			p += "&undo=true"
+		}
+	}
+
+	drainResponse := serviceResponse{}
+	if err := c.do(ctx, "PUT", p, nil, &drainResponse); err != nil {
+		return nil, err
+	}
+
+	return drainResponse.Service, nil
+}
+
+// AddUpstream adds an upstream to an existing service, without changing the rest of the service.
+func (c *Client) AddUpstream(ctx context.Context, name string, upstream *models.Upstream) (*models.Service, error) {
+	params := addUpstreamRequest{
+		Upstream: upstream,
+	}
+
+	addUpstreamResponse := serviceResponse{}
+	if err := c.do(ctx, "POST", servicePath(name, "/upstream", ""), params, &addUpstreamResponse); err != nil {
+		return nil, err
+	}
+
+	return addUpstreamResponse.Service, nil
+}
+
+// History returns the revisions of the service, oldest first.
+func (c *Client) History(ctx context.Context, name string) ([]*models.Revision, error) {
+	listRevisionsResponse := revisionsResponse{}
+	if err := c.do(ctx, "GET", servicePath(name, "/history", ""), nil, &listRevisionsResponse); err != nil {
+		return nil, err
+	}
+
+	return listRevisionsResponse.Revisions, nil
+}
+
+// Rollback returns the service to its state after the revision.  The returned service is nil if
+// the revision deleted the service.  ErrNotFound is returned if there is no such revision.
+func (c *Client) Rollback(ctx context.Context, name string, revision int) (*models.Service, error) {
+	params := rollbackRequest{
+		Revision: revision,
+	}
+
+	rollbackResponse := serviceResponse{}
+	if err := c.do(ctx, "POST", servicePath(name, "/rollback", ""), params, &rollbackResponse); err != nil {
+		return nil, err
+	}
+
+	return rollbackResponse.Service, nil
+}
+
+// Run registers the service and keeps its leases renewed until the context is done, and then
+// deregisters its upstreams, or the whole service if it has none.  The service is registered
+// again if its lease expires.
+func (c *Client) Run(ctx context.Context, service *models.Service) error {
+	if _, err := c.Register(ctx, service, false); err != nil {
+		return err
+	}
+
+	var ticks <-chan time.Time
+	if interval := renewInterval(service); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			deregisterCtx, cancel := context.WithTimeout(context.Background(), deregisterTimeout)
+			defer cancel()
+			return c.deregisterAll(deregisterCtx, service)
+
+		case <-ticks:
+			err := c.renewAll(ctx, service)
+			if err == ErrNotFound {
+				_, err = c.Register(ctx, service, false)
+			}
+			if err != nil && ctx.Err() == nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *Client) renewAll(ctx context.Context, service *models.Service) error {
+	if service.TTL > 0 {
+		if _, err := c.Renew(ctx, service.Name, ""); err != nil {
+			return err
+		}
+	}
+
+	for _, upstream := range service.Upstreams {
+		if upstream.TTL > 0 {
+			if _, err := c.Renew(ctx, service.Name, upstream.URL); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) deregisterAll(ctx context.Context, service *models.Service) error {
+	if len(service.Upstreams) == 0 {
+		err := c.Deregister(ctx, service.Name, "")
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	for _, upstream := range service.Upstreams {
+		if err := c.Deregister(ctx, service.Name, upstream.URL); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// renewInterval returns how often the leases of the service should be renewed, which is a third
+// of the shortest TTL, or zero if the service has no leases.
+func renewInterval(service *models.Service) time.Duration {
+	ttl := service.TTL
+	for _, upstream := range service.Upstreams {
+		if upstream.TTL > 0 && (ttl == 0 || upstream.TTL < ttl) {
+			ttl = upstream.TTL
+		}
+	}
+
+	return time.Duration(ttl) * time.Second / 3
+}
+
+func servicePath(name string, suffix string, upstream string) string {
+	p := "/service/" + url.PathEscape(name) + suffix
+	if upstream != "" {
+		p += "?upstream=" + url.QueryEscape(upstream)
+	}
+	return p
+}
+
+// do sends the request, retrying while premkit is unreachable or unavailable, and decodes the
+// response into out.
+func (c *Client) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	var body []byte
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+
+	backoff := c.MinBackoff
+	for {
+		err := c.doOnce(ctx, method, path, body, out)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !retryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+	}
+}
+
+func (c *Client) doOnce(ctx context.Context, method string, path string, body []byte, out interface{}) error {
+	request, err := http.NewRequest(method, c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(ctx)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if c.Actor != "" {
+		request.Header.Set(models.ActorHeader, c.Actor)
+	}
+
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return &Error{StatusCode: response.StatusCode, Message: strings.TrimSpace(string(b))}
+	}
+
+	if out == nil || len(b) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(b, out)
+}
+
+// retryable returns true for network errors reaching premkit, and responses from a proxy in
+// front of premkit that is not ready yet.  Errors from premkit itself, and requests that can
+// never be sent, such as to an unsupported scheme, are not retried.
+func retryable(err error) bool {
+	switch e := err.(type) {
+	case *Error:
+		switch e.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+
+	case *url.Error:
+		// Context errors are net.Errors too, but mean the caller gave up
+		if errors.Is(e.Err, context.Canceled) || errors.Is(e.Err, context.DeadlineExceeded) {
+			return false
+		}
+
+		// The connection was closed, for example while premkit restarted
+		if errors.Is(e.Err, io.EOF) || errors.Is(e.Err, io.ErrUnexpectedEOF) {
+			return true
+		}
+
+		var netErr net.Error
+		return errors.As(e.Err, &netErr)
+	}
+
+	return false
+}
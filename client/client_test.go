@@ -0,0 +1,232 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "github.com/premkit/premkit/handlers/v1"
+	"github.com/premkit/premkit/models"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setup(t *testing.T) (*httptest.Server, *models.Registry) {
+	registry := models.NewRegistry(models.NewMemoryStore())
+	api := v1.NewAPI(registry, nil)
+
+	router := mux.NewRouter()
+	internalV1 := router.PathPrefix("/premkit/v1").Subrouter()
+	internalV1.HandleFunc("/service", api.RegisterService).Methods("POST")
+	internalV1.HandleFunc("/service", api.ListServices).Methods("GET")
+	internalV1.HandleFunc("/service/{name}", api.GetService).Methods("GET")
+	internalV1.HandleFunc("/service/{name}", api.DeregisterService).Methods("DELETE")
+	internalV1.HandleFunc("/service/{name}/heartbeat", api.Heartbeat).Methods("PUT")
+	internalV1.HandleFunc("/service/{name}/drain", api.Drain).Methods("PUT")
+	internalV1.HandleFunc("/service/{name}/upstream", api.AddUpstream).Methods("POST")
+	internalV1.HandleFunc("/service/{name}/history", api.ListRevisions).Methods("GET")
+	internalV1.HandleFunc("/service/{name}/rollback", api.Rollback).Methods("POST")
+
+	return httptest.NewServer(router), registry
+}
+
+func teardown(server *httptest.Server) {
+	server.Close()
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	server, _ := setup(t)
+	defer teardown(server)
+
+	c, err := New(server.URL)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	service, err := c.Register(ctx, &models.Service{
+		Name: "test",
+		Path: "test",
+		TTL:  30,
+		Upstreams: []*models.Upstream{
+			&models.Upstream{URL: "http://localhost:3000"},
+		},
+	}, false)
+	require.NoError(t, err)
+	assert.Equal(t, "test", service.Name)
+
+	services, err := c.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(services))
+
+	service, err = c.Get(ctx, "test")
+	require.NoError(t, err)
+	require.NotNil(t, service)
+	assert.Equal(t, "http://localhost:3000", service.Upstreams[0].URL)
+
+	_, err = c.Renew(ctx, "test", "")
+	require.NoError(t, err)
+
+	_, err = c.Renew(ctx, "missing", "")
+	assert.Equal(t, ErrNotFound, err)
+
+	require.NoError(t, c.Deregister(ctx, "test", ""))
+
+	service, err = c.Get(ctx, "test")
+	require.NoError(t, err)
+	assert.Nil(t, service)
+
+	_, err = c.Register(ctx, &models.Service{Name: "invalid", Kind: "unknown"}, false)
+	apiErr, ok := err.(*Error)
+	require.True(t, ok, "validation errors should not be retried")
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+}
+
+func TestClientHistory(t *testing.T) {
+	t.Parallel()
+
+	server, _ := setup(t)
+	defer teardown(server)
+
+	c, err := New(server.URL)
+	require.NoError(t, err)
+	c.Actor = "alice"
+	ctx := context.Background()
+
+	for _, path := range []string{"v1", "v2"} {
+		_, err := c.Register(ctx, &models.Service{Name: "test", Path: path}, true)
+		require.NoError(t, err)
+	}
+
+	revisions, err := c.History(ctx, "test")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(revisions))
+	assert.Contains(t, revisions[0].Actor, "alice")
+
+	service, err := c.Rollback(ctx, "test", 1)
+	require.NoError(t, err)
+	require.NotNil(t, service)
+	assert.Equal(t, "v1", service.Path)
+
+	_, err = c.Rollback(ctx, "test", 10)
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = c.History(ctx, "missing")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestClientRetries(t *testing.T) {
+	t.Parallel()
+
+	server, _ := setup(t)
+	defer teardown(server)
+
+	// A proxy that is unavailable for the first requests
+	var requests int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			http.Error(response, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		server.Config.Handler.ServeHTTP(response, request)
+	}))
+	defer proxy.Close()
+
+	c, err := New(proxy.URL)
+	require.NoError(t, err)
+	c.MinBackoff = time.Millisecond
+
+	services, err := c.List(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(services))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	// An address that is never reachable is retried until the context is done
+	unreachable, err := New("http://127.0.0.1:1")
+	require.NoError(t, err)
+	unreachable.MinBackoff = time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = unreachable.List(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestClientInvalidAddress(t *testing.T) {
+	t.Parallel()
+
+	for _, address := range []string{"localhost:2080", "", "ftp://localhost", "http://"} {
+		_, err := New(address)
+		assert.Error(t, err, address)
+	}
+
+	// Requests that can never be sent fail without being retried
+	c := &Client{BaseURL: "ftp://localhost/premkit/v1", HTTPClient: http.DefaultClient, MinBackoff: time.Hour}
+	_, err := c.List(context.Background())
+	assert.Error(t, err)
+}
+
+func TestClientRun(t *testing.T) {
+	t.Parallel()
+
+	server, registry := setup(t)
+	defer teardown(server)
+
+	_, err := registry.CreateService(&models.Service{
+		Name: "test",
+		Path: "test",
+		Upstreams: []*models.Upstream{
+			&models.Upstream{URL: "http://localhost:3001"},
+		},
+	})
+	require.NoError(t, err)
+
+	c, err := New(server.URL)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx, &models.Service{
+			Name: "test",
+			Path: "test",
+			Upstreams: []*models.Upstream{
+				&models.Upstream{URL: "http://localhost:3000", TTL: 30},
+			},
+		})
+	}()
+
+	require.Eventually(t, func() bool {
+		service, err := c.Get(context.Background(), "test")
+		return err == nil && service != nil && len(service.Upstreams) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	// Only the upstream registered by this client is removed
+	service, err := c.Get(context.Background(), "test")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(service.Upstreams))
+	assert.Equal(t, "http://localhost:3001", service.Upstreams[0].URL)
+}
+
+func TestRenewInterval(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, time.Duration(0), renewInterval(&models.Service{}))
+	assert.Equal(t, 10*time.Second, renewInterval(&models.Service{TTL: 30}))
+	assert.Equal(t, 5*time.Second, renewInterval(&models.Service{
+		TTL: 30,
+		Upstreams: []*models.Upstream{
+			&models.Upstream{TTL: 15},
+			&models.Upstream{},
+		},
+	}))
+}
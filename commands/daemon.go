@@ -2,7 +2,9 @@ package commands
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/premkit/premkit/accesslog"
 	"github.com/premkit/premkit/certs"
 	"github.com/premkit/premkit/log"
 	"github.com/premkit/premkit/server"
@@ -15,10 +17,38 @@ import (
 const (
 	defaultHTTPPort               = 2080
 	defaultHTTPSPort              = 2443
+	defaultAdminPort              = 0
 	defaultTLSKeyFile             = ""
 	defaultTLSCertFile            = ""
+	defaultTLSClientCAFile        = ""
 	defaultGenerateSelfSignedCert = true
+	defaultHTTPSRedirect          = false
+	defaultHTTPSRedirectPort      = 0
+	defaultHTTPSRedirectExclude   = ""
+	defaultHSTSMaxAge             = 0
+	defaultServicesFile           = ""
+	defaultDockerSocket           = ""
+	defaultKubernetes             = false
+	defaultKubernetesNamespace    = ""
+	defaultAccessLog              = ""
+	defaultAccessLogFormat        = accesslog.FormatCombined
+	defaultAccessLogTemplate      = ""
+	defaultAccessLogMaxSize       = 100
+	defaultAccessLogMaxBackups    = 5
+	defaultOTLPEndpoint           = ""
+	defaultTraceSampleRatio       = 1.0
+	defaultAuditLog               = ""
+	defaultAuditLogMaxSize        = 100
+	defaultAuditLogMaxBackups     = 5
+	defaultWebhookURLs            = ""
+	defaultWebhookSecret          = ""
+	defaultWebhookEvents          = ""
+	defaultWebhookDeadLetter      = ""
+	defaultRequestIDTrusted       = ""
+	defaultHistoryMaxRevisions    = 100
+	defaultHistoryMaxAge          = 30
 
+	defaultStore    = server.StoreBolt
 	defaultDataFile = "/data/premkit.db"
 	defaultTLSStore = "/data/tls"
 )
@@ -39,19 +69,75 @@ func init() {
 
 	daemonCmd.Flags().Int("bind-http", defaultHTTPPort, "port on which the reverse proxy will bind and listen for http connections")
 	daemonCmd.Flags().Int("bind-https", defaultHTTPSPort, "port on which the reverse proxy will bind and listen for https (tls) connections")
+	daemonCmd.Flags().Int("bind-admin", defaultAdminPort, "when not 0, port on which to listen for http connections to the admin api, /metrics and the database backup only")
 	daemonCmd.Flags().String("key-file", defaultTLSKeyFile, "path to private key to use when serving tls connections")
 	daemonCmd.Flags().String("cert-file", defaultTLSCertFile, "path to cert to use when serving tls connections")
+	daemonCmd.Flags().String("tls-client-ca", defaultTLSClientCAFile, "path to the pem certificates of the cas whose client certificates are verified, and audited as the caller of the admin api")
 	daemonCmd.Flags().Bool("self-signed", defaultGenerateSelfSignedCert, "true to have premkit generate a new self-signed keypair to use for tls connections")
+	daemonCmd.Flags().String("store", defaultStore, "where to keep registered services, bolt (in data-file) or memory (lost when premkit exits)")
 	daemonCmd.Flags().String("data-file", defaultDataFile, "location of the database file")
 	daemonCmd.Flags().String("tls-store", defaultTLSStore, "location to store generated tls certs and keys in")
+	daemonCmd.Flags().Bool("https-redirect", defaultHTTPSRedirect, "true to redirect http connections to the https port, except for acme challenges and excluded paths")
+	daemonCmd.Flags().Int("https-redirect-port", defaultHTTPSRedirectPort, "port to redirect http connections to, if different from bind-https")
+	daemonCmd.Flags().String("https-redirect-exclude", defaultHTTPSRedirectExclude, "comma separated list of service paths that will continue to be served over http when https-redirect is set")
+	daemonCmd.Flags().Int("hsts-max-age", defaultHSTSMaxAge, "when not 0, the max-age in seconds of the Strict-Transport-Security header added to https responses")
+	daemonCmd.Flags().String("services-file", defaultServicesFile, "path to a yaml or json file of services to register, and keep in sync when the file changes")
+	daemonCmd.Flags().String("docker-socket", defaultDockerSocket, "path to the docker engine socket (e.g. /var/run/docker.sock) to register containers with premkit labels as services")
+	daemonCmd.Flags().Bool("kubernetes", defaultKubernetes, "true to register kubernetes services with premkit annotations, using the in-cluster service account")
+	daemonCmd.Flags().String("kubernetes-namespace", defaultKubernetesNamespace, "namespace to discover kubernetes services in, or all namespaces if empty")
+	daemonCmd.Flags().String("access-log", defaultAccessLog, "stdout, or a file, to log every request to")
+	daemonCmd.Flags().String("access-log-format", defaultAccessLogFormat, "format of the access log: json, common, combined or template")
+	daemonCmd.Flags().String("access-log-template", defaultAccessLogTemplate, "go template of access log lines when the format is template (e.g. '{{.ClientIP}} {{.Service}} {{.Status}} {{.TotalLatency}}')")
+	daemonCmd.Flags().Int("access-log-max-size", defaultAccessLogMaxSize, "size in megabytes at which the access log file is rotated, or 0 to never rotate it")
+	daemonCmd.Flags().Int("access-log-max-backups", defaultAccessLogMaxBackups, "number of rotated access log files to keep")
+	daemonCmd.Flags().String("otlp-endpoint", defaultOTLPEndpoint, "url of an otlp/http collector (e.g. http://collector:4318) to export traces of proxied requests to")
+	daemonCmd.Flags().Float64("trace-sample-ratio", defaultTraceSampleRatio, "ratio, from 0 to 1, of new traces to export; traces sampled by the caller are always exported")
+	daemonCmd.Flags().String("audit-log", defaultAuditLog, "file, or unix socket as unix:/path, to write a json line to for every call to the admin api")
+	daemonCmd.Flags().Int("audit-log-max-size", defaultAuditLogMaxSize, "size in megabytes at which the audit log file is rotated, or 0 to never rotate it")
+	daemonCmd.Flags().Int("audit-log-max-backups", defaultAuditLogMaxBackups, "number of rotated audit log files to keep")
+	daemonCmd.Flags().String("webhook-urls", defaultWebhookURLs, "comma separated list of urls to post registry and health events to")
+	daemonCmd.Flags().String("webhook-secret", defaultWebhookSecret, "secret to sign webhook deliveries with, in the X-Premkit-Signature-256 header")
+	daemonCmd.Flags().String("webhook-events", defaultWebhookEvents, "comma separated list of event types to deliver to webhooks (e.g. service-added,service-removed,health-changed), or all events if empty")
+	daemonCmd.Flags().String("webhook-dead-letter", defaultWebhookDeadLetter, "file to write webhook deliveries that failed every attempt to")
+	daemonCmd.Flags().Int("history-max-revisions", defaultHistoryMaxRevisions, "number of revisions of each service to keep, or 0 to keep every revision")
+	daemonCmd.Flags().Int("history-max-age", defaultHistoryMaxAge, "days to keep the history of a deleted service, or 0 to keep it forever")
+	daemonCmd.Flags().String("request-id-trusted", defaultRequestIDTrusted, "comma separated list of addresses or cidrs (e.g. 10.0.0.0/8) whose X-Request-Id header and trace context are kept; other requests get a new id and trace")
 
 	viper.BindPFlag("bind_http", daemonCmd.Flags().Lookup("bind-http"))
 	viper.BindPFlag("bind_https", daemonCmd.Flags().Lookup("bind-https"))
+	viper.BindPFlag("bind_admin", daemonCmd.Flags().Lookup("bind-admin"))
 	viper.BindPFlag("key_file", daemonCmd.Flags().Lookup("key-file"))
 	viper.BindPFlag("cert_file", daemonCmd.Flags().Lookup("cert-file"))
+	viper.BindPFlag("tls_client_ca", daemonCmd.Flags().Lookup("tls-client-ca"))
 	viper.BindPFlag("self_signed", daemonCmd.Flags().Lookup("self-signed"))
+	viper.BindPFlag("store", daemonCmd.Flags().Lookup("store"))
 	viper.BindPFlag("data_file", daemonCmd.Flags().Lookup("data-file"))
 	viper.BindPFlag("tls_store", daemonCmd.Flags().Lookup("tls-store"))
+	viper.BindPFlag("https_redirect", daemonCmd.Flags().Lookup("https-redirect"))
+	viper.BindPFlag("https_redirect_port", daemonCmd.Flags().Lookup("https-redirect-port"))
+	viper.BindPFlag("https_redirect_exclude", daemonCmd.Flags().Lookup("https-redirect-exclude"))
+	viper.BindPFlag("hsts_max_age", daemonCmd.Flags().Lookup("hsts-max-age"))
+	viper.BindPFlag("services_file", daemonCmd.Flags().Lookup("services-file"))
+	viper.BindPFlag("docker_socket", daemonCmd.Flags().Lookup("docker-socket"))
+	viper.BindPFlag("kubernetes", daemonCmd.Flags().Lookup("kubernetes"))
+	viper.BindPFlag("kubernetes_namespace", daemonCmd.Flags().Lookup("kubernetes-namespace"))
+	viper.BindPFlag("access_log", daemonCmd.Flags().Lookup("access-log"))
+	viper.BindPFlag("access_log_format", daemonCmd.Flags().Lookup("access-log-format"))
+	viper.BindPFlag("access_log_template", daemonCmd.Flags().Lookup("access-log-template"))
+	viper.BindPFlag("access_log_max_size", daemonCmd.Flags().Lookup("access-log-max-size"))
+	viper.BindPFlag("access_log_max_backups", daemonCmd.Flags().Lookup("access-log-max-backups"))
+	viper.BindPFlag("otlp_endpoint", daemonCmd.Flags().Lookup("otlp-endpoint"))
+	viper.BindPFlag("trace_sample_ratio", daemonCmd.Flags().Lookup("trace-sample-ratio"))
+	viper.BindPFlag("audit_log", daemonCmd.Flags().Lookup("audit-log"))
+	viper.BindPFlag("audit_log_max_size", daemonCmd.Flags().Lookup("audit-log-max-size"))
+	viper.BindPFlag("audit_log_max_backups", daemonCmd.Flags().Lookup("audit-log-max-backups"))
+	viper.BindPFlag("webhook_urls", daemonCmd.Flags().Lookup("webhook-urls"))
+	viper.BindPFlag("webhook_secret", daemonCmd.Flags().Lookup("webhook-secret"))
+	viper.BindPFlag("webhook_events", daemonCmd.Flags().Lookup("webhook-events"))
+	viper.BindPFlag("webhook_dead_letter", daemonCmd.Flags().Lookup("webhook-dead-letter"))
+	viper.BindPFlag("history_max_revisions", daemonCmd.Flags().Lookup("history-max-revisions"))
+	viper.BindPFlag("history_max_age", daemonCmd.Flags().Lookup("history-max-age"))
+	viper.BindPFlag("request_id_trusted", daemonCmd.Flags().Lookup("request-id-trusted"))
 
 	daemonCmd.RunE = daemon
 }
@@ -100,14 +186,65 @@ func buildConfig() (*server.Config, error) {
 	config := server.Config{
 		HTTPPort:  viper.GetInt("bind_http"),
 		HTTPSPort: viper.GetInt("bind_https"),
+		AdminPort: viper.GetInt("bind_admin"),
 
 		TLSKeyFile:  keyFile,
 		TLSCertFile: certFile,
+
+		TLSClientCAFile: viper.GetString("tls_client_ca"),
+
+		HTTPSRedirect:        viper.GetBool("https_redirect"),
+		HTTPSRedirectPort:    viper.GetInt("https_redirect_port"),
+		HTTPSRedirectExclude: splitList(viper.GetString("https_redirect_exclude")),
+		HSTSMaxAge:           viper.GetInt("hsts_max_age"),
+
+		ServicesFile: viper.GetString("services_file"),
+		DockerSocket: viper.GetString("docker_socket"),
+
+		Kubernetes:          viper.GetBool("kubernetes"),
+		KubernetesNamespace: viper.GetString("kubernetes_namespace"),
+
+		AccessLog:           viper.GetString("access_log"),
+		AccessLogFormat:     viper.GetString("access_log_format"),
+		AccessLogTemplate:   viper.GetString("access_log_template"),
+		AccessLogMaxSize:    viper.GetInt("access_log_max_size"),
+		AccessLogMaxBackups: viper.GetInt("access_log_max_backups"),
+
+		OTLPEndpoint:     viper.GetString("otlp_endpoint"),
+		TraceSampleRatio: viper.GetFloat64("trace_sample_ratio"),
+
+		AuditLog:           viper.GetString("audit_log"),
+		AuditLogMaxSize:    viper.GetInt("audit_log_max_size"),
+		AuditLogMaxBackups: viper.GetInt("audit_log_max_backups"),
+
+		WebhookURLs:       splitList(viper.GetString("webhook_urls")),
+		WebhookSecret:     viper.GetString("webhook_secret"),
+		WebhookEvents:     splitList(viper.GetString("webhook_events")),
+		WebhookDeadLetter: viper.GetString("webhook_dead_letter"),
+
+		HistoryMaxRevisions: viper.GetInt("history_max_revisions"),
+		HistoryMaxAge:       viper.GetInt("history_max_age"),
+
+		RequestIDTrusted: splitList(viper.GetString("request_id_trusted")),
+
+		Store: viper.GetString("store"),
 	}
 
 	return &config, nil
 }
 
+// splitList splits a comma separated setting into its non-empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
 func showAppliedSettings() {
 	var nonDefault []string
 
@@ -117,6 +254,9 @@ func showAppliedSettings() {
 	if viper.GetInt("bind_https") != defaultHTTPSPort {
 		nonDefault = append(nonDefault, fmt.Sprintf("HTTPS Bind Port set to %d", viper.GetInt("bind_https")))
 	}
+	if viper.GetInt("bind_admin") != defaultAdminPort {
+		nonDefault = append(nonDefault, fmt.Sprintf("Admin Bind Port set to %d", viper.GetInt("bind_admin")))
+	}
 
 	if viper.GetString("key_file") != defaultTLSKeyFile {
 		nonDefault = append(nonDefault, fmt.Sprintf("TLS Key File set to %s", viper.GetString("key_file")))
@@ -124,16 +264,106 @@ func showAppliedSettings() {
 	if viper.GetString("cert_file") != defaultTLSCertFile {
 		nonDefault = append(nonDefault, fmt.Sprintf("TLS Cert File set to %s", viper.GetString("cert_file")))
 	}
+	if viper.GetString("tls_client_ca") != defaultTLSClientCAFile {
+		nonDefault = append(nonDefault, fmt.Sprintf("TLS Client CA set to %s", viper.GetString("tls_client_ca")))
+	}
 	if viper.GetBool("self_signed") != defaultGenerateSelfSignedCert {
 		nonDefault = append(nonDefault, fmt.Sprintf("Generate Self Signed Cert set to %v", viper.GetBool("self_signed")))
 	}
 
+	if viper.GetBool("https_redirect") != defaultHTTPSRedirect {
+		nonDefault = append(nonDefault, fmt.Sprintf("HTTPS Redirect set to %v", viper.GetBool("https_redirect")))
+	}
+	if viper.GetInt("https_redirect_port") != defaultHTTPSRedirectPort {
+		nonDefault = append(nonDefault, fmt.Sprintf("HTTPS Redirect Port set to %d", viper.GetInt("https_redirect_port")))
+	}
+	if viper.GetString("https_redirect_exclude") != defaultHTTPSRedirectExclude {
+		nonDefault = append(nonDefault, fmt.Sprintf("HTTPS Redirect Exclude set to %s", viper.GetString("https_redirect_exclude")))
+	}
+	if viper.GetInt("hsts_max_age") != defaultHSTSMaxAge {
+		nonDefault = append(nonDefault, fmt.Sprintf("HSTS Max Age set to %d", viper.GetInt("hsts_max_age")))
+	}
+
+	if viper.GetString("services_file") != defaultServicesFile {
+		nonDefault = append(nonDefault, fmt.Sprintf("Services File set to %s", viper.GetString("services_file")))
+	}
+
+	if viper.GetString("docker_socket") != defaultDockerSocket {
+		nonDefault = append(nonDefault, fmt.Sprintf("Docker Socket set to %s", viper.GetString("docker_socket")))
+	}
+
+	if viper.GetBool("kubernetes") != defaultKubernetes {
+		nonDefault = append(nonDefault, fmt.Sprintf("Kubernetes set to %v", viper.GetBool("kubernetes")))
+	}
+	if viper.GetString("kubernetes_namespace") != defaultKubernetesNamespace {
+		nonDefault = append(nonDefault, fmt.Sprintf("Kubernetes Namespace set to %s", viper.GetString("kubernetes_namespace")))
+	}
+
+	if viper.GetString("access_log") != defaultAccessLog {
+		nonDefault = append(nonDefault, fmt.Sprintf("Access Log set to %s", viper.GetString("access_log")))
+	}
+	if viper.GetString("access_log_format") != defaultAccessLogFormat {
+		nonDefault = append(nonDefault, fmt.Sprintf("Access Log Format set to %s", viper.GetString("access_log_format")))
+	}
+	if viper.GetString("access_log_template") != defaultAccessLogTemplate {
+		nonDefault = append(nonDefault, fmt.Sprintf("Access Log Template set to %s", viper.GetString("access_log_template")))
+	}
+	if viper.GetInt("access_log_max_size") != defaultAccessLogMaxSize {
+		nonDefault = append(nonDefault, fmt.Sprintf("Access Log Max Size set to %d", viper.GetInt("access_log_max_size")))
+	}
+	if viper.GetInt("access_log_max_backups") != defaultAccessLogMaxBackups {
+		nonDefault = append(nonDefault, fmt.Sprintf("Access Log Max Backups set to %d", viper.GetInt("access_log_max_backups")))
+	}
+
+	if viper.GetString("otlp_endpoint") != defaultOTLPEndpoint {
+		nonDefault = append(nonDefault, fmt.Sprintf("OTLP Endpoint set to %s", viper.GetString("otlp_endpoint")))
+	}
+	if viper.GetFloat64("trace_sample_ratio") != defaultTraceSampleRatio {
+		nonDefault = append(nonDefault, fmt.Sprintf("Trace Sample Ratio set to %v", viper.GetFloat64("trace_sample_ratio")))
+	}
+
+	if viper.GetString("audit_log") != defaultAuditLog {
+		nonDefault = append(nonDefault, fmt.Sprintf("Audit Log set to %s", viper.GetString("audit_log")))
+	}
+	if viper.GetInt("audit_log_max_size") != defaultAuditLogMaxSize {
+		nonDefault = append(nonDefault, fmt.Sprintf("Audit Log Max Size set to %d", viper.GetInt("audit_log_max_size")))
+	}
+	if viper.GetInt("audit_log_max_backups") != defaultAuditLogMaxBackups {
+		nonDefault = append(nonDefault, fmt.Sprintf("Audit Log Max Backups set to %d", viper.GetInt("audit_log_max_backups")))
+	}
+
+	if viper.GetString("webhook_urls") != defaultWebhookURLs {
+		nonDefault = append(nonDefault, fmt.Sprintf("Webhook URLs set to %s", viper.GetString("webhook_urls")))
+	}
+	if viper.GetString("webhook_secret") != defaultWebhookSecret {
+		// The secret itself is not logged
+		nonDefault = append(nonDefault, "Webhook Secret is set")
+	}
+	if viper.GetString("webhook_events") != defaultWebhookEvents {
+		nonDefault = append(nonDefault, fmt.Sprintf("Webhook Events set to %s", viper.GetString("webhook_events")))
+	}
+	if viper.GetString("webhook_dead_letter") != defaultWebhookDeadLetter {
+		nonDefault = append(nonDefault, fmt.Sprintf("Webhook Dead Letter set to %s", viper.GetString("webhook_dead_letter")))
+	}
+	if viper.GetString("request_id_trusted") != defaultRequestIDTrusted {
+		nonDefault = append(nonDefault, fmt.Sprintf("Request ID Trusted set to %s", viper.GetString("request_id_trusted")))
+	}
+
+	if viper.GetString("store") != defaultStore {
+		nonDefault = append(nonDefault, fmt.Sprintf("Store set to %s", viper.GetString("store")))
+	}
 	if viper.GetString("data_file") != defaultDataFile {
 		nonDefault = append(nonDefault, fmt.Sprintf("DataFile set to %s", viper.GetString("data_file")))
 	}
 	if viper.GetString("tls_store") != defaultTLSStore {
 		nonDefault = append(nonDefault, fmt.Sprintf("TLS Store set to %s", viper.GetString("tls_store")))
 	}
+	if viper.GetInt("history_max_revisions") != defaultHistoryMaxRevisions {
+		nonDefault = append(nonDefault, fmt.Sprintf("History Max Revisions set to %d", viper.GetInt("history_max_revisions")))
+	}
+	if viper.GetInt("history_max_age") != defaultHistoryMaxAge {
+		nonDefault = append(nonDefault, fmt.Sprintf("History Max Age set to %d", viper.GetInt("history_max_age")))
+	}
 
 	if len(nonDefault) == 0 {
 		log.Infof("Using default settings")
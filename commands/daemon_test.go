@@ -6,6 +6,7 @@ import (
 	"path"
 	"testing"
 
+	"github.com/premkit/premkit/accesslog"
 	"github.com/premkit/premkit/server"
 
 	"github.com/spf13/viper"
@@ -91,6 +92,19 @@ func TestBuildConfigFromFile(t *testing.T) {
 		HTTPSPort:   2443,
 		TLSKeyFile:  path.Join(dirName, "key"),
 		TLSCertFile: path.Join(dirName, "cert"),
+		Store:       server.StoreBolt,
+
+		AccessLogFormat:     accesslog.FormatCombined,
+		AccessLogMaxSize:    100,
+		AccessLogMaxBackups: 5,
+
+		TraceSampleRatio: 1,
+
+		AuditLogMaxSize:    100,
+		AuditLogMaxBackups: 5,
+
+		HistoryMaxRevisions: 100,
+		HistoryMaxAge:       30,
 	}
 	assert.Equal(t, expectedConfig, *config)
 }
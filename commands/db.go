@@ -0,0 +1,272 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/premkit/premkit/models"
+	"github.com/premkit/premkit/persistence"
+
+	"github.com/boltdb/bolt"
+	"github.com/spf13/cobra"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Inspect, back up and restore the database file of a stopped daemon",
+	Long: `The db commands work directly on the data file, and cannot be used while the daemon is
+running.  A running daemon can be backed up with a GET to /premkit/v1/backup on its admin port,
+which is only served when --bind-admin is set.`,
+}
+
+var dbDumpCmd = &cobra.Command{
+	Use:   "dump [FILE]",
+	Short: "Write every service and upstream to a json file, or to stdout",
+	RunE:  dbDump,
+}
+
+var dbRestoreCmd = &cobra.Command{
+	Use:   "restore FILE",
+	Short: "Replace every service and upstream with the ones in a json dump, or - for stdin",
+	RunE:  requireArgs(1, dbRestore),
+}
+
+var dbCompactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "Rewrite the data file without its free pages",
+	RunE:  requireArgs(0, dbCompact),
+}
+
+var dbCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the data file for corruption, invalid services and upstreams that need repair",
+	RunE:  requireArgs(0, dbCheck),
+}
+
+func init() {
+	dataFile := os.Getenv("PREMKIT_DATA_FILE")
+	if dataFile == "" {
+		dataFile = defaultDataFile
+	}
+	dbCmd.PersistentFlags().String("data-file", dataFile, "location of the database file")
+
+	dbCmd.AddCommand(dbDumpCmd)
+	dbCmd.AddCommand(dbRestoreCmd)
+	dbCmd.AddCommand(dbCompactCmd)
+	dbCmd.AddCommand(dbCheckCmd)
+}
+
+func openDataFile(cmd *cobra.Command) (string, *bolt.DB, error) {
+	dataFile, err := cmd.Flags().GetString("data-file")
+	if err != nil {
+		return "", nil, err
+	}
+
+	db, err := persistence.Open(dataFile)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return dataFile, db, nil
+}
+
+func dbDump(cmd *cobra.Command, args []string) error {
+	if len(args) > 1 {
+		cmd.Usage()
+		return fmt.Errorf("%q accepts at most 1 argument, received %d", cmd.CommandPath(), len(args))
+	}
+
+	_, db, err := openDataFile(cmd)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// The models only read the latest layout, so older data files are dumped from a migrated
+	// copy, and are left as they are
+	source, closeSource, err := migratedCopy(db)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	dump, err := models.NewRegistry(models.NewBoltStore(source, nil)).DumpServices()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	if len(args) == 0 {
+		_, err := stdout.Write(b)
+		return err
+	}
+
+	return ioutil.WriteFile(args[0], b, 0600)
+}
+
+// migratedCopy returns db when it has the latest schema version.  Otherwise it returns a copy of
+// db, in a temporary directory, migrated to the latest version.  The returned func closes and
+// removes the copy.
+func migratedCopy(db *bolt.DB) (*bolt.DB, func(), error) {
+	version, err := persistence.GetSchemaVersion(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version == persistence.SchemaVersion() {
+		return db, func() {}, nil
+	}
+
+	dirName, err := ioutil.TempDir("", "premkit-dump")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	copyPath := path.Join(dirName, "premkit.db")
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(copyPath, 0600)
+	})
+	if err != nil {
+		os.RemoveAll(dirName)
+		return nil, nil, err
+	}
+
+	migrated, err := bolt.Open(copyPath, 0600, nil)
+	if err != nil {
+		os.RemoveAll(dirName)
+		return nil, nil, err
+	}
+
+	closeCopy := func() {
+		migrated.Close()
+		os.RemoveAll(dirName)
+	}
+
+	if err := persistence.Migrate(migrated); err != nil {
+		closeCopy()
+		return nil, nil, err
+	}
+
+	return migrated, closeCopy, nil
+}
+
+func dbRestore(cmd *cobra.Command, args []string) error {
+	var b []byte
+	var err error
+	if args[0] == "-" {
+		b, err = ioutil.ReadAll(stdin)
+	} else {
+		b, err = ioutil.ReadFile(args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	dump := models.Dump{}
+	if err := json.Unmarshal(b, &dump); err != nil {
+		return err
+	}
+
+	_, db, err := openDataFile(cmd)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Restored services are written in the latest layout
+	if err := persistence.Migrate(db); err != nil {
+		return err
+	}
+
+	if err := models.NewRegistry(models.NewBoltStore(db, nil)).RestoreServices(&dump); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Restored %d services\n", len(dump.Services))
+	return nil
+}
+
+func dbCompact(cmd *cobra.Command, args []string) error {
+	dataFile, db, err := openDataFile(cmd)
+	if err != nil {
+		return err
+	}
+
+	before, err := os.Stat(dataFile)
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	compacted := dataFile + ".compact"
+	os.Remove(compacted)
+	if err := persistence.Compact(db, compacted); err != nil {
+		db.Close()
+		os.Remove(compacted)
+		return err
+	}
+	db.Close()
+
+	if err := os.Rename(compacted, dataFile); err != nil {
+		return err
+	}
+
+	after, err := os.Stat(dataFile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Compacted %s from %d to %d bytes\n", dataFile, before.Size(), after.Size())
+	return nil
+}
+
+func dbCheck(cmd *cobra.Command, args []string) error {
+	dataFile, db, err := openDataFile(cmd)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	corruption, err := persistence.Check(db)
+	if err != nil {
+		return err
+	}
+
+	// Upstreams left in the shared layout are repaired when the daemon starts
+	repairs, err := persistence.RepairUpstreams(db, true)
+	if err != nil {
+		return err
+	}
+
+	problems, err := models.NewRegistry(models.NewBoltStore(db, nil)).Verify()
+	if err != nil {
+		return err
+	}
+	problems = append(repairs, problems...)
+
+	for _, err := range corruption {
+		fmt.Fprintln(stdout, err)
+	}
+	for _, problem := range problems {
+		fmt.Fprintln(stdout, problem)
+	}
+
+	if len(corruption)+len(problems) > 0 {
+		return fmt.Errorf("Found %d problems in %s", len(corruption)+len(problems), dataFile)
+	}
+
+	version, err := persistence.GetSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "No problems found in %s (schema version %d of %d)\n", dataFile, version, persistence.SchemaVersion())
+	return nil
+}
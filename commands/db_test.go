@@ -0,0 +1,135 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/premkit/premkit/models"
+	"github.com/premkit/premkit/persistence"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBCommands(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "premkit-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dirName)
+
+	dataFile := path.Join(dirName, "premkit.db")
+	dumpFile := path.Join(dirName, "dump.json")
+
+	conn, err := bolt.Open(dataFile, 0600, nil)
+	require.NoError(t, err)
+
+	_, err = models.NewRegistry(models.NewBoltStore(conn, nil)).CreateService(&models.Service{
+		Name: "test",
+		Path: "test",
+		Upstreams: []*models.Upstream{
+			&models.Upstream{URL: "http://localhost:3000"},
+		},
+	})
+	require.NoError(t, err)
+
+	// The data file is locked while it is open
+	_, err = runCommand(t, "db", "check", "--data-file", dataFile)
+	assert.Error(t, err)
+
+	conn.Close()
+
+	out, err := runCommand(t, "db", "check", "--data-file", dataFile)
+	require.NoError(t, err)
+	assert.Contains(t, out, "No problems found")
+
+	_, err = runCommand(t, "db", "dump", dumpFile, "--data-file", dataFile)
+	require.NoError(t, err)
+
+	// Replace the database with an empty one, and restore the dump into it
+	require.NoError(t, os.Remove(dataFile))
+	conn, err = bolt.Open(dataFile, 0600, nil)
+	require.NoError(t, err)
+	conn.Close()
+
+	out, err = runCommand(t, "db", "restore", dumpFile, "--data-file", dataFile)
+	require.NoError(t, err)
+	assert.Contains(t, out, "Restored 1 services")
+
+	_, err = runCommand(t, "db", "compact", "--data-file", dataFile)
+	require.NoError(t, err)
+
+	out, err = runCommand(t, "db", "dump", "--data-file", dataFile)
+	require.NoError(t, err)
+	assert.Contains(t, out, "http://localhost:3000")
+}
+
+func TestDBCheckUnscopedUpstream(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "premkit-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dirName)
+
+	dataFile := path.Join(dirName, "premkit.db")
+
+	conn, err := bolt.Open(dataFile, 0600, nil)
+	require.NoError(t, err)
+	err = conn.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("upstream:http://orphan"))
+		return err
+	})
+	require.NoError(t, err)
+	conn.Close()
+
+	out, err := runCommand(t, "db", "check", "--data-file", dataFile)
+	assert.Error(t, err)
+	assert.Contains(t, out, "Remove upstream:http://orphan, which is not referenced by any service")
+}
+
+func TestDBDumpOriginalLayout(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "premkit-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dirName)
+
+	dataFile := path.Join(dirName, "premkit.db")
+
+	// Upstreams were shared between services before the schema version was recorded
+	conn, err := bolt.Open(dataFile, 0600, nil)
+	require.NoError(t, err)
+	err = conn.Update(func(tx *bolt.Tx) error {
+		service, err := tx.CreateBucket([]byte("service:ui"))
+		if err != nil {
+			return err
+		}
+		service.Put([]byte("path"), []byte("ui"))
+		service.Put([]byte("upstream:http://ui:3000"), []byte("http://ui:3000"))
+
+		upstream, err := tx.CreateBucket([]byte("upstream:http://ui:3000"))
+		if err != nil {
+			return err
+		}
+		upstream.Put([]byte("url"), []byte("http://ui:3000"))
+		upstream.Put([]byte("include.service.path"), []byte("true"))
+		return upstream.Put([]byte("insecure.skip.verify"), []byte("false"))
+	})
+	require.NoError(t, err)
+	conn.Close()
+
+	out, err := runCommand(t, "db", "dump", "--data-file", dataFile)
+	require.NoError(t, err)
+	assert.Contains(t, out, "http://ui:3000")
+
+	// The data file itself is not migrated
+	conn, err = bolt.Open(dataFile, 0600, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	version, err := persistence.GetSchemaVersion(conn)
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	backups, err := filepath.Glob(filepath.Join(dirName, "*.bak"))
+	require.NoError(t, err)
+	assert.Empty(t, backups)
+}
@@ -36,6 +36,9 @@ func Execute() {
 // AddCommands will add all child commands to the PremkitCmd
 func AddCommands() {
 	PremkitCmd.AddCommand(daemonCmd)
+	PremkitCmd.AddCommand(serviceCmd)
+	PremkitCmd.AddCommand(upstreamCmd)
+	PremkitCmd.AddCommand(dbCmd)
 }
 
 // InitializeConfig initializes the config environment with defaults.
@@ -0,0 +1,441 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/premkit/premkit/client"
+	"github.com/premkit/premkit/models"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultAddress = "http://localhost:2080"
+	defaultOutput  = "table"
+
+	// requestTimeout limits how long the client commands wait for the daemon, including retries.
+	requestTimeout = 30 * time.Second
+)
+
+// stdout and stdin are used by the client commands, and replaced in tests.
+var (
+	stdout io.Writer = os.Stdout
+	stdin  io.Reader = os.Stdin
+)
+
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Manage the services registered with a running daemon",
+}
+
+var serviceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the registered services",
+	RunE:  requireArgs(0, serviceList),
+}
+
+var serviceGetCmd = &cobra.Command{
+	Use:   "get NAME",
+	Short: "Show a registered service and its upstreams",
+	RunE:  requireArgs(1, serviceGet),
+}
+
+var serviceRegisterCmd = &cobra.Command{
+	Use:   "register",
+	Short: "Register a service, from flags or from a json file",
+	RunE:  requireArgs(0, serviceRegister),
+}
+
+var serviceDeleteCmd = &cobra.Command{
+	Use:   "delete NAME",
+	Short: "Delete a service and its upstreams",
+	RunE:  requireArgs(1, serviceDelete),
+}
+
+var serviceDrainCmd = &cobra.Command{
+	Use:   "drain NAME",
+	Short: "Stop forwarding requests to the upstreams of a service",
+	RunE:  requireArgs(1, serviceDrain),
+}
+
+var serviceHistoryCmd = &cobra.Command{
+	Use:   "history NAME",
+	Short: "List the changes made to a service",
+	RunE:  requireArgs(1, serviceHistory),
+}
+
+var serviceRollbackCmd = &cobra.Command{
+	Use:   "rollback NAME REVISION",
+	Short: "Return a service to its state after a revision from its history",
+	RunE:  requireArgs(2, serviceRollback),
+}
+
+func init() {
+	addClientFlags(serviceCmd)
+
+	serviceRegisterCmd.Flags().String("file", "", "path to a json file with the service to register, or - for stdin")
+	serviceRegisterCmd.Flags().String("name", "", "name of the service")
+	serviceRegisterCmd.Flags().String("path", "", "path of the service")
+	serviceRegisterCmd.Flags().String("upstreams", "", "comma separated list of upstream urls")
+	serviceRegisterCmd.Flags().Bool("include-service-path", false, "true to forward the service path to the upstreams")
+	serviceRegisterCmd.Flags().Bool("replace", false, "true to replace an existing service instead of merging the upstreams")
+
+	serviceDrainCmd.Flags().String("upstream", "", "url of the upstream to drain, instead of all upstreams")
+	serviceDrainCmd.Flags().Bool("undo", false, "true to resume forwarding requests")
+
+	serviceCmd.AddCommand(serviceListCmd)
+	serviceCmd.AddCommand(serviceGetCmd)
+	serviceCmd.AddCommand(serviceRegisterCmd)
+	serviceCmd.AddCommand(serviceDeleteCmd)
+	serviceCmd.AddCommand(serviceDrainCmd)
+	serviceCmd.AddCommand(serviceHistoryCmd)
+	serviceCmd.AddCommand(serviceRollbackCmd)
+}
+
+// requireArgs checks the number of positional arguments before running the command.
+func requireArgs(n int, run func(*cobra.Command, []string) error) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if len(args) != n {
+			cmd.Usage()
+			return fmt.Errorf("%q requires %d argument(s), received %d", cmd.CommandPath(), n, len(args))
+		}
+		return run(cmd, args)
+	}
+}
+
+// addClientFlags adds the flags used to reach the daemon and format its responses.
+func addClientFlags(cmd *cobra.Command) {
+	address := os.Getenv("PREMKIT_ADDRESS")
+	if address == "" {
+		address = defaultAddress
+	}
+
+	cmd.PersistentFlags().String("address", address, "address of the premkit daemon (or set PREMKIT_ADDRESS)")
+	cmd.PersistentFlags().StringP("output", "o", defaultOutput, "output format, table or json")
+}
+
+func newClient(cmd *cobra.Command) (*client.Client, context.Context, context.CancelFunc, error) {
+	address, err := cmd.Flags().GetString("address")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if output != "table" && output != "json" {
+		return nil, nil, nil, fmt.Errorf("Unknown output format %q", output)
+	}
+
+	c, err := client.New(address)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	c.Actor = cliActor()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	return c, ctx, cancel, nil
+}
+
+// cliActor identifies the user running the command, as user@host, in the history of the
+// services it changes.
+func cliActor() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	if host, err := os.Hostname(); err == nil {
+		return fmt.Sprintf("%s@%s", name, host)
+	}
+	return name
+}
+
+func serviceList(cmd *cobra.Command, args []string) error {
+	c, ctx, cancel, err := newClient(cmd)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	services, err := c.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	if isJSONOutput(cmd) {
+		return printJSON(stdout, services)
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPATH\tKIND\tSOURCE\tUPSTREAMS")
+	for _, service := range services {
+		fmt.Fprintf(w, "%s\t/%s\t%s\t%s\t%d\n", service.Name, service.Path, serviceKind(service), service.Source, len(service.Upstreams))
+	}
+	return w.Flush()
+}
+
+func serviceGet(cmd *cobra.Command, args []string) error {
+	c, ctx, cancel, err := newClient(cmd)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	service, err := c.Get(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if service == nil {
+		return fmt.Errorf("Service %q not found", args[0])
+	}
+
+	return printService(cmd, service)
+}
+
+func serviceRegister(cmd *cobra.Command, args []string) error {
+	service, err := serviceFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	replace, err := cmd.Flags().GetBool("replace")
+	if err != nil {
+		return err
+	}
+
+	c, ctx, cancel, err := newClient(cmd)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	service, err = c.Register(ctx, service, replace)
+	if err != nil {
+		return err
+	}
+
+	return printService(cmd, service)
+}
+
+func serviceFromFlags(cmd *cobra.Command) (*models.Service, error) {
+	file, err := cmd.Flags().GetString("file")
+	if err != nil {
+		return nil, err
+	}
+
+	if file != "" {
+		var b []byte
+		if file == "-" {
+			b, err = ioutil.ReadAll(stdin)
+		} else {
+			b, err = ioutil.ReadFile(file)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		service := models.Service{}
+		if err := json.Unmarshal(b, &service); err != nil {
+			return nil, err
+		}
+		return &service, nil
+	}
+
+	name, _ := cmd.Flags().GetString("name")
+	path, _ := cmd.Flags().GetString("path")
+	upstreams, _ := cmd.Flags().GetString("upstreams")
+	includeServicePath, _ := cmd.Flags().GetBool("include-service-path")
+
+	if name == "" {
+		return nil, fmt.Errorf("--name or --file is required")
+	}
+
+	service := models.Service{
+		Name:      name,
+		Path:      path,
+		Upstreams: make([]*models.Upstream, 0, 0),
+	}
+	for _, url := range splitList(upstreams) {
+		service.Upstreams = append(service.Upstreams, &models.Upstream{
+			URL:                url,
+			IncludeServicePath: includeServicePath,
+		})
+	}
+
+	return &service, nil
+}
+
+func serviceDelete(cmd *cobra.Command, args []string) error {
+	c, ctx, cancel, err := newClient(cmd)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if err := c.Deregister(ctx, args[0], ""); err != nil {
+		return notFound(err, args[0])
+	}
+
+	fmt.Fprintf(stdout, "Deleted service %q\n", args[0])
+	return nil
+}
+
+func serviceDrain(cmd *cobra.Command, args []string) error {
+	upstream, err := cmd.Flags().GetString("upstream")
+	if err != nil {
+		return err
+	}
+
+	undo, err := cmd.Flags().GetBool("undo")
+	if err != nil {
+		return err
+	}
+
+	c, ctx, cancel, err := newClient(cmd)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	service, err := c.Drain(ctx, args[0], upstream, undo)
+	if err != nil {
+		return notFound(err, args[0])
+	}
+
+	return printService(cmd, service)
+}
+
+func serviceHistory(cmd *cobra.Command, args []string) error {
+	c, ctx, cancel, err := newClient(cmd)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	revisions, err := c.History(ctx, args[0])
+	if err != nil {
+		return notFound(err, args[0])
+	}
+
+	if isJSONOutput(cmd) {
+		return printJSON(stdout, revisions)
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tTIME\tCHANGE\tSOURCE\tACTOR")
+	for _, revision := range revisions {
+		change := "updated"
+		if revision.Before == nil {
+			change = "created"
+		} else if revision.After == nil {
+			change = "deleted"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", revision.Number, revision.Time.Format(time.RFC3339), change, revision.Source, revision.Actor)
+	}
+	return w.Flush()
+}
+
+func serviceRollback(cmd *cobra.Command, args []string) error {
+	revision, err := strconv.Atoi(args[1])
+	if err != nil || revision <= 0 {
+		return fmt.Errorf("Invalid revision %q", args[1])
+	}
+
+	c, ctx, cancel, err := newClient(cmd)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	service, err := c.Rollback(ctx, args[0], revision)
+	if err == client.ErrNotFound {
+		return fmt.Errorf("Revision %d of service %q not found", revision, args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	if service == nil {
+		fmt.Fprintf(stdout, "Deleted service %q, as of revision %d\n", args[0], revision)
+		return nil
+	}
+
+	return printService(cmd, service)
+}
+
+// printService prints the service, with a row for each of its upstreams in table output.
+func printService(cmd *cobra.Command, service *models.Service) error {
+	if isJSONOutput(cmd) {
+		return printJSON(stdout, service)
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", service.Name)
+	fmt.Fprintf(w, "Path:\t/%s\n", service.Path)
+	fmt.Fprintf(w, "Kind:\t%s\n", serviceKind(service))
+	fmt.Fprintf(w, "Priority:\t%d\n", service.Priority)
+	fmt.Fprintf(w, "Source:\t%s\n", service.Source)
+	if !service.Registered.IsZero() {
+		fmt.Fprintf(w, "Registered:\t%s\n", service.Registered.Format(time.RFC3339))
+	}
+	if !service.Updated.IsZero() {
+		fmt.Fprintf(w, "Updated:\t%s\n", service.Updated.Format(time.RFC3339))
+	}
+	if service.Expires != nil {
+		fmt.Fprintf(w, "Expires:\t%s\n", service.Expires.Format(time.RFC3339))
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "UPSTREAM\tINCLUDE SERVICE PATH\tDRAINING\tEXPIRES")
+	for _, upstream := range service.Upstreams {
+		expires := ""
+		if upstream.Expires != nil {
+			expires = upstream.Expires.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%v\t%v\t%s\n", upstream.URL, upstream.IncludeServicePath, upstream.Draining, expires)
+	}
+
+	return w.Flush()
+}
+
+func serviceKind(service *models.Service) string {
+	if service.Kind == "" {
+		return models.ServiceKindProxy
+	}
+	return service.Kind
+}
+
+func isJSONOutput(cmd *cobra.Command) bool {
+	output, _ := cmd.Flags().GetString("output")
+	return strings.ToLower(output) == "json"
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// notFound replaces client.ErrNotFound with an error that names the service.
+func notFound(err error, name string) error {
+	if err == client.ErrNotFound {
+		return fmt.Errorf("Service %q not found", name)
+	}
+	return err
+}
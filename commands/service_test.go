@@ -0,0 +1,143 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	v1 "github.com/premkit/premkit/handlers/v1"
+	"github.com/premkit/premkit/models"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var addCommandsOnce sync.Once
+
+func setupDaemon(t *testing.T) (*httptest.Server, *models.Registry) {
+	registry := models.NewRegistry(models.NewMemoryStore())
+	api := v1.NewAPI(registry, nil)
+
+	router := mux.NewRouter()
+	internalV1 := router.PathPrefix("/premkit/v1").Subrouter()
+	internalV1.HandleFunc("/service", api.RegisterService).Methods("POST")
+	internalV1.HandleFunc("/service", api.ListServices).Methods("GET")
+	internalV1.HandleFunc("/service/{name}", api.GetService).Methods("GET")
+	internalV1.HandleFunc("/service/{name}", api.DeregisterService).Methods("DELETE")
+	internalV1.HandleFunc("/service/{name}/drain", api.Drain).Methods("PUT")
+	internalV1.HandleFunc("/service/{name}/upstream", api.AddUpstream).Methods("POST")
+	internalV1.HandleFunc("/service/{name}/history", api.ListRevisions).Methods("GET")
+	internalV1.HandleFunc("/service/{name}/rollback", api.Rollback).Methods("POST")
+
+	return httptest.NewServer(router), registry
+}
+
+func teardownDaemon(server *httptest.Server) {
+	server.Close()
+}
+
+// runCommand runs the premkit command with the args, and returns what it printed.
+func runCommand(t *testing.T, args ...string) (string, error) {
+	addCommandsOnce.Do(AddCommands)
+
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+
+	PremkitCmd.SetArgs(args)
+	_, err := PremkitCmd.ExecuteC()
+
+	return out.String(), err
+}
+
+func TestServiceCommands(t *testing.T) {
+	server, registry := setupDaemon(t)
+	defer teardownDaemon(server)
+
+	_, err := runCommand(t, "service", "register", "--address", server.URL,
+		"--name", "test", "--path", "/test", "--upstreams", "http://localhost:3000,http://localhost:3001")
+	require.NoError(t, err)
+
+	out, err := runCommand(t, "service", "list", "--address", server.URL, "--output", "table")
+	require.NoError(t, err)
+	assert.Contains(t, out, "NAME")
+	assert.Contains(t, out, "test")
+
+	out, err = runCommand(t, "service", "list", "--address", server.URL, "--output", "json")
+	require.NoError(t, err)
+	services := make([]*models.Service, 0, 0)
+	require.NoError(t, json.Unmarshal([]byte(out), &services))
+	require.Equal(t, 1, len(services))
+	assert.Equal(t, 2, len(services[0].Upstreams))
+
+	_, err = runCommand(t, "service", "drain", "test", "--address", server.URL, "--upstream", "http://localhost:3000", "--undo=false")
+	require.NoError(t, err)
+
+	service, err := registry.GetServiceByName([]byte("test"))
+	require.NoError(t, err)
+	for _, upstream := range service.Upstreams {
+		assert.Equal(t, upstream.URL == "http://localhost:3000", upstream.Draining, upstream.URL)
+	}
+
+	_, err = runCommand(t, "service", "get", "missing", "--address", server.URL)
+	assert.Error(t, err)
+
+	out, err = runCommand(t, "service", "history", "test", "--address", server.URL, "--output", "table")
+	require.NoError(t, err)
+	assert.Contains(t, out, "REVISION")
+	assert.Contains(t, out, "created")
+	assert.Contains(t, out, "@", "the user running the command should be recorded")
+
+	_, err = runCommand(t, "service", "rollback", "test", "1", "--address", server.URL, "--output", "json")
+	require.NoError(t, err)
+
+	service, err = registry.GetServiceByName([]byte("test"))
+	require.NoError(t, err)
+	for _, upstream := range service.Upstreams {
+		assert.False(t, upstream.Draining, "the service should be rolled back to before it was drained")
+	}
+
+	_, err = runCommand(t, "service", "rollback", "test", "100", "--address", server.URL)
+	assert.Error(t, err)
+
+	_, err = runCommand(t, "service", "delete", "test", "--address", server.URL)
+	require.NoError(t, err)
+
+	service, err = registry.GetServiceByName([]byte("test"))
+	require.NoError(t, err)
+	assert.Nil(t, service)
+}
+
+func TestUpstreamCommands(t *testing.T) {
+	server, registry := setupDaemon(t)
+	defer teardownDaemon(server)
+
+	_, err := registry.CreateService(&models.Service{
+		Name:     "test",
+		Path:     "test",
+		Priority: 10,
+	})
+	require.NoError(t, err)
+
+	out, err := runCommand(t, "upstream", "add", "test", "http://localhost:3000", "--address", server.URL, "--output", "table")
+	require.NoError(t, err)
+	assert.Contains(t, out, "http://localhost:3000")
+
+	service, err := registry.GetServiceByName([]byte("test"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(service.Upstreams))
+	assert.Equal(t, 10, service.Priority, "adding an upstream should not change the service")
+
+	_, err = runCommand(t, "upstream", "remove", "test", "http://localhost:3000", "--address", server.URL)
+	require.NoError(t, err)
+
+	_, err = runCommand(t, "upstream", "remove", "test", "http://localhost:3000", "--address", server.URL)
+	assert.Error(t, err)
+
+	_, err = runCommand(t, "upstream", "add", "test", "--address", server.URL)
+	assert.Error(t, err)
+}
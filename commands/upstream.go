@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/premkit/premkit/client"
+	"github.com/premkit/premkit/models"
+
+	"github.com/spf13/cobra"
+)
+
+var upstreamCmd = &cobra.Command{
+	Use:   "upstream",
+	Short: "Manage the upstreams of services registered with a running daemon",
+}
+
+var upstreamAddCmd = &cobra.Command{
+	Use:   "add SERVICE URL",
+	Short: "Add an upstream to a service",
+	RunE:  requireArgs(2, upstreamAdd),
+}
+
+var upstreamRemoveCmd = &cobra.Command{
+	Use:   "remove SERVICE URL",
+	Short: "Remove an upstream from a service",
+	RunE:  requireArgs(2, upstreamRemove),
+}
+
+func init() {
+	addClientFlags(upstreamCmd)
+
+	upstreamAddCmd.Flags().Bool("include-service-path", false, "true to forward the service path to the upstream")
+	upstreamAddCmd.Flags().Bool("insecure-skip-verify", false, "true to skip verifying the tls certificate of the upstream")
+	upstreamAddCmd.Flags().Int("ttl", 0, "lease duration in seconds, after which the upstream is removed unless renewed")
+
+	upstreamCmd.AddCommand(upstreamAddCmd)
+	upstreamCmd.AddCommand(upstreamRemoveCmd)
+}
+
+func upstreamAdd(cmd *cobra.Command, args []string) error {
+	includeServicePath, _ := cmd.Flags().GetBool("include-service-path")
+	insecureSkipVerify, _ := cmd.Flags().GetBool("insecure-skip-verify")
+	ttl, _ := cmd.Flags().GetInt("ttl")
+
+	c, ctx, cancel, err := newClient(cmd)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	service, err := c.AddUpstream(ctx, args[0], &models.Upstream{
+		URL:                args[1],
+		IncludeServicePath: includeServicePath,
+		InsecureSkipVerify: insecureSkipVerify,
+		TTL:                ttl,
+	})
+	if err != nil {
+		return notFound(err, args[0])
+	}
+
+	return printService(cmd, service)
+}
+
+func upstreamRemove(cmd *cobra.Command, args []string) error {
+	c, ctx, cancel, err := newClient(cmd)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if err := c.Deregister(ctx, args[0], args[1]); err != nil {
+		if err == client.ErrNotFound {
+			return fmt.Errorf("Upstream %q not found in service %q", args[1], args[0])
+		}
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Removed upstream %q from service %q\n", args[1], args[0])
+	return nil
+}
@@ -0,0 +1,275 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/models"
+)
+
+const (
+	// DockerLabelServiceName is the container label with the name of the service to register.
+	// Only containers with this label are registered.
+	DockerLabelServiceName = "premkit.service.name"
+
+	// DockerLabelServicePath is the container label with the path of the service.
+	DockerLabelServicePath = "premkit.service.path"
+
+	// DockerLabelPort is the container label with the port the container listens on.
+	DockerLabelPort = "premkit.port"
+
+	// DockerLabelScheme is the optional container label with the scheme of the upstream.  The
+	// default is http.
+	DockerLabelScheme = "premkit.scheme"
+
+	// DockerLabelNetwork is the optional container label with the name of the network to reach
+	// the container on, when it is attached to more than one.
+	DockerLabelNetwork = "premkit.network"
+
+	// DockerLabelIncludeServicePath is the optional container label that sets
+	// Upstream.IncludeServicePath.
+	DockerLabelIncludeServicePath = "premkit.include_service_path"
+)
+
+// dockerRetryInterval is how long to wait before reconnecting to the Docker event stream.
+const dockerRetryInterval = 5 * time.Second
+
+// dockerContainer is the subset of a container, as returned by the Docker Engine API, that is
+// needed to register it.
+type dockerContainer struct {
+	ID              string            `json:"Id"`
+	Names           []string          `json:"Names"`
+	Labels          map[string]string `json:"Labels"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// DockerProvider registers running containers with premkit labels as services, and removes them
+// when the containers stop.
+type DockerProvider struct {
+	Registry   *models.Registry
+	SocketPath string
+
+	client *http.Client
+}
+
+// NewDockerProvider creates a provider that talks to the Docker Engine API on the unix socket,
+// and reconciles the containers into registry.
+func NewDockerProvider(registry *models.Registry, socketPath string) *DockerProvider {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+
+	return &DockerProvider{
+		Registry:   registry,
+		SocketPath: socketPath,
+		client:     &http.Client{Transport: transport},
+	}
+}
+
+// Sync lists the running containers and reconciles the services they define.
+func (p *DockerProvider) Sync(ctx context.Context) error {
+	filters, err := json.Marshal(map[string][]string{
+		"label": []string{DockerLabelServiceName},
+	})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("GET", "http://docker/containers/json?filters="+url.QueryEscape(string(filters)), nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := p.client.Do(request.WithContext(ctx))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		err := fmt.Errorf("Unexpected status %d listing containers", response.StatusCode)
+		log.Error(err)
+		return err
+	}
+
+	containers := make([]*dockerContainer, 0, 0)
+	if err := json.NewDecoder(response.Body).Decode(&containers); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	return Reconcile(p.Registry, models.SourceDocker, servicesFromContainers(containers))
+}
+
+// Watch keeps the registry in sync with the running containers until the context is done.
+func (p *DockerProvider) Watch(ctx context.Context) {
+	for {
+		if err := p.watchEvents(ctx); err != nil {
+			log.Errorf("Lost the docker event stream: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(dockerRetryInterval):
+		}
+	}
+}
+
+// watchEvents syncs once, and again after every container event, until the event stream ends.
+func (p *DockerProvider) watchEvents(ctx context.Context) error {
+	filters, err := json.Marshal(map[string][]string{
+		"type":  []string{"container"},
+		"label": []string{DockerLabelServiceName},
+	})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("GET", "http://docker/events?filters="+url.QueryEscape(string(filters)), nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := p.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status %d watching events", response.StatusCode)
+	}
+
+	// Sync after subscribing, so changes made before the subscription are not missed
+	if err := p.Sync(ctx); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(response.Body)
+	for {
+		event := struct {
+			Action string `json:"Action"`
+			Actor  struct {
+				ID string `json:"ID"`
+			} `json:"Actor"`
+		}{}
+		if err := decoder.Decode(&event); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		log.Debugf("Docker container %s: %s", event.Actor.ID, event.Action)
+		if err := p.Sync(ctx); err != nil {
+			log.Errorf("Failed to sync docker containers: %v", err)
+		}
+	}
+}
+
+// servicesFromContainers builds the services defined by the labels of the containers.  Containers
+// with the same service name become upstreams of the same service.
+func servicesFromContainers(containers []*dockerContainer) []*models.Service {
+	servicesByName := make(map[string]*models.Service)
+
+	for _, container := range containers {
+		name := container.Labels[DockerLabelServiceName]
+		if name == "" {
+			continue
+		}
+
+		upstream, err := upstreamFromContainer(container)
+		if err != nil {
+			log.Warningf("Not registering container %s: %v", container.ID, err)
+			continue
+		}
+
+		service, ok := servicesByName[name]
+		if !ok {
+			service = &models.Service{
+				Name:      name,
+				Path:      container.Labels[DockerLabelServicePath],
+				Upstreams: make([]*models.Upstream, 0, 0),
+			}
+			servicesByName[name] = service
+		} else if service.Path != container.Labels[DockerLabelServicePath] {
+			log.Warningf("Container %s has path %q for service %q, which already has path %q", container.ID, container.Labels[DockerLabelServicePath], name, service.Path)
+		}
+
+		service.Upstreams = append(service.Upstreams, upstream)
+	}
+
+	services := make([]*models.Service, 0, len(servicesByName))
+	for _, service := range servicesByName {
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+
+	return services
+}
+
+func upstreamFromContainer(container *dockerContainer) (*models.Upstream, error) {
+	port, err := strconv.Atoi(container.Labels[DockerLabelPort])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s label %q", DockerLabelPort, container.Labels[DockerLabelPort])
+	}
+
+	ip := ""
+	if network := container.Labels[DockerLabelNetwork]; network != "" {
+		ip = container.NetworkSettings.Networks[network].IPAddress
+	} else {
+		// Pick the first network by name, so the choice is stable
+		names := make([]string, 0, len(container.NetworkSettings.Networks))
+		for name := range container.NetworkSettings.Networks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if ip = container.NetworkSettings.Networks[name].IPAddress; ip != "" {
+				break
+			}
+		}
+	}
+	if ip == "" {
+		return nil, fmt.Errorf("no ip address found")
+	}
+
+	scheme := container.Labels[DockerLabelScheme]
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	includeServicePath := false
+	if v := container.Labels[DockerLabelIncludeServicePath]; v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s label %q", DockerLabelIncludeServicePath, v)
+		}
+		includeServicePath = b
+	}
+
+	return &models.Upstream{
+		URL:                fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(ip, strconv.Itoa(port))),
+		IncludeServicePath: includeServicePath,
+	}, nil
+}
@@ -0,0 +1,183 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/premkit/premkit/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDocker is a minimal Docker Engine API that serves a list of containers and an event stream.
+type fakeDocker struct {
+	mu         sync.Mutex
+	containers []map[string]interface{}
+	events     chan string
+}
+
+func (d *fakeDocker) setContainers(containers ...map[string]interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.containers = containers
+}
+
+func (d *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/containers/json":
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		json.NewEncoder(w).Encode(d.containers)
+
+	case "/events":
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case action := <-d.events:
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"Type":   "container",
+					"Action": action,
+					"Actor":  map[string]string{"ID": "abc"},
+				})
+				w.(http.Flusher).Flush()
+			}
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func container(id, name, servicePath, ip string) map[string]interface{} {
+	return map[string]interface{}{
+		"Id": id,
+		"Labels": map[string]string{
+			DockerLabelServiceName: name,
+			DockerLabelServicePath: servicePath,
+			DockerLabelPort:        "8080",
+		},
+		"NetworkSettings": map[string]interface{}{
+			"Networks": map[string]interface{}{
+				"bridge": map[string]string{"IPAddress": ip},
+			},
+		},
+	}
+}
+
+func startFakeDocker(t *testing.T, dir string) (*fakeDocker, string) {
+	socketPath := path.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+
+	docker := &fakeDocker{events: make(chan string)}
+	server := &http.Server{Handler: docker}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return docker, socketPath
+}
+
+func TestDockerProviderSync(t *testing.T) {
+	t.Parallel()
+	registry := setup(t)
+
+	docker, socketPath := startFakeDocker(t, t.TempDir())
+	docker.setContainers(
+		container("1", "ui", "/ui", "172.17.0.2"),
+		container("2", "ui", "/ui", "172.17.0.3"),
+		container("3", "api", "/api", "172.17.0.4"),
+	)
+
+	provider := NewDockerProvider(registry, socketPath)
+	require.NoError(t, provider.Sync(context.Background()))
+
+	ui, err := registry.GetServiceByName([]byte("ui"))
+	require.NoError(t, err)
+	require.NotNil(t, ui)
+	assert.Equal(t, models.SourceDocker, ui.Source)
+	assert.Equal(t, "ui", ui.Path)
+	require.Equal(t, 2, len(ui.Upstreams))
+	assert.Equal(t, "http://172.17.0.2:8080", ui.Upstreams[0].URL)
+	assert.Equal(t, "http://172.17.0.3:8080", ui.Upstreams[1].URL)
+
+	// The api container stops
+	docker.setContainers(
+		container("1", "ui", "/ui", "172.17.0.2"),
+		container("2", "ui", "/ui", "172.17.0.3"),
+	)
+	require.NoError(t, provider.Sync(context.Background()))
+
+	api, err := registry.GetServiceByName([]byte("api"))
+	require.NoError(t, err)
+	assert.Nil(t, api)
+}
+
+func TestDockerProviderWatch(t *testing.T) {
+	t.Parallel()
+	registry := setup(t)
+
+	docker, socketPath := startFakeDocker(t, t.TempDir())
+	docker.setContainers(container("1", "ui", "/ui", "172.17.0.2"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewDockerProvider(registry, socketPath).Watch(ctx)
+
+	waitForService := func(name string, present bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			service, err := registry.GetServiceByName([]byte(name))
+			require.NoError(t, err)
+			if (service != nil) == present {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("service %q present should be %v", name, present)
+	}
+
+	waitForService("ui", true)
+
+	docker.setContainers()
+	docker.events <- "die"
+
+	waitForService("ui", false)
+}
+
+func TestUpstreamFromContainer(t *testing.T) {
+	c := &dockerContainer{
+		ID: "abc",
+		Labels: map[string]string{
+			DockerLabelServiceName:        "ui",
+			DockerLabelPort:               "8443",
+			DockerLabelScheme:             "https",
+			DockerLabelNetwork:            "backend",
+			DockerLabelIncludeServicePath: "true",
+		},
+	}
+	c.NetworkSettings.Networks = map[string]struct {
+		IPAddress string `json:"IPAddress"`
+	}{
+		"bridge":  {IPAddress: "172.17.0.2"},
+		"backend": {IPAddress: "10.0.0.2"},
+	}
+
+	upstream, err := upstreamFromContainer(c)
+	require.NoError(t, err)
+	assert.Equal(t, "https://10.0.0.2:8443", upstream.URL)
+	assert.True(t, upstream.IncludeServicePath)
+
+	c.Labels[DockerLabelPort] = ""
+	_, err = upstreamFromContainer(c)
+	assert.Error(t, err)
+}
@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ServicesFile is the format of the services file.  The file can be written as YAML or JSON, using
+// the same field names as the API.
+type ServicesFile struct {
+	Services []*models.Service `json:"services"`
+}
+
+// FileProvider keeps the services defined in a services file reconciled into the registry.
+type FileProvider struct {
+	Registry *models.Registry
+	Path     string
+	Interval time.Duration
+
+	checksum [sha256.Size]byte
+}
+
+// NewFileProvider creates a provider for the services file at path, which is checked for
+// changes at interval, that reconciles it into registry.
+func NewFileProvider(registry *models.Registry, path string, interval time.Duration) *FileProvider {
+	return &FileProvider{
+		Registry: registry,
+		Path:     path,
+		Interval: interval,
+	}
+}
+
+// Load reads the services file and reconciles it, if it changed since it was last loaded.
+func (p *FileProvider) Load() error {
+	contents, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	checksum := sha256.Sum256(contents)
+	if checksum == p.checksum {
+		return nil
+	}
+
+	services, err := ParseServicesFile(contents)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Loading %d services from %s", len(services), p.Path)
+	if err := Reconcile(p.Registry, models.SourceFile, services); err != nil {
+		return err
+	}
+
+	p.checksum = checksum
+	return nil
+}
+
+// Watch reloads the services file whenever it changes, until the context is done.
+func (p *FileProvider) Watch(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.Load(); err != nil {
+				log.Errorf("Failed to load services file %s: %v", p.Path, err)
+			}
+		}
+	}
+}
+
+// ParseServicesFile parses the YAML or JSON contents of a services file.
+func ParseServicesFile(contents []byte) ([]*models.Service, error) {
+	// Decode the YAML generically and convert it to JSON, so the json tags of the models are used
+	var generic interface{}
+	if err := yaml.Unmarshal(contents, &generic); err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	b, err := json.Marshal(generic)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	servicesFile := ServicesFile{}
+	if err := json.Unmarshal(b, &servicesFile); err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	for i, service := range servicesFile.Services {
+		if service == nil {
+			err := fmt.Errorf("Service %d in the services file is empty", i+1)
+			log.Error(err)
+			return nil, err
+		}
+	}
+
+	return servicesFile.Services, nil
+}
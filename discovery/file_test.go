@@ -0,0 +1,88 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/premkit/premkit/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseServicesFileYAML(t *testing.T) {
+	contents := `
+services:
+  - name: ui
+    path: /ui
+    priority: 5
+    upstreams:
+      - url: http://ui:3000
+        include_service_path: true
+  - name: robots
+    path: /robots.txt
+    kind: static
+    response:
+      body: "User-agent: *"
+`
+
+	services, err := ParseServicesFile([]byte(contents))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(services))
+
+	assert.Equal(t, "ui", services[0].Name)
+	assert.Equal(t, 5, services[0].Priority)
+	require.Equal(t, 1, len(services[0].Upstreams))
+	assert.Equal(t, "http://ui:3000", services[0].Upstreams[0].URL)
+	assert.True(t, services[0].Upstreams[0].IncludeServicePath)
+
+	assert.Equal(t, models.ServiceKindStatic, services[1].Kind)
+	assert.Equal(t, "User-agent: *", services[1].Response.Body)
+}
+
+func TestParseServicesFileJSON(t *testing.T) {
+	contents := `{"services": [{"name": "ui", "path": "/ui", "upstreams": [{"url": "http://ui:3000"}]}]}`
+
+	services, err := ParseServicesFile([]byte(contents))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(services))
+	assert.Equal(t, "ui", services[0].Name)
+}
+
+func TestParseServicesFileEmptyService(t *testing.T) {
+	_, err := ParseServicesFile([]byte("services:\n  - name: ui\n    path: /ui\n  - ~\n"))
+	assert.EqualError(t, err, "Service 2 in the services file is empty")
+
+	_, err = ParseServicesFile([]byte(`{"services": [null]}`))
+	assert.Error(t, err)
+}
+
+func TestFileProviderLoad(t *testing.T) {
+	t.Parallel()
+	registry := setup(t)
+
+	servicesFile := path.Join(t.TempDir(), "services.yaml")
+	err := ioutil.WriteFile(servicesFile, []byte("services:\n  - name: ui\n    path: /ui\n"), 0644)
+	require.NoError(t, err)
+
+	provider := NewFileProvider(registry, servicesFile, 0)
+	require.NoError(t, provider.Load())
+
+	service, err := registry.GetServiceByName([]byte("ui"))
+	require.NoError(t, err)
+	require.NotNil(t, service)
+	assert.Equal(t, models.SourceFile, service.Source)
+
+	err = ioutil.WriteFile(servicesFile, []byte("services: []\n"), 0644)
+	require.NoError(t, err)
+	require.NoError(t, provider.Load())
+
+	service, err = registry.GetServiceByName([]byte("ui"))
+	require.NoError(t, err)
+	assert.Nil(t, service)
+
+	err = ioutil.WriteFile(servicesFile, []byte("services: [\n"), 0644)
+	require.NoError(t, err)
+	assert.Error(t, provider.Load())
+}
@@ -0,0 +1,298 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/models"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// KubernetesAnnotationServiceName is the annotation on a Kubernetes Service with the name of the
+	// premkit service to register.  Only Services with this annotation are registered.
+	KubernetesAnnotationServiceName = "premkit.io/service-name"
+
+	// KubernetesAnnotationServicePath is the annotation with the path of the premkit service.
+	KubernetesAnnotationServicePath = "premkit.io/service-path"
+
+	// KubernetesAnnotationPort is the optional annotation with the name or number of the port to
+	// forward to.  It is only required when the Service has more than one port.
+	KubernetesAnnotationPort = "premkit.io/port"
+
+	// KubernetesAnnotationScheme is the optional annotation with the scheme of the upstreams.  The
+	// default is http.
+	KubernetesAnnotationScheme = "premkit.io/scheme"
+
+	// KubernetesAnnotationIncludeServicePath is the optional annotation that sets
+	// Upstream.IncludeServicePath.
+	KubernetesAnnotationIncludeServicePath = "premkit.io/include-service-path"
+)
+
+// kubernetesResyncPeriod is how often the informers replay their cache, which also reconciles
+// any changes made to the registry by hand.
+const kubernetesResyncPeriod = 5 * time.Minute
+
+// KubernetesProvider registers annotated Kubernetes Services as services, with an upstream for
+// each ready endpoint in their EndpointSlices.
+type KubernetesProvider struct {
+	registry  *models.Registry
+	client    kubernetes.Interface
+	namespace string
+
+	services corelisters.ServiceLister
+	slices   discoverylisters.EndpointSliceLister
+	changed  chan struct{}
+}
+
+// NewKubernetesProvider creates a provider that watches Services and EndpointSlices in the namespace,
+// or in all namespaces if namespace is empty, and reconciles them into registry.
+func NewKubernetesProvider(registry *models.Registry, client kubernetes.Interface, namespace string) *KubernetesProvider {
+	return &KubernetesProvider{
+		registry:  registry,
+		client:    client,
+		namespace: namespace,
+		changed:   make(chan struct{}, 1),
+	}
+}
+
+// NewInClusterKubernetesProvider creates a provider using the service account premkit runs as.
+func NewInClusterKubernetesProvider(registry *models.Registry, namespace string) (*KubernetesProvider, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return NewKubernetesProvider(registry, client, namespace), nil
+}
+
+// Watch keeps the registry in sync with the annotated Services until the context is done.
+func (p *KubernetesProvider) Watch(ctx context.Context) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(p.client, kubernetesResyncPeriod, informers.WithNamespace(p.namespace))
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { p.trigger() },
+		UpdateFunc: func(oldObj, newObj interface{}) { p.trigger() },
+		DeleteFunc: func(obj interface{}) { p.trigger() },
+	}
+
+	serviceInformer := factory.Core().V1().Services()
+	if _, err := serviceInformer.Informer().AddEventHandler(handler); err != nil {
+		return err
+	}
+	p.services = serviceInformer.Lister()
+
+	sliceInformer := factory.Discovery().V1().EndpointSlices()
+	if _, err := sliceInformer.Informer().AddEventHandler(handler); err != nil {
+		return err
+	}
+	p.slices = sliceInformer.Lister()
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+
+	for informerType, ok := range factory.WaitForCacheSync(ctx.Done()) {
+		if !ok {
+			return fmt.Errorf("Failed to sync the %v informer", informerType)
+		}
+	}
+
+	for {
+		if err := p.Sync(); err != nil {
+			log.Errorf("Failed to sync kubernetes services: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-p.changed:
+		}
+	}
+}
+
+// trigger requests a sync.  Changes that arrive while a sync is pending are coalesced.
+func (p *KubernetesProvider) trigger() {
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Sync reconciles the services defined by the annotated Services in the informer caches.
+// Services, for example in different namespaces, that annotate the same service name are merged
+// into one service with the upstreams of all of them.
+func (p *KubernetesProvider) Sync() error {
+	kubeServices, err := p.services.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	// Merge in a stable order, so the path of a service does not depend on the cache
+	sort.Slice(kubeServices, func(i, j int) bool {
+		if kubeServices[i].Namespace != kubeServices[j].Namespace {
+			return kubeServices[i].Namespace < kubeServices[j].Namespace
+		}
+		return kubeServices[i].Name < kubeServices[j].Name
+	})
+
+	services := make([]*models.Service, 0, 0)
+	servicesByName := make(map[string]*models.Service)
+	for _, kubeService := range kubeServices {
+		name := kubeService.Annotations[KubernetesAnnotationServiceName]
+		if name == "" {
+			continue
+		}
+
+		selector := labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: kubeService.Name})
+		slices, err := p.slices.EndpointSlices(kubeService.Namespace).List(selector)
+		if err != nil {
+			return err
+		}
+
+		upstreams, err := upstreamsFromEndpointSlices(kubeService, slices)
+		if err != nil {
+			log.Warningf("Not registering service %s/%s: %v", kubeService.Namespace, kubeService.Name, err)
+			continue
+		}
+
+		path := kubeService.Annotations[KubernetesAnnotationServicePath]
+		service, ok := servicesByName[name]
+		if !ok {
+			service = &models.Service{
+				Name:      name,
+				Path:      path,
+				Upstreams: make([]*models.Upstream, 0, 0),
+			}
+			servicesByName[name] = service
+			services = append(services, service)
+		} else if service.Path != path {
+			log.Warningf("Service %s/%s has path %q for service %q, which already has path %q", kubeService.Namespace, kubeService.Name, path, name, service.Path)
+		}
+
+		for _, upstream := range upstreams {
+			if !hasUpstream(service, upstream.URL) {
+				service.Upstreams = append(service.Upstreams, upstream)
+			}
+		}
+	}
+
+	return Reconcile(p.registry, models.SourceKubernetes, services)
+}
+
+func hasUpstream(service *models.Service, url string) bool {
+	for _, upstream := range service.Upstreams {
+		if upstream.URL == url {
+			return true
+		}
+	}
+	return false
+}
+
+// upstreamsFromEndpointSlices returns an upstream for each ready endpoint address of the Service.
+func upstreamsFromEndpointSlices(kubeService *corev1.Service, slices []*discoveryv1.EndpointSlice) ([]*models.Upstream, error) {
+	scheme := kubeService.Annotations[KubernetesAnnotationScheme]
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	includeServicePath := false
+	if v := kubeService.Annotations[KubernetesAnnotationIncludeServicePath]; v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %q", KubernetesAnnotationIncludeServicePath, v)
+		}
+		includeServicePath = b
+	}
+
+	urls := make(map[string]bool)
+	for _, slice := range slices {
+		port, err := endpointSlicePort(slice, kubeService.Annotations[KubernetesAnnotationPort])
+		if err != nil {
+			return nil, err
+		}
+		if port == 0 {
+			continue
+		}
+
+		for _, endpoint := range slice.Endpoints {
+			// A nil ready condition means the endpoint is ready
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+
+			for _, address := range endpoint.Addresses {
+				url := fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(address, strconv.Itoa(int(port))))
+				urls[url] = true
+			}
+		}
+	}
+
+	sorted := make([]string, 0, len(urls))
+	for url := range urls {
+		sorted = append(sorted, url)
+	}
+	sort.Strings(sorted)
+
+	upstreams := make([]*models.Upstream, 0, len(sorted))
+	for _, url := range sorted {
+		upstreams = append(upstreams, &models.Upstream{
+			URL:                url,
+			IncludeServicePath: includeServicePath,
+		})
+	}
+
+	return upstreams, nil
+}
+
+// endpointSlicePort returns the port of the slice matching the name or number, or the only
+// port if no port was requested.  Zero is returned when the slice has no ports yet.
+func endpointSlicePort(slice *discoveryv1.EndpointSlice, requested string) (int32, error) {
+	if len(slice.Ports) == 0 {
+		return 0, nil
+	}
+
+	if requested == "" {
+		if len(slice.Ports) > 1 {
+			return 0, fmt.Errorf("%s annotation is required for services with more than one port", KubernetesAnnotationPort)
+		}
+		if slice.Ports[0].Port == nil {
+			return 0, nil
+		}
+		return *slice.Ports[0].Port, nil
+	}
+
+	for _, port := range slice.Ports {
+		if port.Port == nil {
+			continue
+		}
+		if port.Name != nil && *port.Name == requested {
+			return *port.Port, nil
+		}
+		if strconv.Itoa(int(*port.Port)) == requested {
+			return *port.Port, nil
+		}
+	}
+
+	return 0, fmt.Errorf("port %q not found", requested)
+}
@@ -0,0 +1,169 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/premkit/premkit/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func kubeService(name string, annotations map[string]string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: annotations,
+		},
+	}
+}
+
+func endpointSlice(serviceName string, portName string, port int32, addresses ...string) *discoveryv1.EndpointSlice {
+	ready := true
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceName + "-abc",
+			Namespace: "default",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: serviceName},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports: []discoveryv1.EndpointPort{
+			discoveryv1.EndpointPort{Name: &portName, Port: &port},
+		},
+	}
+
+	for _, address := range addresses {
+		slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{
+			Addresses:  []string{address},
+			Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+		})
+	}
+
+	return slice
+}
+
+func waitForUpstreams(t *testing.T, registry *models.Registry, name string, expected int) *models.Service {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		service, err := registry.GetServiceByName([]byte(name))
+		require.NoError(t, err)
+		if expected < 0 && service == nil {
+			return nil
+		}
+		if service != nil && len(service.Upstreams) == expected {
+			return service
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("service %q did not have %d upstreams", name, expected)
+	return nil
+}
+
+func TestKubernetesProviderWatch(t *testing.T) {
+	t.Parallel()
+	registry := setup(t)
+
+	client := fake.NewSimpleClientset(
+		kubeService("ui", map[string]string{
+			KubernetesAnnotationServiceName: "ui",
+			KubernetesAnnotationServicePath: "/ui",
+		}),
+		kubeService("unannotated", nil),
+		endpointSlice("ui", "http", 3000, "10.0.0.1", "10.0.0.2"),
+		endpointSlice("unannotated", "http", 3000, "10.0.0.3"),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewKubernetesProvider(registry, client, "default").Watch(ctx)
+
+	service := waitForUpstreams(t, registry, "ui", 2)
+	assert.Equal(t, models.SourceKubernetes, service.Source)
+	assert.Equal(t, "ui", service.Path)
+	assert.Equal(t, "http://10.0.0.1:3000", service.Upstreams[0].URL)
+	assert.Equal(t, "http://10.0.0.2:3000", service.Upstreams[1].URL)
+
+	services, err := registry.ListServices()
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(services), "unannotated services should not be registered")
+
+	// A pod goes away
+	_, err = client.DiscoveryV1().EndpointSlices("default").Update(ctx, endpointSlice("ui", "http", 3000, "10.0.0.2"), metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	service = waitForUpstreams(t, registry, "ui", 1)
+	assert.Equal(t, "http://10.0.0.2:3000", service.Upstreams[0].URL)
+
+	// The service is deleted
+	err = client.CoreV1().Services("default").Delete(ctx, "ui", metav1.DeleteOptions{})
+	require.NoError(t, err)
+
+	waitForUpstreams(t, registry, "ui", -1)
+}
+
+func TestKubernetesProviderMergesServices(t *testing.T) {
+	t.Parallel()
+	registry := setup(t)
+
+	// The same premkit service is annotated in two namespaces
+	annotations := map[string]string{
+		KubernetesAnnotationServiceName: "api",
+		KubernetesAnnotationServicePath: "/api",
+	}
+	blue := kubeService("api", annotations)
+	blue.Namespace = "blue"
+	blueSlice := endpointSlice("api", "http", 3000, "10.0.0.1")
+	blueSlice.Namespace = "blue"
+	green := kubeService("api", annotations)
+	green.Namespace = "green"
+	greenSlice := endpointSlice("api", "http", 3000, "10.0.1.1")
+	greenSlice.Namespace = "green"
+
+	client := fake.NewSimpleClientset(blue, blueSlice, green, greenSlice)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewKubernetesProvider(registry, client, "").Watch(ctx)
+
+	service := waitForUpstreams(t, registry, "api", 2)
+	assert.Equal(t, "api", service.Path)
+	assert.Equal(t, "http://10.0.0.1:3000", service.Upstreams[0].URL)
+	assert.Equal(t, "http://10.0.1.1:3000", service.Upstreams[1].URL)
+}
+
+func TestUpstreamsFromEndpointSlices(t *testing.T) {
+	service := kubeService("api", map[string]string{
+		KubernetesAnnotationServiceName:        "api",
+		KubernetesAnnotationPort:               "https",
+		KubernetesAnnotationScheme:             "https",
+		KubernetesAnnotationIncludeServicePath: "true",
+	})
+
+	notReady := false
+	slice := endpointSlice("api", "https", 8443, "10.0.0.1")
+	httpName := "http"
+	httpPort := int32(8080)
+	slice.Ports = append(slice.Ports, discoveryv1.EndpointPort{Name: &httpName, Port: &httpPort})
+	slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{
+		Addresses:  []string{"10.0.0.2"},
+		Conditions: discoveryv1.EndpointConditions{Ready: &notReady},
+	})
+
+	upstreams, err := upstreamsFromEndpointSlices(service, []*discoveryv1.EndpointSlice{slice})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(upstreams))
+	assert.Equal(t, "https://10.0.0.1:8443", upstreams[0].URL)
+	assert.True(t, upstreams[0].IncludeServicePath)
+
+	delete(service.Annotations, KubernetesAnnotationPort)
+	_, err = upstreamsFromEndpointSlices(service, []*discoveryv1.EndpointSlice{slice})
+	assert.Error(t, err, "a port is required when there is more than one")
+}
@@ -0,0 +1,126 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/models"
+)
+
+// Reconcile makes the services of the registry managed by source match the desired services.
+// Desired services are created, or replaced if they changed, and marked as managed by source.
+// Services that were managed by source and are no longer desired are deleted.  Services
+// registered by another source are left alone.
+func Reconcile(registry *models.Registry, source string, desired []*models.Service) error {
+	current, err := registry.ListServices()
+	if err != nil {
+		return err
+	}
+
+	currentByName := make(map[string]*models.Service)
+	for _, service := range current {
+		currentByName[service.Name] = service
+	}
+
+	desiredByName := make(map[string]bool)
+	for _, service := range desired {
+		if desiredByName[service.Name] {
+			err := fmt.Errorf("Service %q is defined more than once by %s", service.Name, source)
+			log.Error(err)
+			return err
+		}
+		desiredByName[service.Name] = true
+
+		service.Source = source
+
+		existing := currentByName[service.Name]
+		if existing != nil && servicesEqual(existing, service) {
+			continue
+		}
+
+		if existing != nil && existing.Source != source {
+			log.Warningf("Skipping service %q from %s, which is registered by %q", service.Name, source, existing.Source)
+			continue
+		}
+
+		// The service may have been registered by another source since it was listed
+		_, err := registry.ReplaceService(service)
+		if err == models.ErrOtherSource {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		log.Infof("Reconciled service %q from %s", service.Name, source)
+	}
+
+	for _, service := range current {
+		if service.Source != source || desiredByName[service.Name] {
+			continue
+		}
+
+		// The service may have been registered again by another source since it was listed
+		deleted, err := registry.DeleteServiceFromSource([]byte(service.Name), source)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			continue
+		}
+
+		log.Infof("Removed service %q that is no longer defined by %s", service.Name, source)
+	}
+
+	return nil
+}
+
+// servicesEqual returns true if the services have the same definition, ignoring the fields that
+// are set when a service is saved.
+func servicesEqual(a, b *models.Service) bool {
+	aJSON, err := json.Marshal(normalizeService(a))
+	if err != nil {
+		return false
+	}
+
+	bJSON, err := json.Marshal(normalizeService(b))
+	if err != nil {
+		return false
+	}
+
+	return string(aJSON) == string(bJSON)
+}
+
+func normalizeService(service *models.Service) *models.Service {
+	normalized := *service
+	normalized.Registered = time.Time{}
+	normalized.Updated = time.Time{}
+	normalized.Expires = nil
+	normalized.Path = trimSlash(service.Path)
+	if len(normalized.Rewrites) == 0 {
+		normalized.Rewrites = nil
+	}
+
+	normalized.Upstreams = make([]*models.Upstream, 0, len(service.Upstreams))
+	for _, u := range service.Upstreams {
+		upstream := *u
+		upstream.Expires = nil
+		upstream.Draining = false
+		normalized.Upstreams = append(normalized.Upstreams, &upstream)
+	}
+	sort.Slice(normalized.Upstreams, func(i, j int) bool {
+		return normalized.Upstreams[i].URL < normalized.Upstreams[j].URL
+	})
+
+	return &normalized
+}
+
+func trimSlash(path string) string {
+	if len(path) > 0 && path[0] == '/' {
+		return path[1:]
+	}
+	return path
+}
@@ -0,0 +1,103 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/premkit/premkit/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setup(t *testing.T) *models.Registry {
+	return models.NewRegistry(models.NewMemoryStore())
+}
+
+func TestReconcile(t *testing.T) {
+	t.Parallel()
+	registry := setup(t)
+
+	_, err := registry.CreateService(&models.Service{
+		Name:   "api",
+		Path:   "api",
+		Source: models.SourceAPI,
+	})
+	require.NoError(t, err)
+
+	err = Reconcile(registry, models.SourceFile, []*models.Service{
+		&models.Service{Name: "a", Path: "/a", Upstreams: []*models.Upstream{&models.Upstream{URL: "http://a"}}},
+		&models.Service{Name: "b", Path: "/b", Upstreams: []*models.Upstream{&models.Upstream{URL: "http://b"}}},
+	})
+	require.NoError(t, err)
+
+	services, err := registry.ListServices()
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(services))
+
+	a, err := registry.GetServiceByName([]byte("a"))
+	require.NoError(t, err)
+	require.NotNil(t, a)
+	assert.Equal(t, models.SourceFile, a.Source)
+	assert.True(t, a.Managed())
+
+	// Change a, drop b
+	err = Reconcile(registry, models.SourceFile, []*models.Service{
+		&models.Service{Name: "a", Path: "/a", Upstreams: []*models.Upstream{&models.Upstream{URL: "http://a2"}}},
+	})
+	require.NoError(t, err)
+
+	a, err = registry.GetServiceByName([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(a.Upstreams))
+	assert.Equal(t, "http://a2", a.Upstreams[0].URL)
+
+	b, err := registry.GetServiceByName([]byte("b"))
+	require.NoError(t, err)
+	assert.Nil(t, b)
+
+	api, err := registry.GetServiceByName([]byte("api"))
+	require.NoError(t, err)
+	assert.NotNil(t, api, "services from other sources should not be removed")
+
+	// Services from other sources are not taken over
+	err = Reconcile(registry, models.SourceFile, []*models.Service{
+		&models.Service{Name: "a", Path: "/a", Upstreams: []*models.Upstream{&models.Upstream{URL: "http://a2"}}},
+		&models.Service{Name: "api", Path: "/taken"},
+	})
+	require.NoError(t, err)
+
+	api, err = registry.GetServiceByName([]byte("api"))
+	require.NoError(t, err)
+	require.NotNil(t, api)
+	assert.Equal(t, "api", api.Path)
+	assert.Equal(t, models.SourceAPI, api.Source)
+
+	err = Reconcile(registry, models.SourceFile, []*models.Service{
+		&models.Service{Name: "a", Path: "/a"},
+		&models.Service{Name: "a", Path: "/b"},
+	})
+	assert.Error(t, err)
+}
+
+func TestServicesEqual(t *testing.T) {
+	a := &models.Service{
+		Name: "a",
+		Path: "/a",
+		Upstreams: []*models.Upstream{
+			&models.Upstream{URL: "http://2"},
+			&models.Upstream{URL: "http://1"},
+		},
+	}
+	b := &models.Service{
+		Name: "a",
+		Path: "a",
+		Upstreams: []*models.Upstream{
+			&models.Upstream{URL: "http://1"},
+			&models.Upstream{URL: "http://2"},
+		},
+	}
+	assert.True(t, servicesEqual(a, b))
+
+	b.Priority = 1
+	assert.False(t, servicesEqual(a, b))
+}
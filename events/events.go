@@ -0,0 +1,223 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/premkit/premkit/log"
+)
+
+const (
+	// ServiceAdded is published when a service is created.
+	ServiceAdded = "service-added"
+
+	// ServiceUpdated is published when a service is changed, including when its upstreams are.
+	ServiceUpdated = "service-updated"
+
+	// ServiceRemoved is published when a service is deleted.
+	ServiceRemoved = "service-removed"
+
+	// UpstreamAdded is published when an upstream is added to an existing service.
+	UpstreamAdded = "upstream-added"
+
+	// UpstreamRemoved is published when an upstream is removed from a service that still exists.
+	UpstreamRemoved = "upstream-removed"
+
+	// LeaseExpired is published when a service or upstream is removed because its lease was
+	// not renewed in time.
+	LeaseExpired = "lease-expired"
+
+	// HealthChanged is published when an endpoint of an upstream becomes healthy or unhealthy.
+	HealthChanged = "health-changed"
+
+	// Reset is sent to a subscriber, instead of the events it missed, when it resumes after
+	// events that are no longer kept.  The subscriber should read the registry again.
+	Reset = "reset"
+)
+
+// subscriberBuffer is the number of events that can be queued for a subscriber before it is
+// closed.
+const subscriberBuffer = 64
+
+// historySize is the number of recent events kept for subscribers that resume.
+const historySize = 1024
+
+// ErrInvalidID is returned by SubscribeFrom when the ID is not the ID of an event.
+var ErrInvalidID = errors.New("Invalid event id")
+
+// Event is a change to the registry that watchers may want to react to.
+type Event struct {
+	// ID is the epoch of the bus and a number that increases with every event it publishes, as
+	// "<epoch>-<number>", so subscribers can resume after the last event they received, even
+	// across restarts.
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	Service  string    `json:"service,omitempty"`
+	Upstream string    `json:"upstream,omitempty"`
+	Healthy  *bool     `json:"healthy,omitempty"`
+	Time     time.Time `json:"time"`
+
+	// seq is the number in the ID.
+	seq uint64
+}
+
+// Bus delivers the events published about a registry to its subscribers.
+type Bus struct {
+	// epoch identifies the bus in event IDs, so an ID from before a restart is not taken for an
+	// event published since.
+	epoch string
+
+	mu          sync.Mutex
+	subscribers map[chan *Event]struct{}
+
+	// history is a ring of the last historySize events, in which the event with number n is at
+	// n % historySize.
+	history [historySize]*Event
+	lastSeq uint64
+}
+
+// NewBus returns a bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		subscribers: make(map[chan *Event]struct{}),
+	}
+}
+
+func (b *Bus) formatID(seq uint64) string {
+	return fmt.Sprintf("%s-%d", b.epoch, seq)
+}
+
+// Publish sends the event to all current subscribers.  Publish never blocks; the channel of a
+// subscriber that is not keeping up is closed, so it subscribes again with SubscribeFrom and
+// receives the events it missed, or a Reset.
+func (b *Bus) Publish(event *Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastSeq++
+	event.seq = b.lastSeq
+	event.ID = b.formatID(b.lastSeq)
+	b.history[event.seq%historySize] = event
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns a channel that receives every event published after this call, and a
+// function that must be called to stop receiving them.
+func (b *Bus) Subscribe() (<-chan *Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.subscribe(nil)
+}
+
+// SubscribeFrom is Subscribe for a subscriber that resumes after the event with ID after.  The
+// events published since then are received first or, if they are no longer kept or the ID is
+// from before a restart, a Reset event.  ErrInvalidID is returned if after is not an event ID.
+func (b *Bus) SubscribeFrom(after string) (<-chan *Event, func(), error) {
+	i := strings.LastIndex(after, "-")
+	if i < 0 {
+		return nil, nil, ErrInvalidID
+	}
+	seq, err := strconv.ParseUint(after[i+1:], 10, 64)
+	if err != nil {
+		return nil, nil, ErrInvalidID
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if after[:i] != b.epoch || seq > b.lastSeq || b.lastSeq-seq >= historySize {
+		reset := &Event{ID: b.formatID(b.lastSeq), Type: Reset, Time: time.Now(), seq: b.lastSeq}
+		ch, cancel := b.subscribe([]*Event{reset})
+		return ch, cancel, nil
+	}
+
+	missed := make([]*Event, 0, b.lastSeq-seq)
+	for n := seq + 1; n <= b.lastSeq; n++ {
+		missed = append(missed, b.history[n%historySize])
+	}
+
+	ch, cancel := b.subscribe(missed)
+	return ch, cancel, nil
+}
+
+// Watch calls handle with every event published until ctx is done.  When handle falls behind,
+// Watch resumes after the last event it handled, so handle receives the events it missed, or a
+// Reset.
+func (b *Bus) Watch(ctx context.Context, handle func(event *Event)) {
+	ch, cancel := b.Subscribe()
+	defer func() {
+		cancel()
+	}()
+
+	// The ID of the last event handled, to resume after it
+	last := ""
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event, ok := <-ch:
+			if !ok {
+				log.Warningf("Watcher fell behind, resuming after event %q", last)
+				cancel()
+				if last == "" {
+					ch, cancel = b.Subscribe()
+					continue
+				}
+
+				resumed, resumedCancel, err := b.SubscribeFrom(last)
+				if err != nil {
+					log.Error(err)
+					return
+				}
+				ch, cancel = resumed, resumedCancel
+				continue
+			}
+
+			last = event.ID
+			handle(event)
+		}
+	}
+}
+
+// subscribe adds a subscriber that first receives the queued events.  mu must be held.
+func (b *Bus) subscribe(queued []*Event) (<-chan *Event, func()) {
+	ch := make(chan *Event, subscriberBuffer+len(queued))
+	for _, event := range queued {
+		ch <- event
+	}
+
+	b.subscribers[ch] = struct{}{}
+
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return ch, cancel
+}
@@ -0,0 +1,196 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+	bus := NewBus()
+
+	ch, cancel := bus.Subscribe()
+
+	bus.Publish(&Event{Type: LeaseExpired, Service: "service", Upstream: "http://upstream"})
+
+	event := <-ch
+	require.NotNil(t, event)
+	assert.Equal(t, LeaseExpired, event.Type)
+	assert.Equal(t, "service", event.Service)
+	assert.Equal(t, "http://upstream", event.Upstream)
+	assert.False(t, event.Time.IsZero())
+
+	cancel()
+	cancel()
+
+	_, ok := <-ch
+	assert.False(t, ok, "channel should be closed after cancel")
+
+	// Publishing without subscribers should not block
+	bus.Publish(&Event{Type: LeaseExpired})
+}
+
+func TestSubscribeFrom(t *testing.T) {
+	bus := NewBus()
+
+	bus.Publish(&Event{Type: ServiceAdded, Service: "first"})
+	bus.Publish(&Event{Type: ServiceAdded, Service: "second"})
+	bus.Publish(&Event{Type: ServiceRemoved, Service: "first"})
+
+	bus.mu.Lock()
+	last := bus.lastSeq
+	bus.mu.Unlock()
+
+	ch, cancel, err := bus.SubscribeFrom(bus.formatID(last - 2))
+	require.NoError(t, err)
+	defer cancel()
+
+	event := <-ch
+	assert.Equal(t, bus.formatID(last-1), event.ID)
+	assert.Equal(t, "second", event.Service)
+
+	event = <-ch
+	assert.Equal(t, bus.formatID(last), event.ID)
+	assert.Equal(t, ServiceRemoved, event.Type)
+
+	bus.Publish(&Event{Type: UpstreamRemoved, Service: "second", Upstream: "http://upstream"})
+	event = <-ch
+	assert.Equal(t, bus.formatID(last+1), event.ID)
+	assert.Equal(t, UpstreamRemoved, event.Type)
+}
+
+func TestSubscribeFromUnknownID(t *testing.T) {
+	bus := NewBus()
+
+	bus.Publish(&Event{Type: ServiceAdded, Service: "service"})
+
+	bus.mu.Lock()
+	last := bus.lastSeq
+	bus.mu.Unlock()
+
+	// An ID that was not published yet
+	ch, cancel, err := bus.SubscribeFrom(bus.formatID(last + 100))
+	require.NoError(t, err)
+	event := <-ch
+	assert.Equal(t, Reset, event.Type)
+	assert.Equal(t, bus.formatID(last), event.ID)
+	cancel()
+
+	// An ID of a previous process, even one this process also published
+	ch, cancel, err = bus.SubscribeFrom("previous-1")
+	require.NoError(t, err)
+	event = <-ch
+	assert.Equal(t, Reset, event.Type)
+	cancel()
+
+	for i := 0; i < historySize; i++ {
+		bus.Publish(&Event{Type: ServiceUpdated, Service: "service"})
+	}
+
+	// An ID that is no longer kept
+	ch, cancel, err = bus.SubscribeFrom(bus.formatID(last))
+	require.NoError(t, err)
+	defer cancel()
+	event = <-ch
+	assert.Equal(t, Reset, event.Type)
+	assert.Equal(t, bus.formatID(last+historySize), event.ID)
+}
+
+func TestSubscribeFromInvalidID(t *testing.T) {
+	bus := NewBus()
+
+	for _, id := range []string{"", "12", "epoch-x"} {
+		_, _, err := bus.SubscribeFrom(id)
+		assert.Equal(t, ErrInvalidID, err, id)
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	bus := NewBus()
+
+	ch, cancel := bus.Subscribe()
+	defer cancel()
+
+	for i := 0; i < subscriberBuffer+1; i++ {
+		bus.Publish(&Event{Type: ServiceUpdated, Service: "service"})
+	}
+
+	var last *Event
+	for event := range ch {
+		last = event
+	}
+	require.NotNil(t, last, "the queued events should be received before the channel is closed")
+
+	// Resuming after the last event received replays the event that did not fit
+	ch, cancel, err := bus.SubscribeFrom(last.ID)
+	require.NoError(t, err)
+	defer cancel()
+	event := <-ch
+	assert.Equal(t, bus.formatID(last.seq+1), event.ID)
+	assert.Equal(t, ServiceUpdated, event.Type)
+}
+
+func TestWatch(t *testing.T) {
+	bus := NewBus()
+
+	bus.mu.Lock()
+	watchers := len(bus.subscribers)
+	bus.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan *Event, 2*subscriberBuffer)
+	block := make(chan struct{})
+	go bus.Watch(ctx, func(event *Event) {
+		if event.Service == "first" {
+			<-block
+		}
+		received <- event
+	})
+
+	require.Eventually(t, func() bool {
+		bus.mu.Lock()
+		defer bus.mu.Unlock()
+		return len(bus.subscribers) > watchers
+	}, time.Second, time.Millisecond)
+
+	// Fall behind while the first event is handled, so the watcher has to resume
+	bus.Publish(&Event{Type: ServiceAdded, Service: "first"})
+	for i := 0; i < subscriberBuffer+10; i++ {
+		bus.Publish(&Event{Type: ServiceUpdated, Service: "later"})
+	}
+	close(block)
+
+	event := <-received
+	assert.Equal(t, "first", event.Service)
+	for i := 0; i < subscriberBuffer+10; i++ {
+		next := <-received
+		assert.Equal(t, event.seq+1, next.seq, "no event should be missed")
+		event = next
+	}
+}
+
+func TestBusesAreIndependent(t *testing.T) {
+	bus := NewBus()
+	other := NewBus()
+
+	ch, cancel := other.Subscribe()
+	defer cancel()
+
+	bus.Publish(&Event{Type: ServiceAdded, Service: "service"})
+	select {
+	case event := <-ch:
+		t.Fatalf("received %q published on another bus", event.ID)
+	default:
+	}
+
+	// The IDs of a bus are not resumed from on another one
+	resumed, cancelResumed, err := other.SubscribeFrom(bus.formatID(1))
+	require.NoError(t, err)
+	defer cancelResumed()
+	assert.Equal(t, Reset, (<-resumed).Type)
+}
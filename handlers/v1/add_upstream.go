@@ -0,0 +1,115 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/models"
+
+	"github.com/gorilla/mux"
+)
+
+// AddUpstreamParams contains parameters to the add upstream route.
+// swagger:parameters addUpstream
+type AddUpstreamParams struct {
+	// Name of the service.
+	// In: path
+	Name string `json:"name"`
+
+	// Upstream to add.
+	// In: body
+	Upstream *models.Upstream `json:"upstream"`
+
+	// Actor identifies who made the request, from the X-Premkit-Actor header and the client
+	// address.  It is recorded in the history of the service.
+	Actor string `json:"-"`
+}
+
+// AddUpstreamResponse represents the response to an addUpstream call. This response includes
+// a pointer to the updated service.
+// swagger:response addUpstreamResponse
+type AddUpstreamResponse struct {
+	// Service
+	// In: body
+	Body *models.Service `json:"service"`
+}
+
+// AddUpstream is the handler called when a POST is made to add an upstream to a service.
+func (a *API) AddUpstream(response http.ResponseWriter, request *http.Request) {
+	// swagger:route POST /service/{name}/upstream services addUpstream
+	//
+	// Adds an upstream to a registered service, without changing the rest of the service.
+	//
+	//     Consumes:
+	//     - application/json
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Schemes: https
+	//
+	//     Responses:
+	//       201: addUpstreamResponse
+	//       400:
+	//       404:
+	//       409:
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		log.Error(err)
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	params := AddUpstreamParams{}
+	if err := json.Unmarshal(body, &params); err != nil {
+		log.Error(err)
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusBadRequest)
+		return
+	}
+	params.Name = mux.Vars(request)["name"]
+	params.Actor = requestActor(request)
+
+	service, err := a.addUpstream(&params)
+	if err == errManagedService || err == models.ErrNotProxy {
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusConflict)
+		return
+	}
+	if invalid(err) {
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if service == nil {
+		http.Error(response, "Service not found", http.StatusNotFound)
+		return
+	}
+
+	addUpstreamResponse := AddUpstreamResponse{
+		Body: service,
+	}
+	b, err := json.Marshal(addUpstreamResponse)
+	if err != nil {
+		log.Error(err)
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response.WriteHeader(http.StatusCreated)
+	response.Write(b)
+}
+
+func (a *API) addUpstream(params *AddUpstreamParams) (*models.Service, error) {
+	if params.Upstream == nil || params.Upstream.URL == "" {
+		return nil, &models.ValidationError{Err: errors.New("Upstream URL is required")}
+	}
+
+	return a.registry.AddUpstream([]byte(params.Name), params.Upstream, params.Actor)
+}
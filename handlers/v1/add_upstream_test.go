@@ -0,0 +1,67 @@
+package v1
+
+import (
+	"testing"
+
+	"github.com/premkit/premkit/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddUpstream(t *testing.T) {
+	t.Parallel()
+	api := setup(t)
+
+	_, err := api.registry.CreateService(&models.Service{
+		Name:     "test",
+		Path:     "test",
+		Priority: 5,
+	})
+	require.NoError(t, err)
+
+	_, err = api.registry.CreateService(&models.Service{
+		Name:   "managed",
+		Path:   "managed",
+		Source: models.SourceDocker,
+	})
+	require.NoError(t, err)
+
+	service, err := api.addUpstream(&AddUpstreamParams{
+		Name:     "test",
+		Upstream: &models.Upstream{URL: "http://localhost:3000"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(service.Upstreams))
+	assert.Equal(t, 5, service.Priority)
+
+	service, err = api.addUpstream(&AddUpstreamParams{
+		Name:     "missing",
+		Upstream: &models.Upstream{URL: "http://localhost:3000"},
+	})
+	require.NoError(t, err)
+	assert.Nil(t, service)
+
+	_, err = api.addUpstream(&AddUpstreamParams{
+		Name:     "managed",
+		Upstream: &models.Upstream{URL: "http://localhost:3000"},
+	})
+	assert.Equal(t, errManagedService, err)
+
+	_, err = api.registry.CreateService(&models.Service{
+		Name:     "redirect",
+		Path:     "redirect",
+		Kind:     models.ServiceKindRedirect,
+		Redirect: &models.Redirect{URL: "https://example.com"},
+	})
+	require.NoError(t, err)
+
+	_, err = api.addUpstream(&AddUpstreamParams{
+		Name:     "redirect",
+		Upstream: &models.Upstream{URL: "http://localhost:3000"},
+	})
+	assert.Equal(t, models.ErrNotProxy, err)
+
+	_, err = api.addUpstream(&AddUpstreamParams{Name: "test"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,27 @@
+package v1
+
+import (
+	"net"
+
+	"github.com/premkit/premkit/balancer"
+	"github.com/premkit/premkit/models"
+)
+
+// API serves the /premkit/v1 routes, and forwards requests to the services, of a registry.
+type API struct {
+	registry *models.Registry
+	balancer *balancer.Balancer
+
+	// trusted are the networks from which the trace context of forwarded requests is continued.
+	trusted []*net.IPNet
+}
+
+// NewAPI returns the handlers of the services in registry.  The trace context of requests from
+// the trusted networks is continued, and dropped for other requests.
+func NewAPI(registry *models.Registry, trusted []*net.IPNet) *API {
+	return &API{
+		registry: registry,
+		balancer: balancer.New(balancer.NewResolver(nil), registry.Events()),
+		trusted:  trusted,
+	}
+}
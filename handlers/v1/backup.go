@@ -0,0 +1,41 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/premkit/premkit/persistence"
+
+	"github.com/boltdb/bolt"
+)
+
+// Backup is the handler called when a GET is made to download a copy of the database.  It is
+// only routed on the admin listener.
+func (a *API) Backup(response http.ResponseWriter, request *http.Request) {
+	// swagger:route GET /backup backup backup
+	//
+	// Returns a consistent copy of the database file, while the daemon continues to run.  Only
+	// served on the admin port.
+	//
+	//     Produces:
+	//     - application/octet-stream
+	//
+	//     Schemes: https
+	//
+	//     Responses:
+	//       200:
+	//       501:
+	boltStore, ok := a.registry.Store().(interface{ DB() *bolt.DB })
+	if !ok {
+		http.Error(response, "The registry is not stored in a database file", http.StatusNotImplemented)
+		return
+	}
+
+	filename := fmt.Sprintf("premkit-%s.db", time.Now().UTC().Format("20060102T150405Z"))
+	response.Header().Set("Content-Type", "application/octet-stream")
+	response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	// Headers are already written once the copy starts, so errors can only be logged
+	persistence.Backup(boltStore.DB(), response)
+}
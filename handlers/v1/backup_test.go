@@ -0,0 +1,55 @@
+package v1
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/premkit/premkit/models"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackup(t *testing.T) {
+	dirName := t.TempDir()
+
+	db, err := bolt.Open(path.Join(dirName, "test.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	api := NewAPI(models.NewRegistry(models.NewBoltStore(db, nil)), nil)
+
+	_, err = api.registry.CreateService(&models.Service{Name: "test", Path: "test"})
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	api.Backup(recorder, httptest.NewRequest("GET", "/backup", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("Content-Disposition"), "attachment")
+
+	backupFile := path.Join(dirName, "backup.db")
+	require.NoError(t, ioutil.WriteFile(backupFile, recorder.Body.Bytes(), 0600))
+
+	backup, err := bolt.Open(backupFile, 0600, nil)
+	require.NoError(t, err)
+	defer backup.Close()
+
+	err = backup.View(func(tx *bolt.Tx) error {
+		assert.NotNil(t, tx.Bucket([]byte("service:test")))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBackupMemoryStore(t *testing.T) {
+	t.Parallel()
+	api := setup(t)
+
+	recorder := httptest.NewRecorder()
+	api.Backup(recorder, httptest.NewRequest("GET", "/backup", nil))
+	assert.Equal(t, http.StatusNotImplemented, recorder.Code)
+}
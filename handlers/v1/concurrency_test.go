@@ -0,0 +1,137 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/premkit/premkit/models"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConcurrentRegistrations registers, replaces and deregisters services from many goroutines
+// while requests are proxied to a service that is replaced the whole time.  Every proxied request
+// should reach the upstream.
+func TestConcurrentRegistrations(t *testing.T) {
+	dirName := t.TempDir()
+
+	db, err := bolt.Open(path.Join(dirName, "test.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	api := NewAPI(models.NewRegistry(models.NewBoltStore(db, nil)), nil)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	stable := func() *RegisterServiceParams {
+		return &RegisterServiceParams{
+			ReplaceExisting: true,
+			Service: &models.Service{
+				Name: "stable",
+				Path: "stable",
+				Upstreams: []*models.Upstream{
+					&models.Upstream{URL: upstream.URL},
+				},
+			},
+		}
+	}
+	_, err = api.registerService(stable())
+	require.NoError(t, err)
+
+	const writers = 8
+	const iterations = 25
+
+	errs := make(chan error, writers*iterations*4)
+	done := make(chan struct{})
+
+	var proxies sync.WaitGroup
+	proxied := make([]int, 4)
+	for p := range proxied {
+		proxies.Add(1)
+		go func(p int) {
+			defer proxies.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				recorder := httptest.NewRecorder()
+				api.ForwardService(recorder, httptest.NewRequest("GET", "/stable/path", nil))
+				if recorder.Code != http.StatusOK {
+					errs <- fmt.Errorf("Proxied request returned %d", recorder.Code)
+					return
+				}
+				proxied[p]++
+			}
+		}(p)
+	}
+
+	var writes sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		writes.Add(1)
+		go func(w int) {
+			defer writes.Done()
+			for i := 0; i < iterations; i++ {
+				if _, err := api.registerService(stable()); err != nil {
+					errs <- err
+				}
+
+				name := fmt.Sprintf("churn-%d", w)
+				_, err := api.registerService(&RegisterServiceParams{
+					Service: &models.Service{
+						Name: name,
+						Path: name,
+						Upstreams: []*models.Upstream{
+							&models.Upstream{URL: fmt.Sprintf("http://localhost:%d", 3000+i), TTL: 1},
+						},
+					},
+				})
+				if err != nil {
+					errs <- err
+				}
+
+				if err := api.registry.ExpireLeases(time.Now()); err != nil {
+					errs <- err
+				}
+
+				found, err := api.deregisterService(&DeregisterServiceParams{Name: name})
+				if err != nil {
+					errs <- err
+				}
+				if !found {
+					errs <- fmt.Errorf("Service %q was not found", name)
+				}
+			}
+		}(w)
+	}
+
+	writes.Wait()
+	close(done)
+	proxies.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	for p, count := range proxied {
+		assert.NotZero(t, count, "proxy %d did not forward any requests", p)
+	}
+
+	services, err := api.registry.ListServices()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(services), "only the stable service should be left")
+	assert.Equal(t, 1, len(services[0].Upstreams))
+}
@@ -0,0 +1,65 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// DeregisterServiceParams contains parameters to the deregister service route.
+// swagger:parameters deregisterService
+type DeregisterServiceParams struct {
+	// Name of the service to deregister.
+	// In: path
+	Name string `json:"name"`
+
+	// URL of the upstream to remove.  When not set, the service and all of its upstreams are removed.
+	// In: query
+	Upstream string `json:"upstream"`
+
+	// Actor identifies who made the request, from the X-Premkit-Actor header and the client
+	// address.  It is recorded in the history of the service.
+	Actor string `json:"-"`
+}
+
+// DeregisterService is the handler called when a DELETE is made to remove a service, or one of
+// its upstreams.
+func (a *API) DeregisterService(response http.ResponseWriter, request *http.Request) {
+	// swagger:route DELETE /service/{name} services deregisterService
+	//
+	// Removes a service, or one of its upstreams, from the router.
+	//
+	//     Schemes: https
+	//
+	//     Responses:
+	//       204:
+	//       404:
+	//       409:
+	params := DeregisterServiceParams{
+		Name:     mux.Vars(request)["name"],
+		Upstream: request.URL.Query().Get("upstream"),
+		Actor:    requestActor(request),
+	}
+
+	found, err := a.deregisterService(&params)
+	if err == errManagedService {
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if !found {
+		http.Error(response, "Service not found", http.StatusNotFound)
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) deregisterService(params *DeregisterServiceParams) (bool, error) {
+	return a.registry.DeregisterService([]byte(params.Name), []byte(params.Upstream), params.Actor)
+}
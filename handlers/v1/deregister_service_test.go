@@ -0,0 +1,63 @@
+package v1
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/premkit/premkit/models"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeregisterService(t *testing.T) {
+	t.Parallel()
+	api := setup(t)
+
+	_, err := api.registry.CreateService(&models.Service{
+		Name: "test",
+		Path: "test",
+		Upstreams: []*models.Upstream{
+			&models.Upstream{URL: "http://localhost:3000"},
+			&models.Upstream{URL: "http://localhost:3001"},
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = api.registry.CreateService(&models.Service{
+		Name:   "managed",
+		Path:   "managed",
+		Source: models.SourceFile,
+	})
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/service/{name}", api.DeregisterService).Methods("DELETE")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/service/test?upstream=http://localhost:3000", nil))
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+
+	service, err := api.registry.GetServiceByName([]byte("test"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(service.Upstreams))
+	assert.Equal(t, "http://localhost:3001", service.Upstreams[0].URL)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/service/test?upstream=http://localhost:3000", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/service/test", nil))
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+
+	service, err = api.registry.GetServiceByName([]byte("test"))
+	require.NoError(t, err)
+	assert.Nil(t, service)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/service/managed", nil))
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+}
@@ -0,0 +1,58 @@
+package v1
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/premkit/premkit/models"
+)
+
+// redirectTemplateData is the data available to redirect URL templates.
+type redirectTemplateData struct {
+	Host     string
+	Path     string
+	FullPath string
+	Query    string
+}
+
+// serveRedirect answers the request with the redirect configured for a redirect service.
+func serveRedirect(response http.ResponseWriter, request *http.Request, service *models.Service) {
+	t, err := service.Redirect.Template()
+	if err != nil {
+		requestError(response, request, err, http.StatusInternalServerError)
+		return
+	}
+
+	data := redirectTemplateData{
+		Host:     request.Host,
+		Path:     createForwardPath(service.Path, request.URL.Path),
+		FullPath: request.URL.Path,
+		Query:    request.URL.RawQuery,
+	}
+
+	var location bytes.Buffer
+	if err := t.Execute(&location, data); err != nil {
+		requestError(response, request, err, http.StatusInternalServerError)
+		return
+	}
+
+	requestLog(request).Debugf("Redirecting %q to %q for service %q", request.URL.Path, location.String(), service.Name)
+	http.Redirect(response, request, location.String(), service.Redirect.StatusCode())
+}
+
+// serveStaticResponse answers the request with the fixed response configured for a static service.
+func serveStaticResponse(response http.ResponseWriter, request *http.Request, service *models.Service) {
+	for name, value := range service.Response.Headers {
+		response.Header().Set(name, value)
+	}
+
+	status := service.Response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	response.WriteHeader(status)
+	if request.Method != "HEAD" {
+		response.Write([]byte(service.Response.Body))
+	}
+}
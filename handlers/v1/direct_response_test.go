@@ -0,0 +1,81 @@
+package v1
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/premkit/premkit/models"
+	"github.com/premkit/premkit/requestid"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardServiceRedirect(t *testing.T) {
+	t.Parallel()
+	api := setup(t)
+
+	_, err := api.registry.CreateService(&models.Service{
+		Name: "docs",
+		Path: "/docs",
+		Kind: models.ServiceKindRedirect,
+		Redirect: &models.Redirect{
+			URL:       "https://docs.example.com{{.Path}}?{{.Query}}",
+			Permanent: true,
+		},
+	})
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	api.ForwardService(recorder, httptest.NewRequest("GET", "/docs/install?v=2", nil))
+
+	assert.Equal(t, http.StatusMovedPermanently, recorder.Code)
+	assert.Equal(t, "https://docs.example.com/install?v=2", recorder.Header().Get("Location"))
+
+	// Failures are reported with the ID of the request
+	_, err = api.registry.CreateService(&models.Service{
+		Name:     "broken",
+		Path:     "/broken",
+		Kind:     models.ServiceKindRedirect,
+		Redirect: &models.Redirect{URL: "https://example.com{{.Missing}}"},
+	})
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/broken", nil)
+	request = request.WithContext(requestid.NewContext(request.Context(), "abc-123"))
+	recorder = httptest.NewRecorder()
+	api.ForwardService(recorder, request)
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "(request id abc-123)")
+}
+
+func TestForwardServiceStaticResponse(t *testing.T) {
+	t.Parallel()
+	api := setup(t)
+
+	_, err := api.registry.CreateService(&models.Service{
+		Name: "robots",
+		Path: "/robots.txt",
+		Kind: models.ServiceKindStatic,
+		Response: &models.StaticResponse{
+			Headers: map[string]string{"Content-Type": "text/plain"},
+			Body:    "User-agent: *\nDisallow: /\n",
+		},
+		Headers: &models.Headers{
+			Response: &models.HeaderRules{
+				Set: map[string]string{"X-Served-By": "{{.Service}}"},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	api.ForwardService(recorder, httptest.NewRequest("GET", "/robots.txt", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "text/plain", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "robots", recorder.Header().Get("X-Served-By"))
+	assert.Equal(t, "User-agent: *\nDisallow: /\n", recorder.Body.String())
+}
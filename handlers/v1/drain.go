@@ -0,0 +1,103 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/models"
+
+	"github.com/gorilla/mux"
+)
+
+// DrainParams contains parameters to the drain route.
+// swagger:parameters drain
+type DrainParams struct {
+	// Name of the service to drain.
+	// In: path
+	Name string `json:"name"`
+
+	// URL of the upstream to drain.  When not set, all upstreams of the service are drained.
+	// In: query
+	Upstream string `json:"upstream"`
+
+	// Undo stops draining, so requests are forwarded to the upstreams again.
+	// In: query
+	Undo bool `json:"undo"`
+
+	// Actor identifies who made the request, from the X-Premkit-Actor header and the client
+	// address.  It is recorded in the history of the service.
+	Actor string `json:"-"`
+}
+
+// DrainResponse represents the response to a drain call. This response includes a pointer to
+// the drained service.
+// swagger:response drainResponse
+type DrainResponse struct {
+	// Service
+	// In: body
+	Body *models.Service `json:"service"`
+}
+
+// Drain is the handler called when a PUT is made to stop forwarding new requests to upstreams.
+// Services managed by another source cannot be drained.
+func (a *API) Drain(response http.ResponseWriter, request *http.Request) {
+	// swagger:route PUT /service/{name}/drain services drain
+	//
+	// Stops, or with undo resumes, forwarding requests to the upstreams of a service.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Schemes: https
+	//
+	//     Responses:
+	//       200: drainResponse
+	//       400:
+	//       404:
+	//       409:
+	params := DrainParams{
+		Name:     mux.Vars(request)["name"],
+		Upstream: request.URL.Query().Get("upstream"),
+		Actor:    requestActor(request),
+	}
+
+	if undo := request.URL.Query().Get("undo"); undo != "" {
+		b, err := strconv.ParseBool(undo)
+		if err != nil {
+			http.Error(response, fmt.Sprintf("Invalid undo %q", undo), http.StatusBadRequest)
+			return
+		}
+		params.Undo = b
+	}
+
+	service, err := a.registry.SetDraining([]byte(params.Name), []byte(params.Upstream), !params.Undo, params.Actor)
+	if err == errManagedService {
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if service == nil {
+		http.Error(response, "Service not found", http.StatusNotFound)
+		return
+	}
+
+	drainResponse := DrainResponse{
+		Body: service,
+	}
+	b, err := json.Marshal(drainResponse)
+	if err != nil {
+		log.Error(err)
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response.WriteHeader(http.StatusOK)
+	response.Write(b)
+}
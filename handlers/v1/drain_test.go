@@ -0,0 +1,83 @@
+package v1
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/premkit/premkit/models"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrain(t *testing.T) {
+	t.Parallel()
+	api := setup(t)
+
+	_, err := api.registry.CreateService(&models.Service{
+		Name: "test",
+		Path: "test",
+		Upstreams: []*models.Upstream{
+			&models.Upstream{URL: "http://localhost:3000"},
+			&models.Upstream{URL: "http://localhost:3001"},
+		},
+	})
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/service/{name}/drain", api.Drain).Methods("PUT")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("PUT", "/service/test/drain", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	service, err := api.registry.GetServiceByName([]byte("test"))
+	require.NoError(t, err)
+	for _, upstream := range service.Upstreams {
+		assert.True(t, upstream.Draining, upstream.URL)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("PUT", "/service/test/drain?upstream=http://localhost:3001&undo=true", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	service, err = api.registry.GetServiceByName([]byte("test"))
+	require.NoError(t, err)
+	for _, upstream := range service.Upstreams {
+		assert.Equal(t, upstream.URL == "http://localhost:3000", upstream.Draining, upstream.URL)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("PUT", "/service/test/drain?upstream=http://localhost:3002", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("PUT", "/service/test/drain?undo=maybe", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestDrainManagedService(t *testing.T) {
+	t.Parallel()
+	api := setup(t)
+
+	_, err := api.registry.CreateService(&models.Service{
+		Name:      "managed",
+		Path:      "managed",
+		Source:    models.SourceFile,
+		Upstreams: []*models.Upstream{&models.Upstream{URL: "http://localhost:3000"}},
+	})
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/service/{name}/drain", api.Drain).Methods("PUT")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("PUT", "/service/managed/drain", nil))
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+
+	service, err := api.registry.GetServiceByName([]byte("managed"))
+	require.NoError(t, err)
+	assert.False(t, service.Upstreams[0].Draining)
+}
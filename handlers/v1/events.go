@@ -0,0 +1,107 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/premkit/premkit/events"
+	"github.com/premkit/premkit/log"
+)
+
+// eventsKeepAlive is how often a comment is sent on an idle event stream, so proxies and load
+// balancers in between do not close it.
+var eventsKeepAlive = 15 * time.Second
+
+// EventsParams contains parameters to the events route.
+// swagger:parameters events
+type EventsParams struct {
+	// ID of the last event received, to resume after it.  Browsers send it as the
+	// Last-Event-ID header when they reconnect.
+	// In: query
+	After string `json:"after"`
+}
+
+// Events is the handler called when a GET is made to watch changes to the registry.  Events are
+// sent as server-sent events until the client disconnects.
+func (a *API) Events(response http.ResponseWriter, request *http.Request) {
+	// swagger:route GET /events events events
+	//
+	// Streams changes to services and upstreams, lease expirations and health changes as they
+	// happen.  A reset event is sent first when the events after the resumed ID are no longer
+	// kept, and the services should be listed again.
+	//
+	//     Produces:
+	//     - text/event-stream
+	//
+	//     Schemes: https
+	//
+	//     Responses:
+	//       200:
+	//       400:
+	//       500:
+	params := EventsParams{
+		After: request.URL.Query().Get("after"),
+	}
+	if lastEventID := request.Header.Get("Last-Event-ID"); lastEventID != "" {
+		params.After = lastEventID
+	}
+
+	flusher, ok := response.(http.Flusher)
+	if !ok {
+		http.Error(response, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var ch <-chan *events.Event
+	var cancel func()
+	if params.After == "" {
+		ch, cancel = a.registry.Events().Subscribe()
+	} else {
+		var err error
+		ch, cancel, err = a.registry.Events().SubscribeFrom(params.After)
+		if err != nil {
+			http.Error(response, fmt.Sprintf("Invalid event id %q", params.After), http.StatusBadRequest)
+			return
+		}
+	}
+	defer cancel()
+
+	response.Header().Set("Content-Type", "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	response.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(response, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			b, err := json.Marshal(event)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+
+			if _, err := fmt.Fprintf(response, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, b); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package v1
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/premkit/premkit/events"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readEvent reads the next event from a server-sent event stream.
+func readEvent(t *testing.T, reader *bufio.Reader) (string, *events.Event) {
+	id := ""
+	event := &events.Event{}
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "":
+			if id != "" {
+				return id, event
+			}
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), event))
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	api := setup(t)
+	bus := api.registry.Events()
+
+	server := httptest.NewServer(http.HandlerFunc(api.Events))
+	defer server.Close()
+
+	response, err := http.Get(server.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+	reader := bufio.NewReader(response.Body)
+
+	bus.Publish(&events.Event{Type: events.ServiceAdded, Service: "first"})
+	bus.Publish(&events.Event{Type: events.ServiceAdded, Service: "second"})
+
+	firstID, event := readEvent(t, reader)
+	assert.Equal(t, events.ServiceAdded, event.Type)
+	assert.Equal(t, "first", event.Service)
+	assert.Equal(t, firstID, event.ID)
+
+	_, event = readEvent(t, reader)
+	assert.Equal(t, "second", event.Service)
+	response.Body.Close()
+
+	// Resuming after the first event receives the second again
+	request, err := http.NewRequest("GET", server.URL, nil)
+	require.NoError(t, err)
+	request.Header.Set("Last-Event-ID", firstID)
+	response, err = http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	_, event = readEvent(t, bufio.NewReader(response.Body))
+	assert.Equal(t, "second", event.Service)
+}
+
+func TestEventsInvalidID(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	setup(t).Events(recorder, httptest.NewRequest("GET", "/events?after=abc", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
@@ -1,20 +1,32 @@
 package v1
 
 import (
-	"crypto/tls"
-	"errors"
+	"context"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/premkit/premkit/accesslog"
+	"github.com/premkit/premkit/balancer"
 	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/metrics"
 	"github.com/premkit/premkit/models"
+	"github.com/premkit/premkit/requestid"
+	"github.com/premkit/premkit/tracing"
+	"github.com/premkit/premkit/utils"
 
 	"github.com/sirupsen/logrus"
-	cleanhttp "github.com/hashicorp/go-cleanhttp"
 	"github.com/vulcand/oxy/forward"
+	oxyutils "github.com/vulcand/oxy/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -22,22 +34,33 @@ var (
 	fwdInsecure *forward.Forwarder
 )
 
+// RunBalancer makes the balancer of forwarded requests forget services and upstreams as they are
+// removed, until ctx is done.
+func (a *API) RunBalancer(ctx context.Context) {
+	a.balancer.Run(ctx)
+}
+
+// forwardResultKey is the context key of the *forwardResult of a forwarded request.
+type forwardResultKey struct{}
+
+// forwardResult records whether the forwarder failed to reach the upstream.
+type forwardResult struct {
+	failed bool
+}
+
 func init() {
-	insecureTransport := cleanhttp.DefaultTransport()
-	insecureTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	insecureRoundTripper := forward.RoundTripper(insecureTransport)
+	insecureRoundTripper := forward.RoundTripper(newForwardTransport(true))
 	logger := forward.Logger(logrus.StandardLogger())
-	f, err := forward.New(insecureRoundTripper, logger)
+	errorHandler := forward.ErrorHandler(oxyutils.ErrorHandlerFunc(forwardError))
+	f, err := forward.New(insecureRoundTripper, logger, errorHandler)
 	if err != nil {
 		log.Error(err)
 		os.Exit(1)
 	}
 	fwdInsecure = f
 
-	secureTransport := cleanhttp.DefaultTransport()
-	secureTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: false}
-	secureRoundTripper := forward.RoundTripper(secureTransport)
-	f, err = forward.New(secureRoundTripper)
+	secureRoundTripper := forward.RoundTripper(newForwardTransport(false))
+	f, err = forward.New(secureRoundTripper, logger, errorHandler)
 	if err != nil {
 		log.Error(err)
 		os.Exit(1)
@@ -48,56 +71,119 @@ func init() {
 // TODO Swagger this handler, but it's a special handler and therefore a little trickier to swagger-ify
 
 // ForwardService is the handler for anything that should be possibly fowarded to an upstream.
-func ForwardService(response http.ResponseWriter, request *http.Request) {
-	// TODO keep these cached because in any reasonable load this will be painful
-	services, err := models.ListServices()
-	if err != nil {
-		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
-		return
+func (a *API) ForwardService(response http.ResponseWriter, request *http.Request) {
+	start := time.Now()
+	method := request.Method
+
+	// Only callers from trusted networks can continue their trace, or pass baggage to upstreams
+	parent := request.Context()
+	if requestid.Trusted(a.trusted, request.RemoteAddr) {
+		parent = tracing.Extract(parent, request.Header)
+	} else {
+		tracing.Strip(request.Header)
 	}
 
-	var service *models.Service
+	ctx, span := tracing.Tracer().Start(parent, method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", method),
+			attribute.String("url.path", request.URL.Path),
+			attribute.String("server.address", request.Host),
+			attribute.String("client.address", request.RemoteAddr),
+		))
+	defer span.End()
+
+	if id := requestid.FromContext(request.Context()); id != "" {
+		span.SetAttributes(attribute.String("premkit.request_id", id))
+	}
 
-	log.Debugf("Looking for a known route with prefix %q", request.URL.Path)
-	for _, s := range services {
-		if !isPathPrefix(s.Path, request.URL.Path) {
-			log.Debugf("Service with path %q did not match", s.Path)
-			continue
-		}
+	recorder := utils.NewResponseRecorder(response)
+	route := &forwardRoute{}
+	a.forwardService(recorder, request.WithContext(ctx), route)
 
-		log.Debugf("path %q matched service %q (service path %q)", request.URL.Path, s.Name, s.Path)
-		service = s
+	span.SetAttributes(attribute.Int("http.response.status_code", recorder.Status))
+	if route.service != "" {
+		span.SetAttributes(attribute.String("premkit.service", route.service))
+	}
+	if route.endpoint != "" {
+		span.SetAttributes(attribute.String("premkit.upstream", route.endpoint))
+	}
+	if recorder.Status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(recorder.Status))
+	}
 
-		break
+	metrics.ObserveRequest(route.service, route.upstream, method, recorder.Status, time.Since(start))
+
+	if entry := accesslog.FromContext(request.Context()); entry != nil {
+		entry.Service = route.service
+		entry.Upstream = route.endpoint
+		entry.UpstreamLatency = route.upstreamLatency
+	}
+}
+
+// forwardRoute is the service, upstream and endpoint a request was routed to, as far as it was
+// routed, and how long the upstream took to answer.
+type forwardRoute struct {
+	service         string
+	upstream        string
+	endpoint        string
+	upstreamLatency time.Duration
+}
+
+func (a *API) forwardService(response http.ResponseWriter, request *http.Request, route *forwardRoute) {
+	now := time.Now()
+
+	service, err := a.lookupService(request, now)
+	if err != nil {
+		requestError(response, request, err, http.StatusInternalServerError)
+		return
 	}
 
 	if service == nil {
-		response.WriteHeader(http.StatusNotFound)
-		response.Write([]byte(""))
+		writeError(response, request, "", http.StatusNotFound)
 		return
 	}
+	route.service = service.Name
 
-	if len(service.Upstreams) == 0 {
-		err := errors.New("No upstreams are available")
-		log.Error(err)
-		response.WriteHeader(http.StatusBadGateway)
-		response.Write([]byte(""))
+	switch service.Kind {
+	case models.ServiceKindRedirect:
+		data := newHeaderTemplateData(request, service, nil)
+		serveRedirect(rewriteResponseHeaders(response, service, data), request, service)
+		return
+
+	case models.ServiceKindStatic:
+		data := newHeaderTemplateData(request, service, nil)
+		serveStaticResponse(rewriteResponseHeaders(response, service, data), request, service)
 		return
 	}
 
-	// TODO pick an upstream with some intelligence
+	target, err := a.selectUpstream(request, service, now)
+	if err != nil {
+		requestLog(request).Error(err)
+		writeError(response, request, "", http.StatusBadGateway)
+		return
+	}
 
-	// The upstream we will forward to
-	upstream := service.Upstreams[0]
+	route.upstream = target.Upstream.URL
+	route.endpoint = target.Endpoint
+
+	// The upstream we will forward to, addressed by the endpoint that was picked.  Addresses its
+	// hostname resolved to are only dialed, and websockets are dialed by the hostname.
+	endpoint := *target.Upstream
+	upstream := &endpoint
+	dial := newDialEndpoint(target)
+	if dial == nil {
+		endpoint.URL = target.Endpoint
+	}
 
 	url, err := getForwardURLForServiceRequest(upstream, service, request.URL)
 	if err != nil {
-		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		requestError(response, request, err, http.StatusInternalServerError)
 		return
 	}
 
 	if url == nil {
-		http.Error(response, "Route not found", http.StatusNotFound)
+		writeError(response, request, "Route not found", http.StatusNotFound)
 		return
 	}
 
@@ -109,12 +195,149 @@ func ForwardService(response http.ResponseWriter, request *http.Request) {
 		request.RequestURI = fmt.Sprintf("%s?%s", url.Path, url.Query().Encode())
 	}
 
+	if service.Headers != nil {
+		data := newHeaderTemplateData(request, service, upstream)
+		applyHeaderRules(service.Headers.Request, request.Header, data)
+		response = rewriteResponseHeaders(response, service, data)
+	}
+
+	result := &forwardResult{}
+	forwardCtx := context.WithValue(request.Context(), forwardResultKey{}, result)
+	if dial != nil {
+		forwardCtx = context.WithValue(forwardCtx, dialEndpointKey{}, dial)
+	}
+	request = request.WithContext(forwardCtx)
+
+	ctx, span := tracing.Tracer().Start(request.Context(), "forward",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("url.full", url.String())))
+	tracing.Inject(ctx, request.Header)
+
+	done := metrics.StartForwarding(route.service, route.upstream, request.Method)
+	forwardStart := time.Now()
 	if upstream.InsecureSkipVerify {
 		fwdInsecure.ServeHTTP(response, request)
-		return
+	} else {
+		fwdSecure.ServeHTTP(response, request)
+	}
+	route.upstreamLatency = time.Since(forwardStart)
+	done()
+
+	if result.failed {
+		span.SetStatus(codes.Error, "The upstream could not be reached")
+	}
+	span.End()
+
+	a.balancer.Report(target, !result.failed)
+}
+
+// lookupService returns the service that should answer the request, or nil if no service matches.
+func (a *API) lookupService(request *http.Request, now time.Time) (*models.Service, error) {
+	_, span := tracing.Tracer().Start(request.Context(), "route lookup")
+	defer span.End()
+
+	// TODO keep these cached because in any reasonable load this will be painful
+	services, err := a.registry.ListServices()
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	sortServices(services)
+
+	logger := requestLog(request)
+	logger.Debugf("Looking for a known route with prefix %q", request.URL.Path)
+	for _, s := range services {
+		if s.Expired(now) {
+			logger.Debugf("Service %q has an expired lease", s.Name)
+			continue
+		}
+
+		if !isPathPrefix(s.Path, request.URL.Path) {
+			logger.Debugf("Service with path %q did not match", s.Path)
+			continue
+		}
+
+		if !s.Match.Matches(request) {
+			logger.Debugf("Service %q did not match the request predicates", s.Name)
+			continue
+		}
+
+		logger.Debugf("path %q matched service %q (service path %q)", request.URL.Path, s.Name, s.Path)
+		span.SetAttributes(attribute.String("premkit.service", s.Name))
+		return s, nil
+	}
+
+	return nil, nil
+}
+
+// selectUpstream picks the endpoint of an upstream of the service to forward the request to,
+// skipping upstreams that expired or are draining.
+func (a *API) selectUpstream(request *http.Request, service *models.Service, now time.Time) (*balancer.Target, error) {
+	_, span := tracing.Tracer().Start(request.Context(), "upstream selection")
+	defer span.End()
+
+	upstreams := make([]*models.Upstream, 0, 0)
+	for _, u := range service.Upstreams {
+		if !u.Expired(now) && !u.Draining {
+			upstreams = append(upstreams, u)
+		}
+	}
+
+	if len(upstreams) == 0 {
+		span.SetStatus(codes.Error, balancer.ErrNoEndpoints.Error())
+		return nil, balancer.ErrNoEndpoints
+	}
+
+	target, err := a.balancer.Pick(service, upstreams)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.String("premkit.upstream", target.Endpoint))
+	return target, nil
+}
+
+// forwardError is called by the forwarders when the upstream could not be reached.
+func forwardError(response http.ResponseWriter, request *http.Request, err error) {
+	if result, ok := request.Context().Value(forwardResultKey{}).(*forwardResult); ok {
+		result.failed = true
 	}
 
-	fwdSecure.ServeHTTP(response, request)
+	// The status the default handler of the forwarders answers with
+	status := http.StatusInternalServerError
+	if e, ok := err.(net.Error); ok {
+		if e.Timeout() {
+			status = http.StatusGatewayTimeout
+		} else {
+			status = http.StatusBadGateway
+		}
+	} else if err == io.EOF {
+		status = http.StatusBadGateway
+	}
+
+	// Only the status text is returned, so clients do not see the addresses of upstreams
+	requestLog(request).Errorf("Failed to forward the request: %v", err)
+	writeError(response, request, http.StatusText(status), status)
+}
+
+// sortServices orders services in the order they should be evaluated for a request: by priority,
+// then by the most specific path.
+func sortServices(services []*models.Service) {
+	sort.SliceStable(services, func(i, j int) bool {
+		if services[i].Priority != services[j].Priority {
+			return services[i].Priority > services[j].Priority
+		}
+
+		iPath := stripLeadingSlashIfPresent(services[i].Path)
+		jPath := stripLeadingSlashIfPresent(services[j].Path)
+		if len(iPath) != len(jPath) {
+			return len(iPath) > len(jPath)
+		}
+
+		return services[i].Name < services[j].Name
+	})
 }
 
 func stripLeadingSlashIfPresent(path string) string {
@@ -137,28 +360,44 @@ func createForwardPath(servicePath, requestPath string) string {
 }
 
 // TODO refactor this out into a new module
-func getForwardURLForServiceRequest(upstream *models.Upstream, service *models.Service, url *url.URL) (*url.URL, error) {
-	childPath := createForwardPath(service.Path, url.Path)
-	//request.RequestURI = childPath
-
-	// The built url we will forward to
-	upstreamURL := ""
+func getForwardURLForServiceRequest(upstream *models.Upstream, service *models.Service, requestURL *url.URL) (*url.URL, error) {
+	forwardPath := createForwardPath(service.Path, requestURL.Path)
 	if upstream.IncludeServicePath {
-		upstreamURL = fmt.Sprintf("%s/%s%s", upstream.URL, service.Path, childPath)
-	} else {
-		upstreamURL = fmt.Sprintf("%s%s", upstream.URL, childPath)
+		forwardPath = fmt.Sprintf("/%s%s", service.Path, forwardPath)
 	}
 
-	if len(url.Query()) > 0 {
-		upstreamURL = fmt.Sprintf("%s?%s", upstreamURL, url.Query().Encode())
+	for _, rewrite := range service.Rewrites {
+		rewritten, err := rewrite.Apply(forwardPath)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		forwardPath = rewritten
 	}
-	upstreamURL = strings.TrimPrefix(upstreamURL, "/")
 
-	url, err := url.Parse(upstreamURL)
+	// A rewrite can add a query to the path, which the query of the request is merged into
+	forwardPath, rewriteQuery, _ := strings.Cut(forwardPath, "?")
+
+	// The built url we will forward to
+	upstreamURL := strings.TrimPrefix(fmt.Sprintf("%s%s", upstream.URL, forwardPath), "/")
+
+	forwardURL, err := url.Parse(upstreamURL)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
 
-	return url, nil
+	query, err := url.ParseQuery(rewriteQuery)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	for key, values := range requestURL.Query() {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	forwardURL.RawQuery = query.Encode()
+
+	return forwardURL, nil
 }
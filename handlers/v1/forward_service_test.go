@@ -1,9 +1,22 @@
 package v1
 
 import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 
+	"github.com/premkit/premkit/accesslog"
+	"github.com/premkit/premkit/models"
+	"github.com/premkit/premkit/requestid"
+	"github.com/premkit/premkit/tracing"
+
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 func TestStripLeadingSlashIfPresent(t *testing.T) {
@@ -56,3 +69,229 @@ func TestCreateForwardPathWithQuery(t *testing.T) {
 	forwardPath = createForwardPath(servicePath, requestPath)
 	assert.Equal(t, "/one/two?a=b", forwardPath)
 }
+
+func TestSortServices(t *testing.T) {
+	services := []*models.Service{
+		&models.Service{Name: "root", Path: "/"},
+		&models.Service{Name: "api", Path: "/api"},
+		&models.Service{Name: "grpc", Path: "/api", Priority: 10},
+		&models.Service{Name: "api-v1", Path: "/api/v1"},
+	}
+
+	sortServices(services)
+
+	assert.Equal(t, "grpc", services[0].Name)
+	assert.Equal(t, "api-v1", services[1].Name)
+	assert.Equal(t, "api", services[2].Name)
+	assert.Equal(t, "root", services[3].Name)
+}
+
+func TestGetForwardURLForServiceRequest(t *testing.T) {
+	tests := []struct {
+		includeServicePath bool
+		rewrites           []*models.Rewrite
+		requestPath        string
+		expected           string
+	}{
+		{false, nil, "/service/something", "http://upstream/something"},
+		{true, nil, "/service/something", "http://upstream/service/something"},
+		{false, nil, "/service/something?a=b", "http://upstream/something?a=b"},
+		{
+			false,
+			[]*models.Rewrite{&models.Rewrite{Type: models.RewritePrefix, Replacement: "/legacy"}},
+			"/service/something",
+			"http://upstream/legacy/something",
+		},
+		{
+			true,
+			[]*models.Rewrite{&models.Rewrite{Type: models.RewriteRegex, Pattern: "^/service", Replacement: "/svc"}},
+			"/service/something?a=b",
+			"http://upstream/svc/something?a=b",
+		},
+		{
+			false,
+			[]*models.Rewrite{&models.Rewrite{Type: models.RewriteTemplate, Pattern: "/users/{id}", Replacement: "/v2/accounts/{id}"}},
+			"/service/users/5",
+			"http://upstream/v2/accounts/5",
+		},
+		{
+			false,
+			[]*models.Rewrite{
+				&models.Rewrite{Type: models.RewriteTemplate, Pattern: "/users/{id}", Replacement: "/accounts/{id}"},
+				&models.Rewrite{Type: models.RewritePrefix, Replacement: "/v2"},
+			},
+			"/service/users/5/profile",
+			"http://upstream/v2/accounts/5/profile",
+		},
+		{
+			false,
+			[]*models.Rewrite{&models.Rewrite{Type: models.RewriteTemplate, Pattern: "/users/{id}/orders/{order}", Replacement: "/orders/{order}?user={id}"}},
+			"/service/users/5/orders/7?a=b",
+			"http://upstream/orders/7?a=b&user=5",
+		},
+		{
+			false,
+			[]*models.Rewrite{&models.Rewrite{Type: models.RewriteRegex, Pattern: "^/(\\w+)/(\\d+)$", Replacement: "/$1?id=$2"}},
+			"/service/users/5?id=6",
+			"http://upstream/users?id=5&id=6",
+		},
+	}
+
+	for _, test := range tests {
+		service := &models.Service{Path: "service", Rewrites: test.rewrites}
+		upstream := &models.Upstream{URL: "http://upstream", IncludeServicePath: test.includeServicePath}
+
+		requestURL, err := url.Parse(test.requestPath)
+		require.NoError(t, err)
+
+		forwardURL, err := getForwardURLForServiceRequest(upstream, service, requestURL)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, forwardURL.String(), "request path %q", test.requestPath)
+	}
+}
+
+func TestForwardServiceAccessLog(t *testing.T) {
+	t.Parallel()
+	api := setup(t)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	_, err := api.registry.CreateService(&models.Service{
+		Name:      "app",
+		Path:      "/app",
+		Upstreams: []*models.Upstream{&models.Upstream{URL: upstream.URL}},
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	logger, err := accesslog.New(&buf, accesslog.FormatTemplate, "{{.Service}} {{.Upstream}} {{.Status}} {{.Bytes}} {{gt .UpstreamLatency 0}}")
+	require.NoError(t, err)
+	handler := logger.Handler(http.HandlerFunc(api.ForwardService))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/app/page", nil))
+	assert.Equal(t, "app "+upstream.URL+" 200 2 true\n", buf.String())
+
+	buf.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/unknown", nil))
+	assert.Equal(t, "  404 0 false\n", buf.String())
+}
+
+func TestForwardServiceTracing(t *testing.T) {
+	api := setup(t)
+	trusted, err := requestid.ParseNetworks([]string{"192.0.2.1"})
+	require.NoError(t, err)
+	api.trusted = trusted
+
+	spans := tracetest.NewSpanRecorder()
+	tracing.SetProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	defer tracing.SetProvider(noop.NewTracerProvider())
+
+	var traceparent, baggage string
+	upstream := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		traceparent = request.Header.Get("traceparent")
+		baggage = request.Header.Get("baggage")
+	}))
+	defer upstream.Close()
+
+	_, err = api.registry.CreateService(&models.Service{
+		Name:      "app",
+		Path:      "/app",
+		Upstreams: []*models.Upstream{&models.Upstream{URL: upstream.URL}},
+	})
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/app/page", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	recorder := httptest.NewRecorder()
+	api.ForwardService(recorder, request)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	ended := spans.Ended()
+	require.Equal(t, 4, len(ended))
+
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range ended {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+		byName[span.Name()] = span
+	}
+
+	server := byName["GET"]
+	require.NotNil(t, server)
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String(), "the trace of the caller should continue")
+
+	for _, name := range []string{"route lookup", "upstream selection", "forward"} {
+		require.NotNil(t, byName[name], name)
+		assert.Equal(t, server.SpanContext().SpanID(), byName[name].Parent().SpanID(), name)
+	}
+
+	forward := byName["forward"]
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+forward.SpanContext().SpanID().String()+"-01", traceparent)
+
+	// The trace context of an untrusted caller starts a new trace, and its baggage is dropped
+	request = httptest.NewRequest("GET", "/app/page", nil)
+	request.RemoteAddr = "203.0.113.1:5000"
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	request.Header.Set("baggage", "user=mallory")
+	recorder = httptest.NewRecorder()
+	api.ForwardService(recorder, request)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	ended = spans.Ended()[4:]
+	require.Equal(t, 4, len(ended))
+	for _, span := range ended {
+		assert.NotEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String(), span.Name())
+		if span.Name() == "GET" {
+			assert.False(t, span.Parent().IsValid(), "the trace of an untrusted caller should not continue")
+		}
+	}
+	assert.NotContains(t, traceparent, "4bf92f3577b34da6a3ce929d0e0e4736")
+	assert.Empty(t, baggage)
+}
+
+func TestForwardServiceRequestID(t *testing.T) {
+	t.Parallel()
+	api := setup(t)
+
+	var forwarded string
+	upstream := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		forwarded = request.Header.Get(requestid.Header)
+	}))
+	defer upstream.Close()
+
+	_, err := api.registry.CreateService(&models.Service{
+		Name:      "app",
+		Path:      "/app",
+		Upstreams: []*models.Upstream{&models.Upstream{URL: upstream.URL}},
+	})
+	require.NoError(t, err)
+
+	_, err = api.registry.CreateService(&models.Service{
+		Name: "empty",
+		Path: "/empty",
+	})
+	require.NoError(t, err)
+
+	handler := requestid.Handler(nil, http.HandlerFunc(api.ForwardService))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/app/page", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	id := recorder.Header().Get(requestid.Header)
+	require.NotEmpty(t, id)
+	assert.Equal(t, id, forwarded, "the request id should be forwarded to the upstream")
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	id = recorder.Header().Get(requestid.Header)
+	assert.Equal(t, "request id "+id+"\n", recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/empty/page", nil))
+	assert.Equal(t, http.StatusBadGateway, recorder.Code)
+	id = recorder.Header().Get(requestid.Header)
+	assert.Equal(t, "request id "+id+"\n", recorder.Body.String())
+}
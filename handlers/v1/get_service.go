@@ -0,0 +1,112 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/models"
+
+	"github.com/gorilla/mux"
+)
+
+// ListServicesResponse represents the response to a listServices call.
+// swagger:response listServicesResponse
+type ListServicesResponse struct {
+	// Services
+	// In: body
+	Body []*models.Service `json:"services"`
+}
+
+// ListServices is the handler called when a GET is made to list the registered services.
+func (a *API) ListServices(response http.ResponseWriter, request *http.Request) {
+	// swagger:route GET /service services listServices
+	//
+	// Lists the services registered with the router.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Schemes: https
+	//
+	//     Responses:
+	//       200: listServicesResponse
+	services, err := a.registry.ListServices()
+	if err != nil {
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	listServicesResponse := ListServicesResponse{
+		Body: services,
+	}
+	b, err := json.Marshal(listServicesResponse)
+	if err != nil {
+		log.Error(err)
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response.WriteHeader(http.StatusOK)
+	response.Write(b)
+}
+
+// GetServiceParams contains parameters to the get service route.
+// swagger:parameters getService
+type GetServiceParams struct {
+	// Name of the service.
+	// In: path
+	Name string `json:"name"`
+}
+
+// GetServiceResponse represents the response to a getService call.
+// swagger:response getServiceResponse
+type GetServiceResponse struct {
+	// Service
+	// In: body
+	Body *models.Service `json:"service"`
+}
+
+// GetService is the handler called when a GET is made for a single service.
+func (a *API) GetService(response http.ResponseWriter, request *http.Request) {
+	// swagger:route GET /service/{name} services getService
+	//
+	// Returns a registered service.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Schemes: https
+	//
+	//     Responses:
+	//       200: getServiceResponse
+	//       404:
+	params := GetServiceParams{
+		Name: mux.Vars(request)["name"],
+	}
+
+	service, err := a.registry.GetServiceByName([]byte(params.Name))
+	if err != nil {
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if service == nil {
+		http.Error(response, "Service not found", http.StatusNotFound)
+		return
+	}
+
+	getServiceResponse := GetServiceResponse{
+		Body: service,
+	}
+	b, err := json.Marshal(getServiceResponse)
+	if err != nil {
+		log.Error(err)
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response.WriteHeader(http.StatusOK)
+	response.Write(b)
+}
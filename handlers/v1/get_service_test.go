@@ -0,0 +1,53 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/premkit/premkit/models"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListAndGetService(t *testing.T) {
+	t.Parallel()
+	api := setup(t)
+
+	_, err := api.registry.CreateService(&models.Service{
+		Name: "test",
+		Path: "test",
+		Upstreams: []*models.Upstream{
+			&models.Upstream{URL: "http://localhost:3000"},
+		},
+	})
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/service", api.ListServices).Methods("GET")
+	router.HandleFunc("/service/{name}", api.GetService).Methods("GET")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/service", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	listServicesResponse := ListServicesResponse{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &listServicesResponse))
+	require.Equal(t, 1, len(listServicesResponse.Body))
+	assert.Equal(t, "test", listServicesResponse.Body[0].Name)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/service/test", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	getServiceResponse := GetServiceResponse{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &getServiceResponse))
+	assert.Equal(t, "http://localhost:3000", getServiceResponse.Body.Upstreams[0].URL)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/service/missing", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
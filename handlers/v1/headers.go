@@ -0,0 +1,155 @@
+package v1
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"text/template"
+
+	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/models"
+)
+
+// headerTemplateData is the data available to header value templates.
+type headerTemplateData struct {
+	ClientIP  string
+	RequestID string
+	Service   string
+	Upstream  string
+
+	// logger logs the failures to render a header with the ID of the request.
+	logger *log.Entry
+}
+
+// headerTemplates caches parsed header value templates, keyed by the template text.
+var headerTemplates sync.Map
+
+func newHeaderTemplateData(request *http.Request, service *models.Service, upstream *models.Upstream) *headerTemplateData {
+	data := headerTemplateData{
+		ClientIP:  request.RemoteAddr,
+		RequestID: request.Header.Get("X-Request-Id"),
+		Service:   service.Name,
+		logger:    requestLog(request),
+	}
+
+	if host, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+		data.ClientIP = host
+	}
+
+	if upstream != nil {
+		data.Upstream = upstream.URL
+	}
+
+	return &data
+}
+
+func renderHeaderValue(value string, data *headerTemplateData) (string, error) {
+	var t *template.Template
+	if cached, ok := headerTemplates.Load(value); ok {
+		t = cached.(*template.Template)
+	} else {
+		parsed, err := template.New("header").Parse(value)
+		if err != nil {
+			return "", err
+		}
+		headerTemplates.Store(value, parsed)
+		t = parsed
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// applyHeaderRules removes, sets and then adds headers according to the rules.
+func applyHeaderRules(rules *models.HeaderRules, header http.Header, data *headerTemplateData) {
+	if rules == nil {
+		return
+	}
+
+	for _, name := range rules.Remove {
+		header.Del(name)
+	}
+
+	for name, value := range rules.Set {
+		rendered, err := renderHeaderValue(value, data)
+		if err != nil {
+			data.logger.Errorf("Failed to render header %q: %v", name, err)
+			continue
+		}
+		header.Set(name, rendered)
+	}
+
+	for name, value := range rules.Add {
+		rendered, err := renderHeaderValue(value, data)
+		if err != nil {
+			data.logger.Errorf("Failed to render header %q: %v", name, err)
+			continue
+		}
+		header.Add(name, rendered)
+	}
+}
+
+// rewriteResponseHeaders wraps the response so the response header rules of the service are
+// applied before the headers are written.
+func rewriteResponseHeaders(response http.ResponseWriter, service *models.Service, data *headerTemplateData) http.ResponseWriter {
+	if service.Headers == nil || service.Headers.Response == nil {
+		return response
+	}
+
+	return &headerRewriter{
+		ResponseWriter: response,
+		rules:          service.Headers.Response,
+		data:           data,
+	}
+}
+
+// headerRewriter is a http.ResponseWriter that applies header rules to the response headers
+// before they are written.
+type headerRewriter struct {
+	http.ResponseWriter
+
+	rules       *models.HeaderRules
+	data        *headerTemplateData
+	wroteHeader bool
+}
+
+func (w *headerRewriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		applyHeaderRules(w.rules, w.ResponseWriter.Header(), w.data)
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *headerRewriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush allows streaming responses to pass through the rewriter.
+func (w *headerRewriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack allows websocket connections to pass through the rewriter.
+func (w *headerRewriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Response writer does not support hijacking")
+	}
+
+	return hijacker.Hijack()
+}
@@ -0,0 +1,60 @@
+package v1
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/premkit/premkit/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyHeaderRules(t *testing.T) {
+	request := httptest.NewRequest("GET", "/service/path", nil)
+	request.RemoteAddr = "10.0.0.1:5555"
+	request.Header.Set("X-Request-Id", "abc")
+	request.Header.Set("X-Remove-Me", "1")
+	request.Header.Set("X-Replace-Me", "1")
+
+	service := &models.Service{Name: "service"}
+	upstream := &models.Upstream{URL: "http://localhost:3000"}
+	data := newHeaderTemplateData(request, service, upstream)
+
+	rules := &models.HeaderRules{
+		Remove: []string{"X-Remove-Me"},
+		Set: map[string]string{
+			"X-Replace-Me": "{{.Service}}",
+			"X-Real-Ip":    "{{.ClientIP}}",
+		},
+		Add: map[string]string{
+			"X-Upstream": "{{.Upstream}} {{.RequestID}}",
+		},
+	}
+	applyHeaderRules(rules, request.Header, data)
+
+	assert.Equal(t, "", request.Header.Get("X-Remove-Me"))
+	assert.Equal(t, "service", request.Header.Get("X-Replace-Me"))
+	assert.Equal(t, "10.0.0.1", request.Header.Get("X-Real-Ip"))
+	assert.Equal(t, "http://localhost:3000 abc", request.Header.Get("X-Upstream"))
+}
+
+func TestHeaderRewriter(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	w := &headerRewriter{
+		ResponseWriter: recorder,
+		rules: &models.HeaderRules{
+			Remove: []string{"Server"},
+			Set:    map[string]string{"X-Served-By": "{{.Service}}"},
+		},
+		data: &headerTemplateData{Service: "service"},
+	}
+
+	w.Header().Set("Server", "backend/1.0")
+	w.Write([]byte("ok"))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "", recorder.Header().Get("Server"))
+	assert.Equal(t, "service", recorder.Header().Get("X-Served-By"))
+	assert.Equal(t, "ok", recorder.Body.String())
+}
@@ -0,0 +1,77 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/models"
+
+	"github.com/gorilla/mux"
+)
+
+// HeartbeatParams contains parameters to the heartbeat route.
+// swagger:parameters heartbeat
+type HeartbeatParams struct {
+	// Name of the service to renew.
+	// In: path
+	Name string `json:"name"`
+
+	// URL of the upstream to renew.  When not set, the service and all of its upstreams are renewed.
+	// In: query
+	Upstream string `json:"upstream"`
+}
+
+// HeartbeatResponse represents the response to a heartbeat call. This response includes
+// a pointer to the renewed service.
+// swagger:response heartbeatResponse
+type HeartbeatResponse struct {
+	// Service
+	// In: body
+	Body *models.Service `json:"service"`
+}
+
+// Heartbeat is the handler called when a PUT is made to renew the lease of a service.
+func (a *API) Heartbeat(response http.ResponseWriter, request *http.Request) {
+	// swagger:route PUT /service/{name}/heartbeat services heartbeat
+	//
+	// Renews the lease of a service, or one of its upstreams, that was registered with a ttl.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Schemes: https
+	//
+	//     Responses:
+	//       200: heartbeatResponse
+	//       404:
+	params := HeartbeatParams{
+		Name:     mux.Vars(request)["name"],
+		Upstream: request.URL.Query().Get("upstream"),
+	}
+
+	service, err := a.registry.RenewLease([]byte(params.Name), []byte(params.Upstream))
+	if err != nil {
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if service == nil {
+		http.Error(response, "Service not found", http.StatusNotFound)
+		return
+	}
+
+	heartbeatResponse := HeartbeatResponse{
+		Body: service,
+	}
+	b, err := json.Marshal(heartbeatResponse)
+	if err != nil {
+		log.Error(err)
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response.WriteHeader(http.StatusOK)
+	response.Write(b)
+}
@@ -0,0 +1,38 @@
+package v1
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/premkit/premkit/models"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeartbeat(t *testing.T) {
+	t.Parallel()
+	api := setup(t)
+
+	_, err := api.registry.CreateService(&models.Service{
+		Name: "test",
+		Path: "test",
+		Upstreams: []*models.Upstream{
+			&models.Upstream{URL: "http://localhost:3000", TTL: 30},
+		},
+	})
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/service/{name}/heartbeat", api.Heartbeat).Methods("PUT")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("PUT", "/service/test/heartbeat?upstream=http://localhost:3000", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("PUT", "/service/missing/heartbeat", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
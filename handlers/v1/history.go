@@ -0,0 +1,182 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/models"
+
+	"github.com/gorilla/mux"
+)
+
+// ListRevisionsParams contains parameters to the list revisions route.
+// swagger:parameters listRevisions
+type ListRevisionsParams struct {
+	// Name of the service.
+	// In: path
+	Name string `json:"name"`
+}
+
+// ListRevisionsResponse represents the response to a listRevisions call.
+// swagger:response listRevisionsResponse
+type ListRevisionsResponse struct {
+	// Revisions, oldest first
+	// In: body
+	Body []*models.Revision `json:"revisions"`
+}
+
+// ListRevisions is the handler called when a GET is made for the history of a service.
+func (a *API) ListRevisions(response http.ResponseWriter, request *http.Request) {
+	// swagger:route GET /service/{name}/history services listRevisions
+	//
+	// Lists the changes made to a service, including after it was deleted.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Schemes: https
+	//
+	//     Responses:
+	//       200: listRevisionsResponse
+	//       404:
+	params := ListRevisionsParams{
+		Name: mux.Vars(request)["name"],
+	}
+
+	revisions, err := a.registry.ListRevisions([]byte(params.Name))
+	if err != nil {
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if len(revisions) == 0 {
+		http.Error(response, "Service not found", http.StatusNotFound)
+		return
+	}
+
+	listRevisionsResponse := ListRevisionsResponse{
+		Body: revisions,
+	}
+	b, err := json.Marshal(listRevisionsResponse)
+	if err != nil {
+		log.Error(err)
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response.WriteHeader(http.StatusOK)
+	response.Write(b)
+}
+
+// RollbackParams contains parameters to the rollback route.
+// swagger:parameters rollback
+type RollbackParams struct {
+	// Name of the service.
+	// In: path
+	Name string `json:"name"`
+
+	// Number of the revision to roll back to.
+	// In: body
+	Revision int `json:"revision"`
+
+	// Actor identifies who made the request, from the X-Premkit-Actor header and the client
+	// address.  It is recorded in the history of the service.
+	Actor string `json:"-"`
+}
+
+// RollbackResponse represents the response to a rollback call. This response includes a pointer
+// to the rolled back service, which is nil if the rollback deleted the service.
+// swagger:response rollbackResponse
+type RollbackResponse struct {
+	// Service
+	// In: body
+	Body *models.Service `json:"service"`
+}
+
+// Rollback is the handler called when a POST is made to return a service to an earlier revision.
+func (a *API) Rollback(response http.ResponseWriter, request *http.Request) {
+	// swagger:route POST /service/{name}/rollback services rollback
+	//
+	// Returns a service to its state after a revision, or deletes it if the revision deleted it.
+	//
+	//     Consumes:
+	//     - application/json
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Schemes: https
+	//
+	//     Responses:
+	//       200: rollbackResponse
+	//       400:
+	//       404:
+	//       409:
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		log.Error(err)
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	params := RollbackParams{}
+	if err := json.Unmarshal(body, &params); err != nil {
+		log.Error(err)
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusBadRequest)
+		return
+	}
+	params.Name = mux.Vars(request)["name"]
+	params.Actor = requestActor(request)
+
+	if params.Revision <= 0 {
+		http.Error(response, fmt.Sprintf("Invalid revision %d", params.Revision), http.StatusBadRequest)
+		return
+	}
+
+	service, found, err := a.registry.RollbackService([]byte(params.Name), params.Revision, params.Actor)
+	if err == errManagedService {
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if !found {
+		http.Error(response, "Revision not found", http.StatusNotFound)
+		return
+	}
+
+	rollbackResponse := RollbackResponse{
+		Body: service,
+	}
+	b, err := json.Marshal(rollbackResponse)
+	if err != nil {
+		log.Error(err)
+		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response.WriteHeader(http.StatusOK)
+	response.Write(b)
+}
+
+// requestActor identifies who made the request, by the models.ActorHeader when it is set, and the
+// client address.
+func requestActor(request *http.Request) string {
+	address := request.RemoteAddr
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+
+	if actor := request.Header.Get(models.ActorHeader); actor != "" {
+		return fmt.Sprintf("%s (%s)", actor, address)
+	}
+
+	return address
+}
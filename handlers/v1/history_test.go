@@ -0,0 +1,79 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/premkit/premkit/models"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListRevisionsAndRollback(t *testing.T) {
+	t.Parallel()
+	api := setup(t)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/service", api.RegisterService).Methods("POST")
+	router.HandleFunc("/service/{name}/history", api.ListRevisions).Methods("GET")
+	router.HandleFunc("/service/{name}/rollback", api.Rollback).Methods("POST")
+
+	for _, path := range []string{"v1", "v2"} {
+		request := httptest.NewRequest("POST", "/service", strings.NewReader(`{"service": {"name": "test", "path": "`+path+`"}, "replace_existing": true}`))
+		request.Header.Set(models.ActorHeader, "alice")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		require.Equal(t, http.StatusCreated, recorder.Code)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/service/test/history", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	listRevisionsResponse := ListRevisionsResponse{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &listRevisionsResponse))
+	require.Equal(t, 2, len(listRevisionsResponse.Body))
+	assert.Equal(t, "alice (192.0.2.1)", listRevisionsResponse.Body[0].Actor)
+	assert.Equal(t, models.SourceAPI, listRevisionsResponse.Body[0].Source)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/service/missing/history", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", "/service/test/rollback", strings.NewReader(`{"revision": 1}`)))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	rollbackResponse := RollbackResponse{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &rollbackResponse))
+	require.NotNil(t, rollbackResponse.Body)
+	assert.Equal(t, "v1", rollbackResponse.Body.Path)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", "/service/test/rollback", strings.NewReader(`{"revision": 10}`)))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", "/service/test/rollback", strings.NewReader(`{}`)))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestRollbackManagedService(t *testing.T) {
+	t.Parallel()
+	api := setup(t)
+
+	_, err := api.registry.CreateService(&models.Service{Name: "managed", Path: "managed", Source: models.SourceFile})
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/service/{name}/rollback", api.Rollback).Methods("POST")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", "/service/managed/rollback", strings.NewReader(`{"revision": 1}`)))
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+}
@@ -2,14 +2,23 @@ package v1
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io/ioutil"
 	"net/http"
 
-	"github.com/premkit/premkit/log"
 	"github.com/premkit/premkit/models"
 )
 
+// errManagedService is returned when a registration would change a service that is managed by
+// a source other than the API.
+var errManagedService = models.ErrManagedService
+
+// invalid returns true if err was caused by an invalid service or upstream in the request.
+func invalid(err error) bool {
+	var validationErr *models.ValidationError
+	return errors.As(err, &validationErr)
+}
+
 // RegisterServiceParams contains parameters to the register service route.
 // swagger:parameters registerService
 type RegisterServiceParams struct {
@@ -17,6 +26,10 @@ type RegisterServiceParams struct {
 	// In: body
 	Service         *models.Service `json:"service"`
 	ReplaceExisting bool            `json:"replace_existing"`
+
+	// Actor identifies who made the request, from the X-Premkit-Actor header and the client
+	// address.  It is recorded in the history of the service.
+	Actor string `json:"-"`
 }
 
 // RegisterServiceResponse represents the response to a registerService call. This response
@@ -29,7 +42,7 @@ type RegisterServiceResponse struct {
 }
 
 // RegisterService is the handler called when a POST is made to register a new service.
-func RegisterService(response http.ResponseWriter, request *http.Request) {
+func (a *API) RegisterService(response http.ResponseWriter, request *http.Request) {
 	// swagger:route POST /service services registerService
 	//
 	// Registers a new backend service with the router.
@@ -44,23 +57,33 @@ func RegisterService(response http.ResponseWriter, request *http.Request) {
 	//
 	//     Responses:
 	//       201: registerServiceResponse
+	//       400:
+	//       409:
 	body, err := ioutil.ReadAll(request.Body)
 	if err != nil {
-		log.Error(err)
-		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		requestError(response, request, err, http.StatusInternalServerError)
 		return
 	}
 
 	registerServiceParams := RegisterServiceParams{}
 	if err := json.Unmarshal(body, &registerServiceParams); err != nil {
-		log.Error(err)
-		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		requestError(response, request, err, http.StatusBadRequest)
 		return
 	}
 
-	service, err := registerService(&registerServiceParams)
+	registerServiceParams.Actor = requestActor(request)
+
+	service, err := a.registerService(&registerServiceParams)
+	if err == errManagedService {
+		requestError(response, request, err, http.StatusConflict)
+		return
+	}
+	if invalid(err) {
+		requestError(response, request, err, http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		requestError(response, request, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -69,8 +92,7 @@ func RegisterService(response http.ResponseWriter, request *http.Request) {
 	}
 	b, err := json.Marshal(registerServiceResponse)
 	if err != nil {
-		log.Error(err)
-		http.Error(response, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
+		requestError(response, request, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -78,15 +100,14 @@ func RegisterService(response http.ResponseWriter, request *http.Request) {
 	response.Write(b)
 }
 
-func registerService(params *RegisterServiceParams) (*models.Service, error) {
-	if params.ReplaceExisting {
-		_, err := models.DeleteServiceByName([]byte(params.Service.Name))
-		if err != nil {
-			return nil, err
-		}
+func (a *API) registerService(params *RegisterServiceParams) (*models.Service, error) {
+	if params.Service == nil {
+		return nil, &models.ValidationError{Err: errors.New("Service is required")}
 	}
 
-	service, err := models.CreateService(params.Service)
+	params.Service.Source = models.SourceAPI
+
+	service, err := a.registry.RegisterService(params.Service, params.ReplaceExisting, params.Actor)
 	if err != nil {
 		return nil, err
 	}
@@ -1,38 +1,25 @@
 package v1
 
 import (
-	"io/ioutil"
-	"os"
-	"path"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/premkit/premkit/models"
-	"github.com/premkit/premkit/persistence"
+	"github.com/premkit/premkit/requestid"
 
-	"github.com/boltdb/bolt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func setup(t *testing.T) string {
-	dirName, err := ioutil.TempDir("", "premkit-test")
-	require.NoError(t, err)
-
-	conn, err := bolt.Open(path.Join(dirName, "test.db"), 0600, nil)
-	require.NoError(t, err)
-
-	persistence.DB = conn
-
-	return dirName
-}
-
-func teardown(dbPath string) {
-	os.RemoveAll(dbPath)
+func setup(t *testing.T) *API {
+	return NewAPI(models.NewRegistry(models.NewMemoryStore()), nil)
 }
 
 func TestRegisterService(t *testing.T) {
-	dbPath := setup(t)
-	defer teardown(dbPath)
+	t.Parallel()
+	api := setup(t)
 
 	params := RegisterServiceParams{
 		ReplaceExisting: false,
@@ -47,7 +34,66 @@ func TestRegisterService(t *testing.T) {
 		},
 	}
 
-	service, err := registerService(&params)
+	service, err := api.registerService(&params)
 	require.NoError(t, err)
 	assert.NotNil(t, service)
 }
+
+func TestRegisterManagedService(t *testing.T) {
+	t.Parallel()
+	api := setup(t)
+
+	_, err := api.registry.CreateService(&models.Service{
+		Name:   "managed",
+		Path:   "managed",
+		Source: models.SourceFile,
+	})
+	require.NoError(t, err)
+
+	params := RegisterServiceParams{
+		ReplaceExisting: true,
+		Service: &models.Service{
+			Name: "managed",
+			Path: "other",
+		},
+	}
+
+	_, err = api.registerService(&params)
+	assert.Equal(t, errManagedService, err)
+
+	service, err := api.registry.GetServiceByName([]byte("managed"))
+	require.NoError(t, err)
+	assert.Equal(t, "managed", service.Path)
+}
+
+func TestRegisterServiceErrorRequestID(t *testing.T) {
+	t.Parallel()
+	api := setup(t)
+
+	handler := requestid.Handler(nil, http.HandlerFunc(api.RegisterService))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/premkit/v1/service", strings.NewReader("{")))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	id := recorder.Header().Get(requestid.Header)
+	require.NotEmpty(t, id)
+	assert.Contains(t, recorder.Body.String(), "(request id "+id+")")
+}
+
+func TestRegisterServiceInvalid(t *testing.T) {
+	t.Parallel()
+	api := setup(t)
+
+	registrations := []string{
+		`{}`,
+		`{"service":{"name":"bad-regex","path":"a","match":{"headers":[{"name":"x","regex":"("}]}}}`,
+		`{"service":{"name":"bad-rewrite","path":"a","rewrites":[{"type":"prefix","replacement":"v2"}]}}`,
+		`{"service":{"name":"bad-kind","path":"a","kind":"unknown"}}`,
+	}
+	for _, registration := range registrations {
+		recorder := httptest.NewRecorder()
+		api.RegisterService(recorder, httptest.NewRequest("POST", "/premkit/v1/service", strings.NewReader(registration)))
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, registration)
+	}
+}
@@ -0,0 +1,40 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/requestid"
+)
+
+// requestLog returns a logger that adds the ID of the request to every message.
+func requestLog(request *http.Request) *log.Entry {
+	return log.WithRequestID(requestid.FromContext(request.Context()))
+}
+
+// requestError logs err with the ID of the request, and answers the request with status and err.
+func requestError(response http.ResponseWriter, request *http.Request, err error, status int) {
+	requestLog(request).Errorf("Request failed with status %d: %+v", status, err)
+	writeError(response, request, fmt.Sprintf("%+v", err), status)
+}
+
+// writeError answers the request with status and message.  The ID of the request is added to
+// the body, so failures reported by clients can be found in the logs.  The body is empty when
+// both the message and the ID are.
+func writeError(response http.ResponseWriter, request *http.Request, message string, status int) {
+	id := requestid.FromContext(request.Context())
+	switch {
+	case id == "" && message == "":
+		response.WriteHeader(status)
+		return
+
+	case message == "":
+		message = fmt.Sprintf("request id %s", id)
+
+	case id != "":
+		message = fmt.Sprintf("%s (request id %s)", message, id)
+	}
+
+	http.Error(response, message, status)
+}
@@ -0,0 +1,66 @@
+package v1
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/premkit/premkit/balancer"
+	"github.com/premkit/premkit/models"
+
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+)
+
+// dialEndpointKey is the context key of the *dialEndpoint of a forwarded request.
+type dialEndpointKey struct{}
+
+// dialEndpoint is an address the hostname of an upstream resolved to.  Requests to the endpoint
+// keep the hostname in their url, and only their connection is made to the address.
+type dialEndpoint struct {
+	hostname string
+	address  string
+}
+
+// newDialEndpoint returns the address to dial for target, or nil when target is not an address
+// its upstream resolved to and is dialed by its url.
+func newDialEndpoint(target *balancer.Target) *dialEndpoint {
+	if target.Upstream.Resolve != models.ResolveDNS {
+		return nil
+	}
+
+	upstreamURL, err := url.Parse(target.Upstream.URL)
+	if err != nil {
+		return nil
+	}
+	endpointURL, err := url.Parse(target.Endpoint)
+	if err != nil {
+		return nil
+	}
+
+	return &dialEndpoint{hostname: upstreamURL.Hostname(), address: endpointURL.Hostname()}
+}
+
+// newForwardTransport returns a transport for the forwarders.  Requests with a dialEndpoint are
+// connected to its address, so the hostname of the upstream is still used for the Host header
+// and to verify the tls certificate.  Connections are not kept alive, so a connection to one
+// endpoint is never reused for another endpoint of the same hostname.
+func newForwardTransport(insecureSkipVerify bool) *http.Transport {
+	transport := cleanhttp.DefaultTransport()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if endpoint, ok := ctx.Value(dialEndpointKey{}).(*dialEndpoint); ok {
+			// Connections to a proxy from the environment are not redirected
+			if host, port, err := net.SplitHostPort(addr); err == nil && host == endpoint.hostname {
+				addr = net.JoinHostPort(endpoint.address, port)
+			}
+		}
+
+		return dial(ctx, network, addr)
+	}
+
+	return transport
+}
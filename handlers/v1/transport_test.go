@@ -0,0 +1,63 @@
+package v1
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/premkit/premkit/balancer"
+	"github.com/premkit/premkit/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDialEndpoint(t *testing.T) {
+	dial := newDialEndpoint(&balancer.Target{
+		Upstream: &models.Upstream{URL: "https://api.example.com:8443", Resolve: models.ResolveDNS},
+		Endpoint: "https://[::1]:8443",
+	})
+	require.NotNil(t, dial)
+	assert.Equal(t, "api.example.com", dial.hostname)
+	assert.Equal(t, "::1", dial.address)
+
+	// Srv targets are hostnames, and are dialed by their url
+	assert.Nil(t, newDialEndpoint(&balancer.Target{
+		Upstream: &models.Upstream{URL: "http://_http._tcp.api.local", Resolve: models.ResolveSRV},
+		Endpoint: "http://node1.local:8080",
+	}))
+	assert.Nil(t, newDialEndpoint(&balancer.Target{
+		Upstream: &models.Upstream{URL: "http://localhost:3000"},
+		Endpoint: "http://localhost:3000",
+	}))
+}
+
+func TestForwardTransportDialEndpoint(t *testing.T) {
+	var host string
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		host = request.Host
+	}))
+	defer upstream.Close()
+
+	_, port, err := net.SplitHostPort(upstream.Listener.Addr().String())
+	require.NoError(t, err)
+
+	// The certificate of the test server is for example.com, which does not resolve to it
+	roots := x509.NewCertPool()
+	roots.AddCert(upstream.Certificate())
+	transport := newForwardTransport(false)
+	transport.TLSClientConfig.RootCAs = roots
+
+	request := httptest.NewRequest("GET", "https://example.com:"+port+"/", nil)
+	request.RequestURI = ""
+	request = request.WithContext(context.WithValue(context.Background(), dialEndpointKey{},
+		&dialEndpoint{hostname: "example.com", address: "127.0.0.1"}))
+
+	response, err := transport.RoundTrip(request)
+	require.NoError(t, err, "the certificate should be verified for the hostname")
+	response.Body.Close()
+	assert.Equal(t, "example.com:"+port, host)
+}
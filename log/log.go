@@ -53,3 +53,43 @@ func Error(err error) {
 func Fatal(err error) {
 	log.Fatal(err)
 }
+
+// Entry logs messages with a request ID, so they can be matched to the request.
+type Entry struct {
+	entry *log.Entry
+}
+
+// WithRequestID returns an Entry that adds the request ID to every message, or logs messages
+// as they are if id is empty.
+func WithRequestID(id string) *Entry {
+	if id == "" {
+		return &Entry{entry: log.NewEntry(log.StandardLogger())}
+	}
+
+	return &Entry{entry: log.WithField("request_id", id)}
+}
+
+// Debugf logs a message in sprintf format at debug level.
+func (e *Entry) Debugf(format string, args ...interface{}) {
+	e.entry.Debugf(format, args...)
+}
+
+// Infof logs a message in sprintf format at info level.
+func (e *Entry) Infof(format string, args ...interface{}) {
+	e.entry.Infof(format, args...)
+}
+
+// Warningf logs a message in sprintf format at warning level.
+func (e *Entry) Warningf(format string, args ...interface{}) {
+	e.entry.Warningf(format, args...)
+}
+
+// Errorf logs a message in sprintf format at error level.
+func (e *Entry) Errorf(format string, args ...interface{}) {
+	e.entry.Errorf(format, args...)
+}
+
+// Error logs an error object at error level.
+func (e *Entry) Error(err error) {
+	e.entry.Error(err)
+}
@@ -0,0 +1,202 @@
+package metrics
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/premkit/premkit/events"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "premkit"
+
+// Registry holds every premkit metric, and the go runtime and process metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Requests to services, by the service and upstream they were forwarded to.",
+	}, []string{"service", "upstream", "method", "status_class"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Time to answer requests to services, including the upstream response.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "upstream", "method", "status_class"})
+
+	requestsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "requests_in_flight",
+		Help:      "Requests being forwarded to upstreams.",
+	}, []string{"service", "upstream", "method"})
+
+	upstreamHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_healthy",
+		Help:      "1 if the last request to the endpoint of an upstream succeeded, 0 if it failed.",
+	}, []string{"service", "upstream", "endpoint"})
+
+	certificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tls_certificate_expiry_timestamp_seconds",
+		Help:      "Time, in seconds since the epoch, when the tls certificate expires.",
+	}, []string{"file", "subject"})
+
+	boltTransactionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "bolt_transaction_duration_seconds",
+		Help:      "Time bolt transactions took, including waiting to begin and committing, by type (view or update).",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"type"})
+
+	registryServicesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "registry_services"),
+		"Services in the registry.",
+		nil, nil,
+	)
+	registryUpstreamsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "registry_upstreams"),
+		"Upstreams of the services in the registry.",
+		nil, nil,
+	)
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestsTotal,
+		requestDuration,
+		requestsInFlight,
+		upstreamHealthy,
+		certificateExpiry,
+		boltTransactionDuration,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// statusClass groups status codes into 1xx to 5xx.
+func statusClass(status int) string {
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+// methodLabel limits the method label to the standard methods, so clients cannot create any
+// number of series.
+func methodLabel(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE":
+		return method
+	}
+
+	return "OTHER"
+}
+
+// ObserveRequest counts a request to a service that was answered with status.  Service and
+// upstream are empty when the request did not match a service, or was not forwarded.
+func ObserveRequest(service string, upstream string, method string, status int, duration time.Duration) {
+	method = methodLabel(method)
+	class := statusClass(status)
+	requestsTotal.WithLabelValues(service, upstream, method, class).Inc()
+	requestDuration.WithLabelValues(service, upstream, method, class).Observe(duration.Seconds())
+}
+
+// StartForwarding counts a request being forwarded to an upstream, until the returned function
+// is called.
+func StartForwarding(service string, upstream string, method string) func() {
+	gauge := requestsInFlight.WithLabelValues(service, upstream, methodLabel(method))
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// SetUpstreamHealth records whether the last request to the endpoint of an upstream succeeded.
+func SetUpstreamHealth(service string, upstream string, endpoint string, healthy bool) {
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	upstreamHealthy.WithLabelValues(service, upstream, endpoint).Set(value)
+}
+
+// RemoveService deletes the series of a service, so services that were removed do not keep
+// their series forever.
+func RemoveService(service string) {
+	deleteSeries(prometheus.Labels{"service": service})
+}
+
+// RemoveUpstream deletes the series of an upstream of a service.
+func RemoveUpstream(service string, upstream string) {
+	deleteSeries(prometheus.Labels{"service": service, "upstream": upstream})
+}
+
+func deleteSeries(labels prometheus.Labels) {
+	requestsTotal.DeletePartialMatch(labels)
+	requestDuration.DeletePartialMatch(labels)
+	requestsInFlight.DeletePartialMatch(labels)
+	upstreamHealthy.DeletePartialMatch(labels)
+}
+
+// Run deletes the series of services and upstreams as they are removed, which is published on bus,
+// until ctx is done.
+func Run(ctx context.Context, bus *events.Bus) {
+	bus.Watch(ctx, func(event *events.Event) {
+		switch event.Type {
+		case events.ServiceRemoved:
+			RemoveService(event.Service)
+		case events.UpstreamRemoved:
+			RemoveUpstream(event.Service, event.Upstream)
+		}
+	})
+}
+
+// SetCertificateExpiry records when the certificate loaded from file expires.
+func SetCertificateExpiry(file string, certificate *x509.Certificate) {
+	certificateExpiry.WithLabelValues(file, certificate.Subject.CommonName).Set(float64(certificate.NotAfter.Unix()))
+}
+
+// ObserveTransaction records how long a bolt transaction, of type view or update, was open since
+// start.
+func ObserveTransaction(transactionType string, start time.Time) {
+	boltTransactionDuration.WithLabelValues(transactionType).Observe(time.Since(start).Seconds())
+}
+
+// RegistrySize returns the number of services and upstreams in the registry.
+type RegistrySize func() (services int, upstreams int, err error)
+
+// registryCollector reports the size of the registry when metrics are collected.
+type registryCollector struct {
+	size RegistrySize
+}
+
+// RegisterRegistrySize reports the registry size from size every time metrics are collected.
+func RegisterRegistrySize(size RegistrySize) error {
+	return Registry.Register(&registryCollector{size: size})
+}
+
+func (c *registryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- registryServicesDesc
+	ch <- registryUpstreamsDesc
+}
+
+func (c *registryCollector) Collect(ch chan<- prometheus.Metric) {
+	services, upstreams, err := c.size()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(registryServicesDesc, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(registryServicesDesc, prometheus.GaugeValue, float64(services))
+	ch <- prometheus.MustNewConstMetric(registryUpstreamsDesc, prometheus.GaugeValue, float64(upstreams))
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/premkit/premkit/events"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObserveRequest(t *testing.T) {
+	ObserveRequest("service", "http://localhost:3000", "GET", 204, 10*time.Millisecond)
+	ObserveRequest("service", "http://localhost:3000", "GET", 200, 10*time.Millisecond)
+	ObserveRequest("service", "http://localhost:3000", "BREW", 503, 10*time.Millisecond)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(requestsTotal.WithLabelValues("service", "http://localhost:3000", "GET", "2xx")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(requestsTotal.WithLabelValues("service", "http://localhost:3000", "OTHER", "5xx")))
+
+	done := StartForwarding("service", "http://localhost:3000", "POST")
+	assert.Equal(t, 1.0, testutil.ToFloat64(requestsInFlight.WithLabelValues("service", "http://localhost:3000", "POST")))
+	done()
+	assert.Equal(t, 0.0, testutil.ToFloat64(requestsInFlight.WithLabelValues("service", "http://localhost:3000", "POST")))
+}
+
+func TestGauges(t *testing.T) {
+	SetUpstreamHealth("service", "http://upstream", "http://10.0.0.1", false)
+	assert.Equal(t, 0.0, testutil.ToFloat64(upstreamHealthy.WithLabelValues("service", "http://upstream", "http://10.0.0.1")))
+	SetUpstreamHealth("service", "http://upstream", "http://10.0.0.1", true)
+	assert.Equal(t, 1.0, testutil.ToFloat64(upstreamHealthy.WithLabelValues("service", "http://upstream", "http://10.0.0.1")))
+
+	notAfter := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	SetCertificateExpiry("/data/tls/cert.pem", &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "premkit"},
+		NotAfter: notAfter,
+	})
+	assert.Equal(t, float64(notAfter.Unix()), testutil.ToFloat64(certificateExpiry.WithLabelValues("/data/tls/cert.pem", "premkit")))
+}
+
+func TestRemoveSeries(t *testing.T) {
+	ObserveRequest("removed", "http://a", "GET", 200, time.Millisecond)
+	ObserveRequest("removed", "http://b", "GET", 200, time.Millisecond)
+	StartForwarding("removed", "http://a", "GET")()
+	SetUpstreamHealth("removed", "http://a", "http://10.0.0.1", true)
+	SetUpstreamHealth("removed", "http://b", "http://10.0.0.2", true)
+
+	RemoveUpstream("removed", "http://a")
+	assert.False(t, requestsTotal.DeleteLabelValues("removed", "http://a", "GET", "2xx"))
+	assert.False(t, requestDuration.DeleteLabelValues("removed", "http://a", "GET", "2xx"))
+	assert.False(t, requestsInFlight.DeleteLabelValues("removed", "http://a", "GET"))
+	assert.False(t, upstreamHealthy.DeleteLabelValues("removed", "http://a", "http://10.0.0.1"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(upstreamHealthy.WithLabelValues("removed", "http://b", "http://10.0.0.2")))
+
+	RemoveService("removed")
+	assert.False(t, requestsTotal.DeleteLabelValues("removed", "http://b", "GET", "2xx"))
+	assert.False(t, upstreamHealthy.DeleteLabelValues("removed", "http://b", "http://10.0.0.2"))
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := events.NewBus()
+	go Run(ctx, bus)
+
+	SetUpstreamHealth("watched", "http://a", "http://10.0.0.1", true)
+
+	// Run may not have subscribed yet, so keep publishing until the series is removed
+	require.Eventually(t, func() bool {
+		bus.Publish(&events.Event{Type: events.UpstreamRemoved, Service: "watched", Upstream: "http://a"})
+		return testutil.ToFloat64(upstreamHealthy.WithLabelValues("watched", "http://a", "http://10.0.0.1")) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRegistryCollector(t *testing.T) {
+	collector := &registryCollector{size: func() (int, int, error) {
+		return 3, 5, nil
+	}}
+	assert.Equal(t, 2, testutil.CollectAndCount(collector))
+
+	failing := &registryCollector{size: func() (int, int, error) {
+		return 0, 0, errors.New("the registry cannot be read")
+	}}
+	_, err := testutil.CollectAndLint(failing)
+	assert.Error(t, err)
+}
+
+func TestHandler(t *testing.T) {
+	ObserveTransaction("view", time.Now())
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := ioutil.ReadAll(recorder.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `premkit_bolt_transaction_duration_seconds_count{type="view"}`)
+	assert.Contains(t, string(body), "go_goroutines")
+}
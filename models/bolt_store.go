@@ -0,0 +1,658 @@
+package models
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/premkit/premkit/log"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltStore stores each service in a service:<name> bucket, with each of its upstreams in a
+// nested upstream:<url> bucket.  The revisions of a service are kept in a history:<name> bucket,
+// which is not deleted with the service, keyed by their big endian number.
+type boltStore struct {
+	db *bolt.DB
+
+	// observe is called with the type, view or update, and the start of every transaction once
+	// it is closed.
+	observe func(transactionType string, start time.Time)
+}
+
+// NewBoltStore returns a store backed by the bolt database.  When observe is not nil, it is called
+// with the type, view or update, and the start of every transaction once it is closed, so the
+// daemon can record metrics without models depending on them.
+func NewBoltStore(db *bolt.DB, observe func(transactionType string, start time.Time)) Store {
+	if observe == nil {
+		observe = func(transactionType string, start time.Time) {}
+	}
+
+	return &boltStore{db: db, observe: observe}
+}
+
+// DB returns the bolt database of the store.
+func (s *boltStore) DB() *bolt.DB {
+	return s.db
+}
+
+func (s *boltStore) View(fn func(tx Tx) error) error {
+	defer s.observe("view", time.Now())
+
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx: tx})
+	})
+}
+
+func (s *boltStore) Update(fn func(tx Tx) error) error {
+	defer s.observe("update", time.Now())
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx: tx})
+	})
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func serviceBucketName(name string) []byte {
+	return []byte(fmt.Sprintf("service:%s", name))
+}
+
+func historyBucketName(name string) []byte {
+	return []byte(fmt.Sprintf("history:%s", name))
+}
+
+func upstreamBucketName(url string) []byte {
+	return []byte(fmt.Sprintf("upstream:%s", url))
+}
+
+// upstreamBucketNames returns the names of the upstream buckets nested in the service bucket.
+// Keys that are not buckets have a value, and are skipped.
+func upstreamBucketNames(serviceBucket *bolt.Bucket) ([][]byte, error) {
+	names := make([][]byte, 0, 0)
+	err := serviceBucket.ForEach(func(k, v []byte) error {
+		if strings.HasPrefix(string(k), "upstream:") && v == nil {
+			names = append(names, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return names, nil
+}
+
+func (t *boltTx) ServiceNames() ([]string, error) {
+	names := make([]string, 0, 0)
+	err := t.tx.ForEach(func(bucketName []byte, b *bolt.Bucket) error {
+		if strings.HasPrefix(string(bucketName), "service:") {
+			names = append(names, strings.TrimPrefix(string(bucketName), "service:"))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return names, nil
+}
+
+func (t *boltTx) GetService(name string) (*Service, error) {
+	serviceBucket := t.tx.Bucket(serviceBucketName(name))
+	if serviceBucket == nil {
+		return nil, nil
+	}
+
+	service := Service{
+		Name:      name,
+		Upstreams: make([]*Upstream, 0, 0),
+	}
+	if err := readServiceFields(serviceBucket, &service); err != nil {
+		return nil, err
+	}
+
+	upstreamNames, err := upstreamBucketNames(serviceBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, upstreamName := range upstreamNames {
+		upstream := Upstream{
+			URL: strings.TrimPrefix(string(upstreamName), "upstream:"),
+		}
+		if err := readUpstreamFields(serviceBucket.Bucket(upstreamName), &upstream); err != nil {
+			return nil, err
+		}
+
+		service.Upstreams = append(service.Upstreams, &upstream)
+	}
+
+	return &service, nil
+}
+
+func (t *boltTx) PutService(service *Service) error {
+	serviceBucket, err := t.tx.CreateBucketIfNotExists(serviceBucketName(service.Name))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if err := writeServiceFields(serviceBucket, service); err != nil {
+		return err
+	}
+
+	// Replace the upstreams
+	upstreamNames, err := upstreamBucketNames(serviceBucket)
+	if err != nil {
+		return err
+	}
+
+	for _, upstreamName := range upstreamNames {
+		if err := serviceBucket.DeleteBucket(upstreamName); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+
+	for _, upstream := range service.Upstreams {
+		if err := putUpstream(serviceBucket, upstream); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *boltTx) DeleteService(name string) (bool, error) {
+	err := t.tx.DeleteBucket(serviceBucketName(name))
+	if err == bolt.ErrBucketNotFound {
+		return false, nil
+	}
+	if err != nil {
+		log.Error(err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (t *boltTx) PutUpstream(serviceName string, upstream *Upstream) error {
+	serviceBucket := t.tx.Bucket(serviceBucketName(serviceName))
+	if serviceBucket == nil {
+		err := fmt.Errorf("Service %q not found", serviceName)
+		log.Error(err)
+		return err
+	}
+
+	return putUpstream(serviceBucket, upstream)
+}
+
+func putUpstream(serviceBucket *bolt.Bucket, upstream *Upstream) error {
+	log.Debugf("Creating or updating upstream %q", upstream.URL)
+
+	upstreamBucket, err := serviceBucket.CreateBucketIfNotExists(upstreamBucketName(upstream.URL))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	return writeUpstreamFields(upstreamBucket, upstream)
+}
+
+func (t *boltTx) DeleteUpstream(serviceName string, url string) (bool, error) {
+	serviceBucket := t.tx.Bucket(serviceBucketName(serviceName))
+	if serviceBucket == nil {
+		return false, nil
+	}
+
+	err := serviceBucket.DeleteBucket(upstreamBucketName(url))
+	if err == bolt.ErrBucketNotFound {
+		return false, nil
+	}
+	if err != nil {
+		log.Error(err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (t *boltTx) AppendRevision(revision *Revision) error {
+	historyBucket, err := t.tx.CreateBucketIfNotExists(historyBucketName(revision.Service))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	sequence, err := historyBucket.NextSequence()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	revision.Number = int(sequence)
+
+	b, err := json.Marshal(revision)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, sequence)
+	if err := historyBucket.Put(key, b); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+func (t *boltTx) HistoryNames() ([]string, error) {
+	names := make([]string, 0, 0)
+	err := t.tx.ForEach(func(bucketName []byte, b *bolt.Bucket) error {
+		if strings.HasPrefix(string(bucketName), "history:") {
+			names = append(names, strings.TrimPrefix(string(bucketName), "history:"))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// DeleteRevisions deletes the history bucket when every revision is deleted, which restarts the
+// numbering of the service.
+func (t *boltTx) DeleteRevisions(serviceName string, number int) error {
+	historyBucket := t.tx.Bucket(historyBucketName(serviceName))
+	if historyBucket == nil {
+		return nil
+	}
+
+	if uint64(number) >= historyBucket.Sequence() {
+		if err := t.tx.DeleteBucket(historyBucketName(serviceName)); err != nil {
+			log.Error(err)
+			return err
+		}
+		return nil
+	}
+
+	last := make([]byte, 8)
+	binary.BigEndian.PutUint64(last, uint64(number))
+
+	cursor := historyBucket.Cursor()
+	for k, _ := cursor.First(); k != nil && string(k) <= string(last); k, _ = cursor.First() {
+		if err := cursor.Delete(); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *boltTx) Revisions(serviceName string) ([]*Revision, error) {
+	revisions := make([]*Revision, 0, 0)
+
+	historyBucket := t.tx.Bucket(historyBucketName(serviceName))
+	if historyBucket == nil {
+		return revisions, nil
+	}
+
+	err := historyBucket.ForEach(func(k, v []byte) error {
+		revision := Revision{}
+		if err := json.Unmarshal(v, &revision); err != nil {
+			return err
+		}
+		revisions = append(revisions, &revision)
+		return nil
+	})
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// writeServiceFields writes the scalar fields of a service to its bucket.  Upstreams are
+// written separately.
+func writeServiceFields(serviceBucket *bolt.Bucket, service *Service) error {
+	if err := serviceBucket.Put([]byte("path"), []byte(service.Path)); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if err := serviceBucket.Put([]byte("priority"), []byte(strconv.Itoa(service.Priority))); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if err := putJSON(serviceBucket, []byte("match"), service.Match); err != nil {
+		return err
+	}
+
+	if err := putJSON(serviceBucket, []byte("headers"), service.Headers); err != nil {
+		return err
+	}
+
+	if len(service.Rewrites) == 0 {
+		service.Rewrites = nil
+	}
+	if err := putJSON(serviceBucket, []byte("rewrites"), service.Rewrites); err != nil {
+		return err
+	}
+
+	if err := serviceBucket.Put([]byte("kind"), []byte(service.Kind)); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if err := putJSON(serviceBucket, []byte("redirect"), service.Redirect); err != nil {
+		return err
+	}
+
+	if err := putJSON(serviceBucket, []byte("response"), service.Response); err != nil {
+		return err
+	}
+
+	if err := serviceBucket.Put([]byte("ttl"), []byte(strconv.Itoa(service.TTL))); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if err := putTime(serviceBucket, []byte("expires"), service.Expires); err != nil {
+		return err
+	}
+
+	if err := serviceBucket.Put([]byte("source"), []byte(service.Source)); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if err := putTime(serviceBucket, []byte("registered"), nonZeroTime(service.Registered)); err != nil {
+		return err
+	}
+
+	if err := putTime(serviceBucket, []byte("updated"), nonZeroTime(service.Updated)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// readServiceFields reads the scalar fields of a service from its bucket.  Keys that are missing
+// were written by an older version, and are left at their zero value.
+func readServiceFields(serviceBucket *bolt.Bucket, service *Service) error {
+	service.Path = string(serviceBucket.Get([]byte("path")))
+
+	if priority := serviceBucket.Get([]byte("priority")); priority != nil {
+		i, err := strconv.Atoi(string(priority))
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		service.Priority = i
+	}
+
+	if match := serviceBucket.Get([]byte("match")); match != nil {
+		service.Match = &Match{}
+		if err := json.Unmarshal(match, service.Match); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+
+	if headers := serviceBucket.Get([]byte("headers")); headers != nil {
+		service.Headers = &Headers{}
+		if err := json.Unmarshal(headers, service.Headers); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+
+	if rewrites := serviceBucket.Get([]byte("rewrites")); rewrites != nil {
+		if err := json.Unmarshal(rewrites, &service.Rewrites); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+
+	service.Kind = string(serviceBucket.Get([]byte("kind")))
+
+	if redirect := serviceBucket.Get([]byte("redirect")); redirect != nil {
+		service.Redirect = &Redirect{}
+		if err := json.Unmarshal(redirect, service.Redirect); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+
+	if response := serviceBucket.Get([]byte("response")); response != nil {
+		service.Response = &StaticResponse{}
+		if err := json.Unmarshal(response, service.Response); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+
+	if ttl := serviceBucket.Get([]byte("ttl")); ttl != nil {
+		i, err := strconv.Atoi(string(ttl))
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		service.TTL = i
+	}
+
+	expires, err := getTime(serviceBucket, []byte("expires"))
+	if err != nil {
+		return err
+	}
+	service.Expires = expires
+
+	service.Source = string(serviceBucket.Get([]byte("source")))
+
+	registered, err := getTime(serviceBucket, []byte("registered"))
+	if err != nil {
+		return err
+	}
+	if registered != nil {
+		service.Registered = *registered
+	}
+
+	updated, err := getTime(serviceBucket, []byte("updated"))
+	if err != nil {
+		return err
+	}
+	if updated != nil {
+		service.Updated = *updated
+	}
+
+	return nil
+}
+
+// writeUpstreamFields writes the fields of an upstream to its bucket.
+func writeUpstreamFields(upstreamBucket *bolt.Bucket, upstream *Upstream) error {
+	if err := upstreamBucket.Put([]byte("url"), []byte(upstream.URL)); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if err := upstreamBucket.Put([]byte("include.service.path"), []byte(strconv.FormatBool(upstream.IncludeServicePath))); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if err := upstreamBucket.Put([]byte("insecure.skip.verify"), []byte(strconv.FormatBool(upstream.InsecureSkipVerify))); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if err := upstreamBucket.Put([]byte("ttl"), []byte(strconv.Itoa(upstream.TTL))); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if err := putTime(upstreamBucket, []byte("expires"), upstream.Expires); err != nil {
+		return err
+	}
+
+	if err := upstreamBucket.Put([]byte("resolve"), []byte(upstream.Resolve)); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if err := upstreamBucket.Put([]byte("resolve.interval"), []byte(strconv.Itoa(upstream.ResolveInterval))); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if err := upstreamBucket.Put([]byte("draining"), []byte(strconv.FormatBool(upstream.Draining))); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// readUpstreamFields reads the fields of an upstream from its bucket.  Keys that are missing were
+// written by an older version, and are left at their zero value.
+func readUpstreamFields(upstreamBucket *bolt.Bucket, upstream *Upstream) error {
+	b, err := strconv.ParseBool(string(upstreamBucket.Get([]byte("include.service.path"))))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	upstream.IncludeServicePath = b
+
+	b, err = strconv.ParseBool(string(upstreamBucket.Get([]byte("insecure.skip.verify"))))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	upstream.InsecureSkipVerify = b
+
+	if ttl := upstreamBucket.Get([]byte("ttl")); ttl != nil {
+		i, err := strconv.Atoi(string(ttl))
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		upstream.TTL = i
+	}
+
+	expires, err := getTime(upstreamBucket, []byte("expires"))
+	if err != nil {
+		return err
+	}
+	upstream.Expires = expires
+
+	upstream.Resolve = string(upstreamBucket.Get([]byte("resolve")))
+
+	if interval := upstreamBucket.Get([]byte("resolve.interval")); interval != nil {
+		i, err := strconv.Atoi(string(interval))
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		upstream.ResolveInterval = i
+	}
+
+	if draining := upstreamBucket.Get([]byte("draining")); draining != nil {
+		b, err := strconv.ParseBool(string(draining))
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		upstream.Draining = b
+	}
+
+	return nil
+}
+
+// putJSON stores the JSON encoding of v in the bucket, or removes the key if v is nil.
+func putJSON(bucket *bolt.Bucket, key []byte, v interface{}) error {
+	if reflect.ValueOf(v).IsNil() {
+		return deleteKey(bucket, key)
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if err := bucket.Put(key, b); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// deleteKey removes the key if it is present.  Bolt fails to delete a missing key that sorts
+// just before a nested bucket, such as the upstream buckets of a service.
+func deleteKey(bucket *bolt.Bucket, key []byte) error {
+	if bucket.Get(key) == nil {
+		return nil
+	}
+
+	if err := bucket.Delete(key); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// putTime stores t in the bucket, or removes the key if t is nil.
+func putTime(bucket *bolt.Bucket, key []byte, t *time.Time) error {
+	if t == nil {
+		return deleteKey(bucket, key)
+	}
+
+	if err := bucket.Put(key, []byte(t.Format(time.RFC3339Nano))); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// nonZeroTime returns nil for the zero time, so it is not stored by putTime.
+func nonZeroTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+// getTime reads a time stored with putTime, returning nil if the key is not present.
+func getTime(bucket *bolt.Bucket, key []byte) (*time.Time, error) {
+	b := bucket.Get(key)
+	if b == nil {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, string(b))
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return &t, nil
+}
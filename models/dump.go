@@ -0,0 +1,137 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/premkit/premkit/log"
+)
+
+// DumpVersion is the version of the dump format written by DumpServices.
+const DumpVersion = 1
+
+// restoreActor is the actor of the revisions of services changed by RestoreServices.
+const restoreActor = "restore"
+
+// Dump is a portable copy of every service and upstream in the database.  Upstreams are written
+// with the service they belong to.
+type Dump struct {
+	Version  int        `json:"version"`
+	Created  time.Time  `json:"created"`
+	Services []*Service `json:"services"`
+}
+
+// DumpServices returns a dump of the database.
+func (r *Registry) DumpServices() (*Dump, error) {
+	services, err := r.ListServices()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Dump{
+		Version:  DumpVersion,
+		Created:  time.Now(),
+		Services: services,
+	}, nil
+}
+
+// RestoreServices replaces every service and upstream in the database with the ones in the dump,
+// in a single transaction.  Nothing is changed if any service in the dump is invalid.  The history
+// of each service is kept, with a revision for the restore.
+func (r *Registry) RestoreServices(dump *Dump) error {
+	if dump.Version != DumpVersion {
+		err := fmt.Errorf("Unsupported dump version %d", dump.Version)
+		log.Error(err)
+		return err
+	}
+
+	names := make(map[string]bool)
+	for _, service := range dump.Services {
+		if service.Name == "" || names[service.Name] {
+			err := fmt.Errorf("Invalid or duplicate service name %q", service.Name)
+			log.Error(err)
+			return err
+		}
+		names[service.Name] = true
+
+		if err := validateService(service); err != nil {
+			return err
+		}
+		service.Path = strings.TrimPrefix(service.Path, "/")
+	}
+
+	return r.update(func(tx Tx) error {
+		names, err := tx.ServiceNames()
+		if err != nil {
+			return err
+		}
+
+		replaced := make(map[string]*Service)
+		for _, name := range names {
+			service, err := tx.GetService(name)
+			if err != nil {
+				return err
+			}
+			replaced[name] = service
+
+			if _, err := tx.DeleteService(name); err != nil {
+				return err
+			}
+		}
+
+		for _, service := range dump.Services {
+			if err := tx.PutService(service); err != nil {
+				return err
+			}
+
+			if err := recordRevision(tx, service.Name, replaced[service.Name], service, service.Source, restoreActor); err != nil {
+				return err
+			}
+			delete(replaced, service.Name)
+		}
+
+		for _, name := range names {
+			if service, ok := replaced[name]; ok {
+				if err := recordRevision(tx, name, service, nil, service.Source, restoreActor); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// Verify checks that every service can be read and is valid.  Returns a description of each
+// problem found.
+func (r *Registry) Verify() ([]string, error) {
+	problems := make([]string, 0, 0)
+	err := r.view(func(tx Tx) error {
+		names, err := tx.ServiceNames()
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			service, err := tx.GetService(name)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("Service %q cannot be read: %v", name, err))
+				continue
+			}
+
+			if err := validateService(service); err != nil {
+				problems = append(problems, fmt.Sprintf("Service %q is invalid: %v", name, err))
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return problems, nil
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpAndRestore(t *testing.T) {
+	registry := setup(t)
+
+	_, err := registry.CreateService(&Service{
+		Name:     "test",
+		Path:     "test",
+		Priority: 3,
+		Upstreams: []*Upstream{
+			&Upstream{URL: "http://localhost:3000", IncludeServicePath: true},
+		},
+	})
+	require.NoError(t, err)
+
+	dump, err := registry.DumpServices()
+	require.NoError(t, err)
+	assert.Equal(t, DumpVersion, dump.Version)
+	require.Equal(t, 1, len(dump.Services))
+
+	b, err := json.Marshal(dump)
+	require.NoError(t, err)
+
+	// Change the database after the dump
+	_, err = registry.CreateService(&Service{
+		Name: "other",
+		Path: "other",
+		Upstreams: []*Upstream{
+			&Upstream{URL: "http://localhost:3001"},
+		},
+	})
+	require.NoError(t, err)
+
+	restored := Dump{}
+	require.NoError(t, json.Unmarshal(b, &restored))
+	require.NoError(t, registry.RestoreServices(&restored))
+
+	services, err := registry.ListServices()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(services))
+	assert.Equal(t, "test", services[0].Name)
+	assert.Equal(t, 3, services[0].Priority)
+	require.Equal(t, 1, len(services[0].Upstreams))
+	assert.True(t, services[0].Upstreams[0].IncludeServicePath)
+
+	problems, err := registry.Verify()
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+}
+
+func TestRestoreInvalidDump(t *testing.T) {
+	registry := setup(t)
+
+	_, err := registry.CreateService(&Service{Name: "test", Path: "test"})
+	require.NoError(t, err)
+
+	err = registry.RestoreServices(&Dump{
+		Version: DumpVersion,
+		Services: []*Service{
+			&Service{Name: "valid", Path: "valid"},
+			&Service{Name: "invalid", Kind: "unknown"},
+		},
+	})
+	assert.Error(t, err)
+
+	err = registry.RestoreServices(&Dump{Version: DumpVersion + 1})
+	assert.Error(t, err)
+
+	// Nothing was changed
+	services, err := registry.ListServices()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(services))
+	assert.Equal(t, "test", services[0].Name)
+}
+
+func TestVerify(t *testing.T) {
+	registry := setup(t)
+
+	_, err := registry.CreateService(&Service{Name: "valid", Path: "valid"})
+	require.NoError(t, err)
+
+	db := registry.Store().(*boltStore).DB()
+	err = db.Update(func(tx *bolt.Tx) error {
+		unreadable, err := tx.CreateBucket([]byte("service:unreadable"))
+		if err != nil {
+			return err
+		}
+		if err := unreadable.Put([]byte("priority"), []byte("high")); err != nil {
+			return err
+		}
+
+		invalid, err := tx.CreateBucket([]byte("service:invalid"))
+		if err != nil {
+			return err
+		}
+		return invalid.Put([]byte("kind"), []byte("unknown"))
+	})
+	require.NoError(t, err)
+
+	problems, err := registry.Verify()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(problems))
+	assert.Contains(t, problems[0], fmt.Sprintf("Service %q is invalid", "invalid"))
+	assert.Contains(t, problems[1], fmt.Sprintf("Service %q cannot be read", "unreadable"))
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"github.com/premkit/premkit/events"
+)
+
+// revisionTx keeps the revisions appended in a transaction, so the changes can be published once
+// it is committed.
+type revisionTx struct {
+	Tx
+	revisions []*Revision
+}
+
+func (tx *revisionTx) AppendRevision(revision *Revision) error {
+	if err := tx.Tx.AppendRevision(revision); err != nil {
+		return err
+	}
+
+	tx.revisions = append(tx.revisions, revision)
+	return nil
+}
+
+// publishChanges publishes the events of committed revisions.
+func (r *Registry) publishChanges(revisions []*Revision) {
+	for _, revision := range revisions {
+		for _, event := range changeEvents(revision) {
+			r.events.Publish(event)
+		}
+	}
+}
+
+// changeEvents returns an event for the change of the service in the revision, followed by an
+// event for each upstream added to or removed from a service that was updated.  Removals made
+// because a lease expired follow an events.LeaseExpired event.
+func changeEvents(revision *Revision) []*events.Event {
+	change := func(eventType string, upstream string) *events.Event {
+		return &events.Event{
+			Type:     eventType,
+			Service:  revision.Service,
+			Upstream: upstream,
+			Time:     revision.Time,
+		}
+	}
+	expired := revision.Actor == leaseActor
+
+	if revision.Before == nil {
+		return []*events.Event{change(events.ServiceAdded, "")}
+	}
+	if revision.After == nil {
+		if expired {
+			return []*events.Event{change(events.LeaseExpired, ""), change(events.ServiceRemoved, "")}
+		}
+		return []*events.Event{change(events.ServiceRemoved, "")}
+	}
+
+	changes := []*events.Event{change(events.ServiceUpdated, "")}
+
+	before := make(map[string]bool)
+	for _, upstream := range revision.Before.Upstreams {
+		before[upstream.URL] = true
+	}
+	after := make(map[string]bool)
+	for _, upstream := range revision.After.Upstreams {
+		after[upstream.URL] = true
+		if !before[upstream.URL] {
+			changes = append(changes, change(events.UpstreamAdded, upstream.URL))
+		}
+	}
+	for _, upstream := range revision.Before.Upstreams {
+		if !after[upstream.URL] {
+			if expired {
+				changes = append(changes, change(events.LeaseExpired, upstream.URL))
+			}
+			changes = append(changes, change(events.UpstreamRemoved, upstream.URL))
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/premkit/premkit/events"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishChanges(t *testing.T) {
+	registry := setup(t)
+
+	ch, cancel := registry.Events().Subscribe()
+	defer cancel()
+
+	next := func() *events.Event {
+		select {
+		case event := <-ch:
+			return event
+		default:
+			return nil
+		}
+	}
+
+	_, err := registry.CreateService(&Service{
+		Name:      "service",
+		Path:      "service",
+		Upstreams: []*Upstream{&Upstream{URL: "http://localhost:3000"}},
+	})
+	require.NoError(t, err)
+
+	event := next()
+	require.NotNil(t, event)
+	assert.Equal(t, events.ServiceAdded, event.Type)
+	assert.Equal(t, "service", event.Service)
+	assert.Nil(t, next())
+
+	_, err = registry.AddUpstream([]byte("service"), &Upstream{URL: "http://localhost:3001"}, "")
+	require.NoError(t, err)
+
+	event = next()
+	require.NotNil(t, event)
+	assert.Equal(t, events.ServiceUpdated, event.Type)
+	event = next()
+	require.NotNil(t, event)
+	assert.Equal(t, events.UpstreamAdded, event.Type)
+	assert.Equal(t, "http://localhost:3001", event.Upstream)
+
+	_, err = registry.DeregisterService([]byte("service"), []byte("http://localhost:3000"), "")
+	require.NoError(t, err)
+
+	event = next()
+	require.NotNil(t, event)
+	assert.Equal(t, events.ServiceUpdated, event.Type)
+	event = next()
+	require.NotNil(t, event)
+	assert.Equal(t, events.UpstreamRemoved, event.Type)
+	assert.Equal(t, "http://localhost:3000", event.Upstream)
+
+	// Failed changes are not published
+	_, err = registry.CreateService(&Service{Name: "service", Path: "service", Kind: "unknown"})
+	require.Error(t, err)
+	assert.Nil(t, next())
+
+	_, err = registry.DeleteServiceByName([]byte("service"))
+	require.NoError(t, err)
+
+	event = next()
+	require.NotNil(t, event)
+	assert.Equal(t, events.ServiceRemoved, event.Type)
+	assert.Nil(t, next())
+}
+
+func TestPublishChangesToOwnBus(t *testing.T) {
+	registry := setup(t)
+	other := setup(t)
+
+	ch, cancel := other.Events().Subscribe()
+	defer cancel()
+
+	_, err := registry.CreateService(&Service{Name: "service", Path: "service"})
+	require.NoError(t, err)
+
+	select {
+	case event := <-ch:
+		t.Fatalf("received %s of service %q from another registry", event.Type, event.Service)
+	default:
+	}
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"fmt"
+	"text/template"
+)
+
+// Headers contains the header changes to make to a request before it is forwarded to an upstream,
+// and to the response before it is returned to the client.
+// swagger:model
+type Headers struct {
+	Request  *HeaderRules `json:"request,omitempty"`
+	Response *HeaderRules `json:"response,omitempty"`
+}
+
+// HeaderRules lists headers to remove, set and add, applied in that order.  Values are templates
+// which can reference {{.ClientIP}}, {{.RequestID}}, {{.Service}} and {{.Upstream}}.
+// swagger:model
+type HeaderRules struct {
+	Add    map[string]string `json:"add,omitempty"`
+	Set    map[string]string `json:"set,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+func validateHeaders(headers *Headers) error {
+	if headers == nil {
+		return nil
+	}
+
+	for _, rules := range []*HeaderRules{headers.Request, headers.Response} {
+		if rules == nil {
+			continue
+		}
+
+		for _, values := range []map[string]string{rules.Add, rules.Set} {
+			for name, value := range values {
+				if name == "" {
+					return fmt.Errorf("Header name is required")
+				}
+
+				if _, err := template.New(name).Parse(value); err != nil {
+					return fmt.Errorf("Invalid template for header %q: %v", name, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
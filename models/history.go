@@ -0,0 +1,230 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/premkit/premkit/log"
+)
+
+// ActorHeader is the request header that identifies who is making a change, such as a user name.
+// It is recorded, with the client address, in the history of the changed service.
+const ActorHeader = "X-Premkit-Actor"
+
+// Revision is one change to a service, kept in the append-only history of the service.  Before is
+// nil when the change created the service, and After is nil when it deleted the service.
+// swagger:model
+type Revision struct {
+	// Number orders the revisions of a service, starting at 1.
+	Number  int       `json:"number"`
+	Service string    `json:"service"`
+	Time    time.Time `json:"time"`
+
+	// Source is the source that made the change, such as SourceAPI or SourceFile.  Actor
+	// identifies who made it, when known.
+	Source string `json:"source"`
+	Actor  string `json:"actor,omitempty"`
+
+	Before *Service `json:"before"`
+	After  *Service `json:"after"`
+}
+
+// recordRevision appends the change of a service from before to after to its history.  Nothing
+// is recorded when the service did not change, such as when it is registered again to renew its
+// leases.
+func recordRevision(tx Tx, name string, before *Service, after *Service, source string, actor string) error {
+	if before == nil && after == nil {
+		return nil
+	}
+
+	same, err := unchanged(before, after)
+	if err != nil || same {
+		return err
+	}
+
+	if source == "" {
+		source = SourceAPI
+	}
+
+	revision := Revision{
+		Service: name,
+		Time:    time.Now(),
+		Source:  source,
+		Actor:   actor,
+		Before:  before,
+		After:   after,
+	}
+
+	return tx.AppendRevision(&revision)
+}
+
+// ListRevisions returns the history of the service, oldest first.  The history of a deleted
+// service is kept, so it can be rolled back.
+func (r *Registry) ListRevisions(name []byte) ([]*Revision, error) {
+	var revisions []*Revision
+	err := r.view(func(tx Tx) error {
+		r, err := tx.Revisions(string(name))
+		revisions = r
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// RollbackService returns the service to the state after a revision, or deletes it if the
+// revision deleted it.  The rolled back service is registered through the API, and its leases
+// start again.  Returns nil and false if there is no such revision, and ErrManagedService if the
+// service is managed by another source.
+func (r *Registry) RollbackService(name []byte, number int, actor string) (*Service, bool, error) {
+	var service *Service
+	found := false
+	err := r.update(func(tx Tx) error {
+		revisions, err := tx.Revisions(string(name))
+		if err != nil {
+			return err
+		}
+
+		var revision *Revision
+		for _, r := range revisions {
+			if r.Number == number {
+				revision = r
+			}
+		}
+		if revision == nil {
+			return nil
+		}
+		found = true
+
+		current, err := tx.GetService(string(name))
+		if err != nil {
+			return err
+		}
+		if current != nil && current.Managed() {
+			log.Errorf("Refusing to roll back service %q managed by %q", current.Name, current.Source)
+			return ErrManagedService
+		}
+
+		if revision.After == nil {
+			if current == nil {
+				return nil
+			}
+			if _, err := tx.DeleteService(current.Name); err != nil {
+				return err
+			}
+			return recordRevision(tx, current.Name, current, nil, SourceAPI, rollbackActor(actor, number))
+		}
+
+		service = revision.After
+		service.Source = SourceAPI
+		service.Updated = time.Now()
+		startLeases(service, service.Updated)
+
+		if err := tx.PutService(service); err != nil {
+			return err
+		}
+
+		return recordRevision(tx, service.Name, current, service, SourceAPI, rollbackActor(actor, number))
+	})
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return service, found, nil
+}
+
+// unchanged returns true if before and after only differ in the times that are set every time
+// a service is written.
+func unchanged(before *Service, after *Service) (bool, error) {
+	if before == nil || after == nil {
+		return false, nil
+	}
+
+	a, err := revisionJSON(before)
+	if err != nil {
+		return false, err
+	}
+	b, err := revisionJSON(after)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(a, b), nil
+}
+
+// revisionJSON returns the service as JSON without the times that are set every time it is
+// written, and with its upstreams in URL order.
+func revisionJSON(service *Service) ([]byte, error) {
+	copied, err := copyService(service)
+	if err != nil {
+		return nil, err
+	}
+
+	copied.Registered = time.Time{}
+	copied.Updated = time.Time{}
+	copied.Expires = nil
+	for _, upstream := range copied.Upstreams {
+		upstream.Expires = nil
+	}
+	sortUpstreams(copied.Upstreams)
+
+	return json.Marshal(copied)
+}
+
+// PruneHistory keeps at most maxRevisions revisions of each service, and deletes the history of
+// services that were deleted more than maxAge before now.  A zero limit is not applied.
+func (r *Registry) PruneHistory(now time.Time, maxRevisions int, maxAge time.Duration) error {
+	return r.update(func(tx Tx) error {
+		names, err := tx.HistoryNames()
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			revisions, err := tx.Revisions(name)
+			if err != nil {
+				return err
+			}
+			if len(revisions) == 0 {
+				continue
+			}
+			last := revisions[len(revisions)-1]
+
+			if maxAge > 0 && last.After == nil && now.Sub(last.Time) > maxAge {
+				current, err := tx.GetService(name)
+				if err != nil {
+					return err
+				}
+				if current == nil {
+					log.Infof("Removing the history of service %q, deleted at %s", name, last.Time)
+					if err := tx.DeleteRevisions(name, last.Number); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+
+			if maxRevisions > 0 && len(revisions) > maxRevisions {
+				if err := tx.DeleteRevisions(name, revisions[len(revisions)-maxRevisions-1].Number); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+func rollbackActor(actor string, number int) string {
+	if actor == "" {
+		return fmt.Sprintf("rollback to revision %d", number)
+	}
+
+	return fmt.Sprintf("%s (rollback to revision %d)", actor, number)
+}
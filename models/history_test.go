@@ -0,0 +1,204 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceHistory(t *testing.T) {
+	registry := setup(t)
+
+	_, err := registry.RegisterService(&Service{
+		Name:      "test",
+		Path:      "v1",
+		Source:    SourceAPI,
+		Upstreams: []*Upstream{&Upstream{URL: "http://a"}},
+	}, false, "alice")
+	require.NoError(t, err)
+
+	created, err := registry.getServiceByName([]byte("test"))
+	require.NoError(t, err)
+	assert.False(t, created.Registered.IsZero(), "the registration date should be stored")
+	assert.Equal(t, created.Registered, created.Updated)
+
+	_, err = registry.RegisterService(&Service{
+		Name:      "test",
+		Path:      "v2",
+		Source:    SourceAPI,
+		Upstreams: []*Upstream{&Upstream{URL: "http://b"}},
+	}, true, "bob")
+	require.NoError(t, err)
+
+	_, err = registry.SetDraining([]byte("test"), []byte("http://b"), true, "carol")
+	require.NoError(t, err)
+
+	updated, err := registry.getServiceByName([]byte("test"))
+	require.NoError(t, err)
+	assert.True(t, updated.Updated.After(created.Updated))
+
+	_, err = registry.DeregisterService([]byte("test"), nil, "dave")
+	require.NoError(t, err)
+
+	// The history is kept after the service is deleted
+	revisions, err := registry.ListRevisions([]byte("test"))
+	require.NoError(t, err)
+	require.Equal(t, 4, len(revisions))
+
+	for i, revision := range revisions {
+		assert.Equal(t, i+1, revision.Number)
+		assert.Equal(t, "test", revision.Service)
+		assert.Equal(t, SourceAPI, revision.Source)
+		assert.False(t, revision.Time.IsZero())
+	}
+
+	assert.Equal(t, "alice", revisions[0].Actor)
+	assert.Nil(t, revisions[0].Before)
+	assert.Equal(t, "v1", revisions[0].After.Path)
+
+	assert.Equal(t, "bob", revisions[1].Actor)
+	assert.Equal(t, "v1", revisions[1].Before.Path)
+	assert.Equal(t, "v2", revisions[1].After.Path)
+
+	assert.Equal(t, "carol", revisions[2].Actor)
+	assert.False(t, revisions[2].Before.Upstreams[0].Draining)
+	assert.True(t, revisions[2].After.Upstreams[0].Draining)
+
+	assert.Equal(t, "dave", revisions[3].Actor)
+	assert.Nil(t, revisions[3].After)
+
+	missing, err := registry.ListRevisions([]byte("missing"))
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+}
+
+func TestUnchangedServiceHistory(t *testing.T) {
+	registry := setup(t)
+
+	service := &Service{
+		Name:      "test",
+		Path:      "test",
+		Upstreams: []*Upstream{&Upstream{URL: "http://b", TTL: 60}, &Upstream{URL: "http://a", TTL: 60}},
+	}
+	for i := 0; i < 3; i++ {
+		_, err := registry.RegisterService(service, true, "")
+		require.NoError(t, err)
+	}
+
+	revisions, err := registry.ListRevisions([]byte("test"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(revisions), "registering the same service again should not add revisions")
+}
+
+func TestPruneHistory(t *testing.T) {
+	registry := setup(t)
+
+	for _, path := range []string{"v1", "v2", "v3", "v4"} {
+		_, err := registry.RegisterService(&Service{Name: "kept", Path: path}, true, "")
+		require.NoError(t, err)
+		_, err = registry.RegisterService(&Service{Name: "deleted", Path: path}, true, "")
+		require.NoError(t, err)
+	}
+	_, err := registry.DeregisterService([]byte("deleted"), nil, "")
+	require.NoError(t, err)
+
+	// The deleted service is too recent to lose its history
+	require.NoError(t, registry.PruneHistory(time.Now(), 2, time.Hour))
+
+	revisions, err := registry.ListRevisions([]byte("kept"))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(revisions))
+	assert.Equal(t, 3, revisions[0].Number)
+	assert.Equal(t, "v4", revisions[1].After.Path)
+
+	revisions, err = registry.ListRevisions([]byte("deleted"))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(revisions))
+	assert.Nil(t, revisions[1].After)
+
+	require.NoError(t, registry.PruneHistory(time.Now().Add(2*time.Hour), 2, time.Hour))
+
+	revisions, err = registry.ListRevisions([]byte("deleted"))
+	require.NoError(t, err)
+	assert.Empty(t, revisions)
+
+	revisions, err = registry.ListRevisions([]byte("kept"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(revisions), "the history of services that exist should be kept")
+}
+
+func TestRollbackService(t *testing.T) {
+	registry := setup(t)
+
+	_, err := registry.RegisterService(&Service{
+		Name:      "test",
+		Path:      "v1",
+		Source:    SourceAPI,
+		Upstreams: []*Upstream{&Upstream{URL: "http://a", TTL: 30}},
+	}, false, "")
+	require.NoError(t, err)
+
+	_, err = registry.RegisterService(&Service{
+		Name:      "test",
+		Path:      "v2",
+		Source:    SourceAPI,
+		Upstreams: []*Upstream{&Upstream{URL: "http://b"}},
+	}, true, "")
+	require.NoError(t, err)
+
+	service, found, err := registry.RollbackService([]byte("test"), 1, "alice")
+	require.NoError(t, err)
+	assert.True(t, found)
+	require.NotNil(t, service)
+	assert.Equal(t, "v1", service.Path)
+
+	service, err = registry.getServiceByName([]byte("test"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", service.Path)
+	require.Equal(t, 1, len(service.Upstreams))
+	assert.Equal(t, "http://a", service.Upstreams[0].URL)
+	assert.NotNil(t, service.Upstreams[0].Expires, "the lease should start again")
+
+	revisions, err := registry.ListRevisions([]byte("test"))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(revisions))
+	assert.Equal(t, "alice (rollback to revision 1)", revisions[2].Actor)
+	assert.Equal(t, "v2", revisions[2].Before.Path)
+
+	// Rolling back to a revision that deleted the service deletes it
+	_, err = registry.DeregisterService([]byte("test"), nil, "")
+	require.NoError(t, err)
+	_, _, err = registry.RollbackService([]byte("test"), 3, "")
+	require.NoError(t, err)
+
+	_, found, err = registry.RollbackService([]byte("test"), 4, "")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	service, err = registry.maybeGetServiceByName([]byte("test"))
+	require.NoError(t, err)
+	assert.Nil(t, service)
+
+	_, found, err = registry.RollbackService([]byte("test"), 100, "")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestRollbackManagedService(t *testing.T) {
+	registry := setup(t)
+
+	_, err := registry.CreateService(&Service{Name: "managed", Path: "v1", Source: SourceFile})
+	require.NoError(t, err)
+	_, err = registry.ReplaceService(&Service{Name: "managed", Path: "v2", Source: SourceFile})
+	require.NoError(t, err)
+
+	revisions, err := registry.ListRevisions([]byte("managed"))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(revisions))
+	assert.Equal(t, SourceFile, revisions[0].Source)
+
+	_, _, err = registry.RollbackService([]byte("managed"), 1, "")
+	assert.Equal(t, ErrManagedService, err)
+}
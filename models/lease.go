@@ -0,0 +1,136 @@
+package models
+
+import (
+	"time"
+
+	"github.com/premkit/premkit/log"
+)
+
+// leaseActor is the actor of the revisions of services changed when a lease expires.
+const leaseActor = "lease expiry"
+
+// startLeases sets the expiration of the service and its upstreams that were registered with a TTL.
+func startLeases(service *Service, now time.Time) {
+	service.Expires = leaseExpiration(service.TTL, now)
+	for _, upstream := range service.Upstreams {
+		upstream.Expires = leaseExpiration(upstream.TTL, now)
+	}
+}
+
+func leaseExpiration(ttl int, now time.Time) *time.Time {
+	if ttl <= 0 {
+		return nil
+	}
+
+	expires := now.Add(time.Duration(ttl) * time.Second)
+	return &expires
+}
+
+// RenewLease extends the leases of a service and all of its upstreams.  If upstreamURL is set, only
+// the lease of that upstream is renewed.  The renewed service is returned, or nil if the service
+// or upstream was not found.
+func (r *Registry) RenewLease(serviceName []byte, upstreamURL []byte) (*Service, error) {
+	now := time.Now()
+
+	var service *Service
+	err := r.update(func(tx Tx) error {
+		current, err := tx.GetService(string(serviceName))
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return nil
+		}
+
+		renewed := make([]*Upstream, 0, 0)
+		for _, upstream := range current.Upstreams {
+			if len(upstreamURL) > 0 && upstream.URL != string(upstreamURL) {
+				continue
+			}
+
+			upstream.Expires = leaseExpiration(upstream.TTL, now)
+			renewed = append(renewed, upstream)
+		}
+
+		if len(upstreamURL) > 0 && len(renewed) == 0 {
+			return nil
+		}
+
+		if len(upstreamURL) == 0 {
+			current.Expires = leaseExpiration(current.TTL, now)
+			if err := tx.PutService(current); err != nil {
+				return err
+			}
+		} else {
+			for _, upstream := range renewed {
+				if err := tx.PutUpstream(current.Name, upstream); err != nil {
+					return err
+				}
+			}
+		}
+
+		service = current
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return service, nil
+}
+
+// ExpireLeases removes every service and upstream with a lease that expired before now, and
+// publishes an events.LeaseExpired event for each once they are removed.  Leases are checked and
+// removed in a single transaction, so a lease renewed concurrently is never removed.
+func (r *Registry) ExpireLeases(now time.Time) error {
+	return r.update(func(tx Tx) error {
+		names, err := tx.ServiceNames()
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			service, err := tx.GetService(name)
+			if err != nil {
+				return err
+			}
+
+			if service.Expired(now) {
+				log.Infof("Lease of service %q expired at %s, removing it", service.Name, service.Expires)
+				if _, err := tx.DeleteService(service.Name); err != nil {
+					return err
+				}
+				if err := recordRevision(tx, service.Name, service, nil, service.Source, leaseActor); err != nil {
+					return err
+				}
+				continue
+			}
+
+			removed := false
+			for _, upstream := range service.Upstreams {
+				if !upstream.Expired(now) {
+					continue
+				}
+				removed = true
+
+				log.Infof("Lease of upstream %q of service %q expired at %s, removing it", upstream.URL, service.Name, upstream.Expires)
+				if _, err := tx.DeleteUpstream(service.Name, upstream.URL); err != nil {
+					return err
+				}
+			}
+
+			if removed {
+				after, err := touchService(tx, service.Name)
+				if err != nil {
+					return err
+				}
+				if err := recordRevision(tx, service.Name, service, after, service.Source, leaseActor); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
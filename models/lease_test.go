@@ -0,0 +1,127 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/premkit/premkit/events"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpireUpstreamLease(t *testing.T) {
+	registry := setup(t)
+
+	_, err := registry.CreateService(&Service{
+		Name: "leased",
+		Path: "leased",
+		Upstreams: []*Upstream{
+			&Upstream{URL: "permanent"},
+			&Upstream{URL: "leased", TTL: 10},
+		},
+	})
+	require.NoError(t, err)
+
+	service, err := registry.getServiceByName([]byte("leased"))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(service.Upstreams))
+
+	ch, cancel := registry.Events().Subscribe()
+	defer cancel()
+
+	// Nothing has expired yet
+	require.NoError(t, registry.ExpireLeases(time.Now()))
+	service, err = registry.getServiceByName([]byte("leased"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(service.Upstreams))
+
+	require.NoError(t, registry.ExpireLeases(time.Now().Add(time.Minute)))
+	service, err = registry.getServiceByName([]byte("leased"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(service.Upstreams))
+	assert.Equal(t, "permanent", service.Upstreams[0].URL)
+
+	upstream, err := registry.maybeGetUpstream([]byte("leased"), []byte("leased"))
+	require.NoError(t, err)
+	assert.Nil(t, upstream, "the expired upstream should be deleted")
+
+	event := <-ch
+	assert.Equal(t, events.ServiceUpdated, event.Type)
+	assert.Equal(t, "leased", event.Service)
+
+	event = <-ch
+	assert.Equal(t, events.LeaseExpired, event.Type)
+	assert.Equal(t, "leased", event.Service)
+	assert.Equal(t, "leased", event.Upstream)
+
+	event = <-ch
+	assert.Equal(t, events.UpstreamRemoved, event.Type)
+	assert.Equal(t, "leased", event.Upstream)
+}
+
+func TestExpireServiceLease(t *testing.T) {
+	registry := setup(t)
+
+	_, err := registry.CreateService(&Service{
+		Name: "leased",
+		Path: "leased",
+		TTL:  10,
+	})
+	require.NoError(t, err)
+
+	ch, cancel := registry.Events().Subscribe()
+	defer cancel()
+
+	require.NoError(t, registry.ExpireLeases(time.Now().Add(time.Minute)))
+
+	service, err := registry.maybeGetServiceByName([]byte("leased"))
+	require.NoError(t, err)
+	assert.Nil(t, service)
+
+	event := <-ch
+	assert.Equal(t, events.LeaseExpired, event.Type)
+	assert.Equal(t, "leased", event.Service)
+	assert.Empty(t, event.Upstream)
+
+	event = <-ch
+	assert.Equal(t, events.ServiceRemoved, event.Type)
+	assert.Equal(t, "leased", event.Service)
+}
+
+func TestRenewLease(t *testing.T) {
+	registry := setup(t)
+
+	created, err := registry.CreateService(&Service{
+		Name: "leased",
+		Path: "leased",
+		TTL:  10,
+		Upstreams: []*Upstream{
+			&Upstream{URL: "leased", TTL: 10},
+		},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, created.Expires)
+	firstExpiration := *created.Expires
+
+	time.Sleep(10 * time.Millisecond)
+
+	renewed, err := registry.RenewLease([]byte("leased"), nil)
+	require.NoError(t, err)
+	require.NotNil(t, renewed)
+
+	service, err := registry.getServiceByName([]byte("leased"))
+	require.NoError(t, err)
+	require.NotNil(t, service.Expires)
+	assert.True(t, service.Expires.After(firstExpiration))
+	require.NotNil(t, service.Upstreams[0].Expires)
+	assert.True(t, service.Upstreams[0].Expires.After(firstExpiration))
+
+	renewed, err = registry.RenewLease([]byte("leased"), []byte("unknown"))
+	require.NoError(t, err)
+	assert.Nil(t, renewed)
+
+	renewed, err = registry.RenewLease([]byte("unknown"), nil)
+	require.NoError(t, err)
+	assert.Nil(t, renewed)
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// regexes caches the compiled regexes of predicates and rewrites by pattern.  Services are read
+// from the store for every request, so a regex kept on the service would be compiled again each
+// time.
+var regexes sync.Map
+
+// compileRegex returns the compiled pattern, compiling it only the first time it is used.
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexes.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	regexes.Store(pattern, re)
+	return re, nil
+}
+
+// Match contains the predicates, in addition to the service path, that a request must satisfy to
+// be routed to a service.  All predicates must match.  An empty Match will match every request.
+// swagger:model
+type Match struct {
+	Methods []string     `json:"methods,omitempty"`
+	Headers []*Predicate `json:"headers,omitempty"`
+	Query   []*Predicate `json:"query,omitempty"`
+	Cookies []*Predicate `json:"cookies,omitempty"`
+}
+
+// Predicate matches a single named header, query parameter or cookie.  If Value is set, the
+// named item must equal it.  If Regex is set, the named item must match it.  If neither is set,
+// the named item only has to be present.
+// swagger:model
+type Predicate struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+	Regex string `json:"regex,omitempty"`
+}
+
+// Matches returns true if the request satisfies all predicates in the match.
+func (m *Match) Matches(request *http.Request) bool {
+	if m == nil {
+		return true
+	}
+
+	if len(m.Methods) > 0 {
+		found := false
+		for _, method := range m.Methods {
+			if strings.EqualFold(method, request.Method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for _, p := range m.Headers {
+		values, ok := request.Header[http.CanonicalHeaderKey(p.Name)]
+		if !p.matchesAny(values, ok) {
+			return false
+		}
+	}
+
+	query := request.URL.Query()
+	for _, p := range m.Query {
+		values, ok := query[p.Name]
+		if !p.matchesAny(values, ok) {
+			return false
+		}
+	}
+
+	for _, p := range m.Cookies {
+		cookie, err := request.Cookie(p.Name)
+		if err != nil {
+			return false
+		}
+		if !p.matchesAny([]string{cookie.Value}, true) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *Predicate) matchesAny(values []string, present bool) bool {
+	if !present {
+		return false
+	}
+
+	if p.Value == "" && p.Regex == "" {
+		return true
+	}
+
+	for _, value := range values {
+		if p.Value != "" && value != p.Value {
+			continue
+		}
+		if p.Regex != "" {
+			re, err := compileRegex(p.Regex)
+			if err != nil || !re.MatchString(value) {
+				continue
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func validateMatch(match *Match) error {
+	if match == nil {
+		return nil
+	}
+
+	predicates := make([]*Predicate, 0, 0)
+	predicates = append(predicates, match.Headers...)
+	predicates = append(predicates, match.Query...)
+	predicates = append(predicates, match.Cookies...)
+
+	for _, p := range predicates {
+		if p == nil || p.Name == "" {
+			return fmt.Errorf("Predicate name is required")
+		}
+
+		if p.Regex != "" {
+			if _, err := compileRegex(p.Regex); err != nil {
+				return fmt.Errorf("Invalid regex for predicate %q: %v", p.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
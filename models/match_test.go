@@ -0,0 +1,84 @@
+package models
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchNil(t *testing.T) {
+	var match *Match
+	request := httptest.NewRequest("GET", "/path", nil)
+	assert.True(t, match.Matches(request))
+}
+
+func TestMatchMethods(t *testing.T) {
+	match := &Match{Methods: []string{"get", "HEAD"}}
+
+	assert.True(t, match.Matches(httptest.NewRequest("GET", "/path", nil)))
+	assert.True(t, match.Matches(httptest.NewRequest("HEAD", "/path", nil)))
+	assert.False(t, match.Matches(httptest.NewRequest("POST", "/path", nil)))
+}
+
+func TestMatchHeaders(t *testing.T) {
+	match := &Match{
+		Headers: []*Predicate{
+			&Predicate{Name: "x-api-version", Value: "2"},
+			&Predicate{Name: "Accept", Regex: "^application/grpc-web"},
+		},
+	}
+
+	request := httptest.NewRequest("GET", "/path", nil)
+	request.Header.Set("X-Api-Version", "2")
+	request.Header.Set("Accept", "application/grpc-web+proto")
+	assert.True(t, match.Matches(request))
+
+	request.Header.Set("X-Api-Version", "1")
+	assert.False(t, match.Matches(request))
+
+	request.Header.Set("X-Api-Version", "2")
+	request.Header.Set("Accept", "text/html")
+	assert.False(t, match.Matches(request))
+}
+
+func TestMatchQuery(t *testing.T) {
+	match := &Match{
+		Query: []*Predicate{
+			&Predicate{Name: "debug"},
+		},
+	}
+
+	assert.True(t, match.Matches(httptest.NewRequest("GET", "/path?debug", nil)))
+	assert.True(t, match.Matches(httptest.NewRequest("GET", "/path?a=b&debug=1", nil)))
+	assert.False(t, match.Matches(httptest.NewRequest("GET", "/path?a=b", nil)))
+}
+
+func TestMatchCookies(t *testing.T) {
+	match := &Match{
+		Cookies: []*Predicate{
+			&Predicate{Name: "canary", Value: "true"},
+		},
+	}
+
+	request := httptest.NewRequest("GET", "/path", nil)
+	assert.False(t, match.Matches(request))
+
+	request.AddCookie(&http.Cookie{Name: "canary", Value: "false"})
+	assert.False(t, match.Matches(request))
+
+	request = httptest.NewRequest("GET", "/path", nil)
+	request.AddCookie(&http.Cookie{Name: "canary", Value: "true"})
+	assert.True(t, match.Matches(request))
+}
+
+func TestValidateMatch(t *testing.T) {
+	assert.NoError(t, validateMatch(nil))
+	assert.NoError(t, validateMatch(&Match{Headers: []*Predicate{&Predicate{Name: "a", Regex: "^b$"}}}))
+	assert.Error(t, validateMatch(&Match{Headers: []*Predicate{&Predicate{Name: "a", Regex: "("}}}))
+
+	_, cached := regexes.Load("^b$")
+	assert.True(t, cached, "validated regexes should be compiled once and kept")
+	assert.Error(t, validateMatch(&Match{Query: []*Predicate{&Predicate{Value: "b"}}}))
+}
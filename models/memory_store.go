@@ -0,0 +1,290 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// errReadOnly is returned when a memory store transaction from View is written to.
+var errReadOnly = errors.New("Transaction is read-only")
+
+// memoryStore keeps the registry in memory.  It is lost when premkit exits, so it is meant for
+// tests and ephemeral deployments that register every service at startup.
+type memoryStore struct {
+	mu        sync.RWMutex
+	services  map[string]*Service
+	revisions map[string][]*Revision
+}
+
+// NewMemoryStore returns an empty store that is not persisted.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		services:  make(map[string]*Service),
+		revisions: make(map[string][]*Revision),
+	}
+}
+
+func (s *memoryStore) View(fn func(tx Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return fn(&memoryTx{
+		services:  s.services,
+		revisions: s.revisions,
+		readOnly:  true,
+	})
+}
+
+// Update runs fn on copies of the maps, which replace the store's maps only if fn succeeds.
+// Stored values, and histories, are never modified in place, so the copies can share them.
+func (s *memoryStore) Update(fn func(tx Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &memoryTx{
+		services:  make(map[string]*Service, len(s.services)),
+		revisions: make(map[string][]*Revision, len(s.revisions)),
+	}
+	for name, service := range s.services {
+		tx.services[name] = service
+	}
+	for name, revisions := range s.revisions {
+		tx.revisions[name] = revisions
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	s.services = tx.services
+	s.revisions = tx.revisions
+	return nil
+}
+
+type memoryTx struct {
+	services  map[string]*Service
+	revisions map[string][]*Revision
+	readOnly  bool
+}
+
+func (t *memoryTx) ServiceNames() ([]string, error) {
+	names := make([]string, 0, len(t.services))
+	for name := range t.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (t *memoryTx) GetService(name string) (*Service, error) {
+	service, ok := t.services[name]
+	if !ok {
+		return nil, nil
+	}
+
+	return copyService(service)
+}
+
+func (t *memoryTx) PutService(service *Service) error {
+	if t.readOnly {
+		return errReadOnly
+	}
+
+	stored, err := copyService(service)
+	if err != nil {
+		return err
+	}
+
+	// Keep one upstream per URL, the last one written, in URL order
+	upstreams := make(map[string]*Upstream)
+	for _, upstream := range stored.Upstreams {
+		upstreams[upstream.URL] = upstream
+	}
+	stored.Upstreams = make([]*Upstream, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		stored.Upstreams = append(stored.Upstreams, upstream)
+	}
+	sortUpstreams(stored.Upstreams)
+
+	t.services[service.Name] = stored
+	return nil
+}
+
+func (t *memoryTx) DeleteService(name string) (bool, error) {
+	if t.readOnly {
+		return false, errReadOnly
+	}
+
+	if _, ok := t.services[name]; !ok {
+		return false, nil
+	}
+
+	delete(t.services, name)
+	return true, nil
+}
+
+func (t *memoryTx) PutUpstream(serviceName string, upstream *Upstream) error {
+	if t.readOnly {
+		return errReadOnly
+	}
+
+	service, err := t.GetService(serviceName)
+	if err != nil {
+		return err
+	}
+	if service == nil {
+		return fmt.Errorf("Service %q not found", serviceName)
+	}
+
+	upstreams := []*Upstream{copyUpstream(upstream)}
+	for _, u := range service.Upstreams {
+		if u.URL != upstream.URL {
+			upstreams = append(upstreams, u)
+		}
+	}
+	sortUpstreams(upstreams)
+	service.Upstreams = upstreams
+
+	t.services[serviceName] = service
+	return nil
+}
+
+func (t *memoryTx) DeleteUpstream(serviceName string, url string) (bool, error) {
+	if t.readOnly {
+		return false, errReadOnly
+	}
+
+	service, err := t.GetService(serviceName)
+	if err != nil || service == nil {
+		return false, err
+	}
+
+	upstreams := make([]*Upstream, 0, len(service.Upstreams))
+	for _, u := range service.Upstreams {
+		if u.URL != url {
+			upstreams = append(upstreams, u)
+		}
+	}
+	if len(upstreams) == len(service.Upstreams) {
+		return false, nil
+	}
+	service.Upstreams = upstreams
+
+	t.services[serviceName] = service
+	return true, nil
+}
+
+func (t *memoryTx) AppendRevision(revision *Revision) error {
+	if t.readOnly {
+		return errReadOnly
+	}
+
+	history := t.revisions[revision.Service]
+	revision.Number = 1
+	if len(history) > 0 {
+		revision.Number = history[len(history)-1].Number + 1
+	}
+
+	stored, err := copyRevision(revision)
+	if err != nil {
+		return err
+	}
+
+	// Copy the history, which may be shared with the store
+	t.revisions[revision.Service] = append(append(make([]*Revision, 0, len(history)+1), history...), stored)
+	return nil
+}
+
+func (t *memoryTx) HistoryNames() ([]string, error) {
+	names := make([]string, 0, len(t.revisions))
+	for name := range t.revisions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (t *memoryTx) DeleteRevisions(serviceName string, number int) error {
+	if t.readOnly {
+		return errReadOnly
+	}
+
+	kept := make([]*Revision, 0, len(t.revisions[serviceName]))
+	for _, revision := range t.revisions[serviceName] {
+		if revision.Number > number {
+			kept = append(kept, revision)
+		}
+	}
+
+	if len(kept) == 0 {
+		delete(t.revisions, serviceName)
+		return nil
+	}
+
+	t.revisions[serviceName] = kept
+	return nil
+}
+
+func (t *memoryTx) Revisions(serviceName string) ([]*Revision, error) {
+	revisions := make([]*Revision, 0, len(t.revisions[serviceName]))
+	for _, revision := range t.revisions[serviceName] {
+		copied, err := copyRevision(revision)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, copied)
+	}
+
+	return revisions, nil
+}
+
+func sortUpstreams(upstreams []*Upstream) {
+	sort.Slice(upstreams, func(i, j int) bool {
+		return upstreams[i].URL < upstreams[j].URL
+	})
+}
+
+// copyService returns a deep copy of the service, so callers can't change what is stored.
+func copyService(service *Service) (*Service, error) {
+	b, err := json.Marshal(service)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := Service{}
+	if err := json.Unmarshal(b, &copied); err != nil {
+		return nil, err
+	}
+
+	return &copied, nil
+}
+
+// copyRevision returns a deep copy of the revision.
+func copyRevision(revision *Revision) (*Revision, error) {
+	b, err := json.Marshal(revision)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := Revision{}
+	if err := json.Unmarshal(b, &copied); err != nil {
+		return nil, err
+	}
+
+	return &copied, nil
+}
+
+func copyUpstream(upstream *Upstream) *Upstream {
+	copied := *upstream
+	if upstream.Expires != nil {
+		expires := *upstream.Expires
+		copied.Expires = &expires
+	}
+
+	return &copied
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"text/template"
+)
+
+const (
+	// ServiceKindProxy forwards requests to the upstreams of the service.  This is the default.
+	ServiceKindProxy = "proxy"
+
+	// ServiceKindRedirect answers requests with a redirect, and has no upstreams.
+	ServiceKindRedirect = "redirect"
+
+	// ServiceKindStatic answers requests with a fixed response, and has no upstreams.
+	ServiceKindStatic = "static"
+)
+
+// redirectTemplates caches the parsed redirect URL templates by URL, like regexes.
+var redirectTemplates sync.Map
+
+// Redirect describes the redirect returned by a redirect service.  The URL is a template which
+// can reference {{.Host}}, {{.Path}} (the request path after the service path), {{.FullPath}}
+// and {{.Query}}.
+// swagger:model
+type Redirect struct {
+	URL       string `json:"url"`
+	Permanent bool   `json:"permanent"`
+
+	// PreserveMethod uses 307 and 308 redirects instead of 302 and 301, so clients repeat the
+	// request with the same method and body.
+	PreserveMethod bool `json:"preserve_method"`
+}
+
+// Template returns the parsed template of the URL.  The template is only parsed the first time it
+// is used, which is when the service is validated.
+func (r *Redirect) Template() (*template.Template, error) {
+	if t, ok := redirectTemplates.Load(r.URL); ok {
+		return t.(*template.Template), nil
+	}
+
+	t, err := template.New("redirect").Parse(r.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	redirectTemplates.Store(r.URL, t)
+	return t, nil
+}
+
+// StatusCode returns the HTTP status code to use for this redirect.
+func (r *Redirect) StatusCode() int {
+	if r.Permanent {
+		if r.PreserveMethod {
+			return http.StatusPermanentRedirect
+		}
+		return http.StatusMovedPermanently
+	}
+
+	if r.PreserveMethod {
+		return http.StatusTemporaryRedirect
+	}
+	return http.StatusFound
+}
+
+// StaticResponse describes the fixed response returned by a static service.
+// swagger:model
+type StaticResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body"`
+}
+
+func validateKind(service *Service) error {
+	switch service.Kind {
+	case "", ServiceKindProxy:
+		return nil
+
+	case ServiceKindRedirect:
+		if service.Redirect == nil || service.Redirect.URL == "" {
+			return fmt.Errorf("Redirect URL is required for service %q", service.Name)
+		}
+		if _, err := service.Redirect.Template(); err != nil {
+			return fmt.Errorf("Invalid redirect URL template %q: %v", service.Redirect.URL, err)
+		}
+
+	case ServiceKindStatic:
+		if service.Response == nil {
+			return fmt.Errorf("Response is required for service %q", service.Name)
+		}
+		if service.Response.Status != 0 && (service.Response.Status < 100 || service.Response.Status > 599) {
+			return fmt.Errorf("Invalid response status %d", service.Response.Status)
+		}
+
+	default:
+		return fmt.Errorf("Unknown service kind %q", service.Kind)
+	}
+
+	if len(service.Upstreams) > 0 {
+		return fmt.Errorf("Service %q of kind %q cannot have upstreams", service.Name, service.Kind)
+	}
+
+	return nil
+}
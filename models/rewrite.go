@@ -0,0 +1,160 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// RewriteRegex replaces every match of Pattern with Replacement, which can reference
+	// capture groups as $1 or ${name}.
+	RewriteRegex = "regex"
+
+	// RewritePrefix adds Replacement, which starts with /, to the start of the path.
+	RewritePrefix = "prefix"
+
+	// RewriteTemplate matches the start of the path against a template such as /users/{id} in
+	// Pattern, and replaces it with a template such as /v2/accounts/{id} in Replacement, which
+	// starts with /.  The rest of the path is kept.
+	RewriteTemplate = "template"
+)
+
+var templateSegment = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// templates caches the compiled rewrite templates by pattern, like regexes.
+var templates sync.Map
+
+// Rewrite is a single rule that changes the path forwarded to an upstream.  Rules are applied in
+// order, after the service path has been removed (or kept, see Upstream.IncludeServicePath).
+// swagger:model
+type Rewrite struct {
+	Type        string `json:"type"`
+	Pattern     string `json:"pattern,omitempty"`
+	Replacement string `json:"replacement"`
+}
+
+// Apply returns the path after this rule has been applied.  Paths that do not match the rule are
+// returned unchanged.
+func (r *Rewrite) Apply(path string) (string, error) {
+	switch r.Type {
+	case RewriteRegex:
+		re, err := compileRegex(r.Pattern)
+		if err != nil {
+			return "", err
+		}
+		return re.ReplaceAllString(path, r.Replacement), nil
+
+	case RewritePrefix:
+		return r.Replacement + path, nil
+
+	case RewriteTemplate:
+		re, err := compileRewriteTemplate(r.Pattern)
+		if err != nil {
+			return "", err
+		}
+
+		match := re.FindStringSubmatch(path)
+		if match == nil {
+			return path, nil
+		}
+
+		// Only match whole segments, so /users/{id} does not match /users/1abc as /users/1
+		rest := path[len(match[0]):]
+		if rest != "" && !strings.HasPrefix(rest, "/") && !strings.HasSuffix(match[0], "/") {
+			return path, nil
+		}
+
+		values := make(map[string]string)
+		for i, name := range re.SubexpNames() {
+			if name != "" {
+				values[name] = match[i]
+			}
+		}
+
+		replaced := templateSegment.ReplaceAllStringFunc(r.Replacement, func(segment string) string {
+			return values[strings.Trim(segment, "{}")]
+		})
+
+		return replaced + rest, nil
+	}
+
+	return "", fmt.Errorf("Unknown rewrite type %q", r.Type)
+}
+
+// compileRewriteTemplate converts a template such as /users/{id} into a regular expression that
+// matches the start of a path, with a named group for each segment.  The template is only
+// compiled the first time it is used.
+func compileRewriteTemplate(pattern string) (*regexp.Regexp, error) {
+	if re, ok := templates.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	expr := "^"
+
+	last := 0
+	for _, loc := range templateSegment.FindAllStringSubmatchIndex(pattern, -1) {
+		expr += regexp.QuoteMeta(pattern[last:loc[0]])
+		expr += fmt.Sprintf("(?P<%s>[^/]+)", pattern[loc[2]:loc[3]])
+		last = loc[1]
+	}
+	expr += regexp.QuoteMeta(pattern[last:])
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	templates.Store(pattern, re)
+	return re, nil
+}
+
+func validateRewrites(rewrites []*Rewrite) error {
+	for _, r := range rewrites {
+		if r == nil {
+			return fmt.Errorf("Rewrite cannot be empty")
+		}
+
+		switch r.Type {
+		case RewriteRegex:
+			if _, err := compileRegex(r.Pattern); err != nil {
+				return fmt.Errorf("Invalid rewrite regex %q: %v", r.Pattern, err)
+			}
+
+		case RewritePrefix:
+			if r.Replacement == "" {
+				return fmt.Errorf("Rewrite prefix is required")
+			}
+			if !strings.HasPrefix(r.Replacement, "/") {
+				return fmt.Errorf("Rewrite prefix %q must start with /", r.Replacement)
+			}
+
+		case RewriteTemplate:
+			// The replacement is the start of the path, and is added after the host of the upstream
+			if !strings.HasPrefix(r.Replacement, "/") {
+				return fmt.Errorf("Rewrite template replacement %q must start with /", r.Replacement)
+			}
+
+			re, err := compileRewriteTemplate(r.Pattern)
+			if err != nil {
+				return fmt.Errorf("Invalid rewrite template %q: %v", r.Pattern, err)
+			}
+
+			names := make(map[string]bool)
+			for _, name := range re.SubexpNames() {
+				names[name] = true
+			}
+			for _, segment := range templateSegment.FindAllStringSubmatch(r.Replacement, -1) {
+				if !names[segment[1]] {
+					return fmt.Errorf("Rewrite template %q does not define {%s}", r.Pattern, segment[1])
+				}
+			}
+
+		default:
+			return fmt.Errorf("Unknown rewrite type %q", r.Type)
+		}
+	}
+
+	return nil
+}
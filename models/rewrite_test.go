@@ -0,0 +1,54 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteApply(t *testing.T) {
+	tests := []struct {
+		rewrite  Rewrite
+		path     string
+		expected string
+	}{
+		{Rewrite{Type: RewriteRegex, Pattern: "^/api/v1", Replacement: "/api/v2"}, "/api/v1/users", "/api/v2/users"},
+		{Rewrite{Type: RewriteRegex, Pattern: "^/(\\w+)/(\\d+)$", Replacement: "/$1?id=$2"}, "/users/5", "/users?id=5"},
+		{Rewrite{Type: RewriteRegex, Pattern: "^/nomatch", Replacement: "/x"}, "/users", "/users"},
+		{Rewrite{Type: RewritePrefix, Replacement: "/legacy"}, "/users", "/legacy/users"},
+		{Rewrite{Type: RewritePrefix, Replacement: "/legacy"}, "", "/legacy"},
+		{Rewrite{Type: RewriteTemplate, Pattern: "/users/{id}", Replacement: "/v2/accounts/{id}"}, "/users/5", "/v2/accounts/5"},
+		{Rewrite{Type: RewriteTemplate, Pattern: "/users/{id}", Replacement: "/v2/accounts/{id}"}, "/users/5/profile", "/v2/accounts/5/profile"},
+		{Rewrite{Type: RewriteTemplate, Pattern: "/users/{id}/orders/{order}", Replacement: "/orders/{order}?user={id}"}, "/users/5/orders/7", "/orders/7?user=5"},
+		{Rewrite{Type: RewriteTemplate, Pattern: "/users", Replacement: "/accounts"}, "/usersettings", "/usersettings"},
+		{Rewrite{Type: RewriteTemplate, Pattern: "/users/{id}", Replacement: "/v2/accounts/{id}"}, "/groups/5", "/groups/5"},
+	}
+
+	for _, test := range tests {
+		actual, err := test.rewrite.Apply(test.path)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, actual, "%s %q applied to %q", test.rewrite.Type, test.rewrite.Pattern, test.path)
+	}
+}
+
+func TestValidateRewrites(t *testing.T) {
+	assert.NoError(t, validateRewrites(nil))
+	assert.NoError(t, validateRewrites([]*Rewrite{
+		&Rewrite{Type: RewriteRegex, Pattern: "^/a", Replacement: "/b"},
+		&Rewrite{Type: RewritePrefix, Replacement: "/b"},
+		&Rewrite{Type: RewriteTemplate, Pattern: "/a/{id}", Replacement: "/b/{id}"},
+	}))
+
+	assert.Error(t, validateRewrites([]*Rewrite{&Rewrite{Type: "unknown"}}))
+	assert.Error(t, validateRewrites([]*Rewrite{&Rewrite{Type: RewriteRegex, Pattern: "("}}))
+	assert.Error(t, validateRewrites([]*Rewrite{&Rewrite{Type: RewritePrefix}}))
+	assert.Error(t, validateRewrites([]*Rewrite{&Rewrite{Type: RewritePrefix, Replacement: "v2"}}))
+	assert.Error(t, validateRewrites([]*Rewrite{&Rewrite{Type: RewriteTemplate, Pattern: "/a/{id}", Replacement: "b/{id}"}}))
+	assert.Error(t, validateRewrites([]*Rewrite{&Rewrite{Type: RewriteTemplate, Pattern: "/a/{id}", Replacement: "/b/{name}"}}))
+
+	_, cached := regexes.Load("^/a")
+	assert.True(t, cached, "validated regexes should be compiled once and kept")
+	_, cached = templates.Load("/a/{id}")
+	assert.True(t, cached, "validated templates should be compiled once and kept")
+}
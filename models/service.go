@@ -7,10 +7,21 @@ import (
 	"time"
 
 	"github.com/premkit/premkit/log"
-	"github.com/premkit/premkit/persistence"
 	"github.com/premkit/premkit/utils"
+)
+
+const (
+	// SourceAPI is the source of services registered through the API.
+	SourceAPI = "api"
+
+	// SourceFile is the source of services loaded from the services file.
+	SourceFile = "file"
+
+	// SourceDocker is the source of services discovered from docker container labels.
+	SourceDocker = "docker"
 
-	"github.com/boltdb/bolt"
+	// SourceKubernetes is the source of services discovered from annotated Kubernetes Services.
+	SourceKubernetes = "kubernetes"
 )
 
 // Service represents a single registered service with this reverse proxy.
@@ -20,59 +31,152 @@ type Service struct {
 	Path      string      `json:"path"`
 	Upstreams []*Upstream `json:"upstreams"`
 
+	// Priority orders services when more than one could route a request.  Services with a
+	// higher priority are evaluated first.
+	Priority int      `json:"priority"`
+	Match    *Match   `json:"match,omitempty"`
+	Headers  *Headers `json:"headers,omitempty"`
+
+	// Rewrites are applied, in order, to the path forwarded to the upstream.
+	Rewrites []*Rewrite `json:"rewrites,omitempty"`
+
+	// Kind is one of ServiceKindProxy (the default), ServiceKindRedirect or ServiceKindStatic.
+	// Redirect and static services answer requests themselves, using Redirect or Response.
+	Kind     string          `json:"kind,omitempty"`
+	Redirect *Redirect       `json:"redirect,omitempty"`
+	Response *StaticResponse `json:"response,omitempty"`
+
+	// TTL is the lease duration, in seconds, of this service.  When set, the service and its
+	// upstreams are removed if the lease is not renewed (see RenewLease) before it Expires.
+	TTL     int        `json:"ttl,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+
+	// Source records what registered the service.  Services from any source other than
+	// SourceAPI are managed by that source, and cannot be changed through the API.
+	Source string `json:"source,omitempty"`
+
+	// Registered is when the service was created, and Updated when it was last changed.
 	Registered time.Time `json:"registered"`
+	Updated    time.Time `json:"updated"`
 }
 
 // ListServices returns a list of all available, known services.
 // TODO this should cache and not always hit the disk.
-func ListServices() ([]*Service, error) {
+func (r *Registry) ListServices() ([]*Service, error) {
 	services := make([]*Service, 0, 0)
 
-	db, err := persistence.GetDB()
+	err := r.view(func(tx Tx) error {
+		names, err := tx.ServiceNames()
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			service, err := tx.GetService(name)
+			if err != nil {
+				return err
+			}
+			services = append(services, service)
+		}
+
+		return nil
+	})
+
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.View(func(tx *bolt.Tx) error {
-		tx.ForEach(func(bucketName []byte, b *bolt.Bucket) error {
-			if !strings.HasPrefix(string(bucketName), "service:") {
-				return nil
-			}
-
-			s := strings.Split(string(bucketName), ":")
-			if len(s) < 2 {
-				err = fmt.Errorf("Unexpected bucket name: %q", bucketName)
-				log.Error(err)
-				return nil
-			}
+	return services, nil
+}
 
-			serviceName := strings.Join(s[1:], ":")
+// RegistrySize returns the number of services, and of their upstreams.
+func (r *Registry) RegistrySize() (int, int, error) {
+	services, upstreams := 0, 0
+	err := r.view(func(tx Tx) error {
+		names, err := tx.ServiceNames()
+		if err != nil {
+			return err
+		}
 
-			service, err := getServiceByName([]byte(serviceName))
+		for _, name := range names {
+			service, err := tx.GetService(name)
 			if err != nil {
 				return err
 			}
-
-			services = append(services, service)
-
-			return nil
-		})
+			if service == nil {
+				continue
+			}
+			services++
+			upstreams += len(service.Upstreams)
+		}
 
 		return nil
 	})
 
 	if err != nil {
-		return nil, err
+		return 0, 0, err
 	}
 
-	return services, nil
+	return services, upstreams, nil
 }
 
+// ErrManagedService is returned when a change through the API would change a service that is
+// managed by another source.
+var ErrManagedService = errors.New("Service is managed by another source and cannot be changed through the API")
+
+// ErrNotProxy is returned when an upstream is added to a service that does not forward requests
+// to upstreams.
+var ErrNotProxy = errors.New("Service does not forward to upstreams")
+
+// ErrOtherSource is returned when a source would replace a service that was registered by
+// another source.
+var ErrOtherSource = errors.New("Service was registered by another source")
+
 // CreateService will create a new (or update an existing) service.  If the service already
 // exists, this call will update it with the new name, and append it's own upstream.
 // This could be problematic if two different services register with the same path.  The router
 // would send traffic randomly to each.
-func CreateService(service *Service) (*Service, error) {
+func (r *Registry) CreateService(service *Service) (*Service, error) {
+	return r.writeService(service, false, nil, "")
+}
+
+// ReplaceService creates the service, replacing any existing service with the same name.
+// Returns ErrOtherSource if the existing service was registered by another source.
+func (r *Registry) ReplaceService(service *Service) (*Service, error) {
+	return r.writeService(service, true, refuseOtherSource, "")
+}
+
+// RegisterService creates or updates a service registered through the API by actor, or replaces
+// it when replace is set.  Returns ErrManagedService if the existing service is managed by
+// another source.
+func (r *Registry) RegisterService(service *Service, replace bool, actor string) (*Service, error) {
+	return r.writeService(service, replace, refuseManaged, actor)
+}
+
+// refuseManaged refuses changes through the API to a service managed by another source.
+func refuseManaged(current *Service, service *Service) error {
+	if current.Managed() {
+		log.Errorf("Refusing to change service %q managed by %q", current.Name, current.Source)
+		return ErrManagedService
+	}
+
+	return nil
+}
+
+// refuseOtherSource refuses changes to a service registered by another source.
+func refuseOtherSource(current *Service, service *Service) error {
+	if current.owner() != service.owner() {
+		log.Errorf("Refusing to replace service %q registered by %q with one from %q", current.Name, current.owner(), service.owner())
+		return ErrOtherSource
+	}
+
+	return nil
+}
+
+// writeService reads the current service and writes the new one, with a revision of the change,
+// in a single transaction, so concurrent requests never see the service missing while it is
+// replaced.  refuse, if set, can refuse to change the existing service.
+func (r *Registry) writeService(service *Service, replace bool, refuse func(current *Service, service *Service) error, actor string) (*Service, error) {
 	log.Debugf("Creating service %q (path: %q)", service.Name, service.Path)
 
 	if err := validateService(service); err != nil {
@@ -82,137 +186,118 @@ func CreateService(service *Service) (*Service, error) {
 	// Clean the service a little
 	service.Path = strings.TrimPrefix(service.Path, "/")
 
-	// If the service already exists, we just want to update it with a new upstream
-	current, err := maybeGetServiceByName([]byte(service.Name))
-	if err != nil {
-		return nil, err
-	}
+	startLeases(service, time.Now())
 
-	if current == nil {
-		return createNewService(service)
-	}
+	err := r.update(func(tx Tx) error {
+		// If the service already exists, we just want to update it with a new upstream
+		current, err := tx.GetService(service.Name)
+		if err != nil {
+			return err
+		}
 
-	return updateService(current, service)
-}
+		if current != nil && refuse != nil {
+			if err := refuse(current, service); err != nil {
+				return err
+			}
+		}
+
+		if current == nil || replace {
+			err = createNewService(tx, service)
+		} else {
+			err = updateService(tx, current, service)
+		}
+		if err != nil {
+			return err
+		}
+
+		return recordRevision(tx, service.Name, current, service, service.Source, actor)
+	})
 
-func updateService(current *Service, service *Service) (*Service, error) {
-	db, err := persistence.GetDB()
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		serviceBucket := tx.Bucket([]byte(fmt.Sprintf("service:%s", service.Name)))
+	return service, nil
+}
 
-		// Update the path
-		if err := serviceBucket.Put([]byte("path"), []byte(service.Path)); err != nil {
-			log.Error(err)
-			return err
-		}
+func updateService(tx Tx, current *Service, service *Service) error {
+	service.Registered = current.Registered
+	service.Updated = time.Now()
 
-		// TODO update the registration date
+	// On update, we merge these upstreams into the current upstreams
+	upstreamURLs := make([]string, 0, 0)
+	for _, u := range current.Upstreams {
+		upstreamURLs = append(upstreamURLs, u.URL)
+	}
+	for _, u := range service.Upstreams {
+		upstreamURLs = append(upstreamURLs, u.URL)
+	}
+	upstreamURLs = utils.RemoveDuplicates(upstreamURLs)
 
-		// On update, we merge these upstreams into the current upstreams
-		upstreamURLs := make([]string, 0, 0)
-		for _, u := range current.Upstreams {
-			upstreamURLs = append(upstreamURLs, u.URL)
-		}
-		for _, u := range service.Upstreams {
-			upstreamURLs = append(upstreamURLs, u.URL)
+	combinedUpstreams := make([]*Upstream, 0, 0)
+	for _, url := range upstreamURLs {
+		// Check for this upstream in the new service first
+		added := false
+		for _, upstream := range service.Upstreams {
+			if upstream.URL == url {
+				combinedUpstreams = append(combinedUpstreams, upstream)
+				added = true
+				break
+			}
 		}
-		upstreamURLs = utils.RemoveDuplicates(upstreamURLs)
 
-		combinedUpstreams := make([]*Upstream, 0, 0)
-		for _, url := range upstreamURLs {
-			// Check for this upstream in the new service first
-			added := false
-			for _, upstream := range service.Upstreams {
+		if !added {
+			for _, upstream := range current.Upstreams {
 				if upstream.URL == url {
 					combinedUpstreams = append(combinedUpstreams, upstream)
 					added = true
 					break
 				}
 			}
-
-			if !added {
-				for _, upstream := range current.Upstreams {
-					if upstream.URL == url {
-						combinedUpstreams = append(combinedUpstreams, upstream)
-						added = true
-						break
-					}
-				}
-			}
-		}
-
-		service.Upstreams = combinedUpstreams
-
-		for _, upstream := range combinedUpstreams {
-			if err := SaveUpstream(upstream, tx); err != nil {
-				return err
-			}
-
-			// And save the reference
-			if err := serviceBucket.Put([]byte(fmt.Sprintf("upstream:%s", upstream.URL)), []byte(upstream.URL)); err != nil {
-				log.Error(err)
-				return err
-			}
 		}
+	}
 
-		return nil
-	})
+	service.Upstreams = combinedUpstreams
 
-	if err != nil {
-		return nil, err
+	// The upstreams of the current service may not be allowed in the new one, such as when it
+	// changes to a redirect
+	if err := validateService(service); err != nil {
+		return err
 	}
 
-	return service, nil
+	return tx.PutService(service)
 }
 
-func createNewService(service *Service) (*Service, error) {
+func createNewService(tx Tx, service *Service) error {
 	// Create a new service
 	service.Registered = time.Now()
+	service.Updated = service.Registered
 
-	db, err := persistence.GetDB()
-	if err != nil {
+	return tx.PutService(service)
+}
+
+// touchService sets when the service was updated, after its upstreams were changed, and returns
+// the updated service.
+func touchService(tx Tx, name string) (*Service, error) {
+	service, err := tx.GetService(name)
+	if err != nil || service == nil {
 		return nil, err
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		serviceBucket, err := tx.CreateBucket([]byte(fmt.Sprintf("service:%s", service.Name)))
-		if err != nil {
-			log.Error(err)
-			return err
-		}
-
-		// Write the path
-		if err := serviceBucket.Put([]byte("path"), []byte(service.Path)); err != nil {
-			log.Error(err)
-			return err
-		}
-
-		// TODO write the registration date
-
-		// Write the upstreams
-		for _, upstream := range service.Upstreams {
-			// upstream are stored in the service bucket, but these are
-			// just references to the upstream buckets themselves.  the details
-			// of an upstream must be read from the upstream bucket.
-			log.Debugf("Saving upstream with URL %q", upstream.URL)
-			if err := SaveUpstream(upstream, tx); err != nil {
-				return err
-			}
-
-			// And save the reference
-			if err := serviceBucket.Put([]byte(fmt.Sprintf("upstream:%s", upstream.URL)), []byte(upstream.URL)); err != nil {
-				log.Error(err)
-				return err
-			}
+	service.Updated = time.Now()
+	if err := tx.PutService(service); err != nil {
+		return nil, err
+	}
 
-			log.Debugf("Saved upstream with URL %q", upstream.URL)
-		}
+	return service, nil
+}
 
-		return nil
+func (r *Registry) maybeGetServiceByName(name []byte) (*Service, error) {
+	var service *Service
+	err := r.view(func(tx Tx) error {
+		s, err := tx.GetService(string(name))
+		service = s
+		return err
 	})
 
 	if err != nil {
@@ -222,115 +307,186 @@ func createNewService(service *Service) (*Service, error) {
 	return service, nil
 }
 
-func maybeGetServiceByName(name []byte) (*Service, error) {
-	log.Debugf("Attempting to load a service named %q", name)
-	db, err := persistence.GetDB()
+// GetServiceByName returns the service with the name, or nil if there is no such service.
+func (r *Registry) GetServiceByName(name []byte) (*Service, error) {
+	return r.maybeGetServiceByName(name)
+}
+
+func (r *Registry) getServiceByName(name []byte) (*Service, error) {
+	service, err := r.maybeGetServiceByName(name)
 	if err != nil {
 		return nil, err
 	}
 
-	service := Service{
-		Name: string(name),
+	if service == nil {
+		return nil, errors.New("Service not found")
 	}
 
-	ok := false
-	err = db.View(func(tx *bolt.Tx) error {
-		serviceBucket := tx.Bucket([]byte(fmt.Sprintf("service:%s", name)))
-		if serviceBucket == nil {
-			return nil
-		}
+	return service, nil
+}
 
-		ok = true
-		service.Path = string(serviceBucket.Get([]byte("path")))
+// Managed returns true if the service is managed by a source other than the API.
+func (s *Service) Managed() bool {
+	return s.Source != "" && s.Source != SourceAPI
+}
 
-		service.Upstreams = make([]*Upstream, 0, 0)
-		err := serviceBucket.ForEach(func(k, v []byte) error {
-			log.Debugf("key: %q", k)
-			if strings.HasPrefix(string(k), "upstream:") {
-				upstream, err := maybeGetUpstreamByURL(v)
-				if err != nil {
-					return err
-				}
+// owner returns the source that registered the service.  Services without a source were
+// registered through the API.
+func (s *Service) owner() string {
+	if s.Source == "" {
+		return SourceAPI
+	}
+	return s.Source
+}
 
-				if upstream != nil {
-					service.Upstreams = append(service.Upstreams, upstream)
-				}
-			}
-			if err != nil {
-				log.Error(err)
-				return err
-			}
+// Expired returns true if the service has a lease that expired before now.
+func (s *Service) Expired(now time.Time) bool {
+	return s.Expires != nil && now.After(*s.Expires)
+}
 
-			return nil
-		})
-		if err != nil {
-			return err
-		}
+// ValidationError is returned when a service or upstream is invalid, such as when a predicate
+// regex does not compile.
+type ValidationError struct {
+	Err error
+}
 
-		return nil
-	})
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
 
-	if err != nil {
-		return nil, err
-	}
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
 
-	if !ok {
-		return nil, nil
+// validateService returns a *ValidationError if the service is invalid.
+func validateService(service *Service) error {
+	if err := checkService(service); err != nil {
+		log.Error(err)
+		return &ValidationError{Err: err}
 	}
 
-	log.Debugf("maybeGetService found a service for name %q", string(name))
-	return &service, nil
+	return nil
 }
 
-func getServiceByName(name []byte) (*Service, error) {
-	service, err := maybeGetServiceByName(name)
-	if err != nil {
-		return nil, err
+func checkService(service *Service) error {
+	// TODO validate the rest of the service.
+	if err := validateMatch(service.Match); err != nil {
+		return err
 	}
 
-	if service == nil {
-		return nil, errors.New("Service not found")
+	if err := validateHeaders(service.Headers); err != nil {
+		return err
 	}
 
-	return service, nil
-}
+	if err := validateRewrites(service.Rewrites); err != nil {
+		return err
+	}
+
+	if err := validateKind(service); err != nil {
+		return err
+	}
+
+	if service.TTL < 0 {
+		return fmt.Errorf("Invalid ttl %d for service %q", service.TTL, service.Name)
+	}
+	for _, upstream := range service.Upstreams {
+		if err := validateUpstream(upstream); err != nil {
+			return err
+		}
+	}
 
-func validateService(service *Service) error {
-	// TODO this.
 	return nil
 }
 
-// DeleteServiceByName will find any service matching the name, and delete it,
-// including any upstreams that are not referenced by other services.
-func DeleteServiceByName(name []byte) (bool, error) {
-	service, err := maybeGetServiceByName(name)
+// DeleteServiceByName will find any service matching the name, and delete it with its upstreams.
+func (r *Registry) DeleteServiceByName(name []byte) (bool, error) {
+	deleted := false
+	err := r.update(func(tx Tx) error {
+		current, err := tx.GetService(string(name))
+		if err != nil || current == nil {
+			return err
+		}
+
+		deleted, err = tx.DeleteService(string(name))
+		if err != nil {
+			return err
+		}
+
+		return recordRevision(tx, current.Name, current, nil, current.Source, "")
+	})
+
 	if err != nil {
 		return false, err
 	}
 
-	if service == nil {
-		// There was no matching service. No reason to error, just return.
-		return false, nil
-	}
+	return deleted, nil
+}
+
+// DeleteServiceFromSource deletes the service only if it is still managed by source.  Returns
+// false if there is no such service.
+func (r *Registry) DeleteServiceFromSource(name []byte, source string) (bool, error) {
+	deleted := false
+	err := r.update(func(tx Tx) error {
+		current, err := tx.GetService(string(name))
+		if err != nil || current == nil || current.Source != source {
+			return err
+		}
+
+		deleted, err = tx.DeleteService(string(name))
+		if err != nil {
+			return err
+		}
+
+		return recordRevision(tx, current.Name, current, nil, source, "")
+	})
 
-	// Delete the service
-	db, err := persistence.GetDB()
 	if err != nil {
 		return false, err
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket([]byte(fmt.Sprintf("service:%s", name)))
-		if err != nil {
-			log.Error(err)
+	return deleted, nil
+}
+
+// DeregisterService deletes a service registered through the API by actor, or only its upstream
+// when upstreamURL is set.  Returns false if the service or upstream is not found, and
+// ErrManagedService if the service is managed by another source.
+func (r *Registry) DeregisterService(name []byte, upstreamURL []byte, actor string) (bool, error) {
+	deleted := false
+	err := r.update(func(tx Tx) error {
+		current, err := tx.GetService(string(name))
+		if err != nil || current == nil {
 			return err
 		}
 
-		// TODO delete the upstreams that we orphaned
+		if current.Managed() {
+			log.Errorf("Refusing to deregister service %q managed by %q", current.Name, current.Source)
+			return ErrManagedService
+		}
 
-		return nil
+		if len(upstreamURL) > 0 {
+			deleted, err = tx.DeleteUpstream(string(name), string(upstreamURL))
+			if err != nil || !deleted {
+				return err
+			}
+
+			after, err := touchService(tx, string(name))
+			if err != nil {
+				return err
+			}
+			return recordRevision(tx, current.Name, current, after, SourceAPI, actor)
+		}
+
+		deleted, err = tx.DeleteService(string(name))
+		if err != nil {
+			return err
+		}
+
+		return recordRevision(tx, current.Name, current, nil, SourceAPI, actor)
 	})
 
-	return true, nil
+	if err != nil {
+		return false, err
+	}
 
+	return deleted, nil
 }
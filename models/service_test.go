@@ -1,48 +1,34 @@
 package models
 
 import (
-	"io/ioutil"
-	"os"
 	"path"
 	"testing"
 
-	"github.com/premkit/premkit/persistence"
-
 	"github.com/boltdb/bolt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func setup(t *testing.T) string {
-	dirName, err := ioutil.TempDir("", "premkit-test")
-	require.NoError(t, err)
-
-	conn, err := bolt.Open(path.Join(dirName, "test.db"), 0600, nil)
+func setup(t *testing.T) *Registry {
+	conn, err := bolt.Open(path.Join(t.TempDir(), "test.db"), 0600, nil)
 	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
 
-	persistence.DB = conn
-
-	return dirName
-}
-
-func teardown(dbPath string) {
-	os.RemoveAll(dbPath)
+	return NewRegistry(NewBoltStore(conn, nil))
 }
 
 func TestListServicesEmpty(t *testing.T) {
-	dbPath := setup(t)
-	defer teardown(dbPath)
+	registry := setup(t)
 
-	services, err := ListServices()
+	services, err := registry.ListServices()
 	require.NoError(t, err)
 	assert.Equal(t, 0, len(services), "there should be no services")
 }
 
 func TestCreateService(t *testing.T) {
-	dbPath := setup(t)
-	defer teardown(dbPath)
+	registry := setup(t)
 
-	service, err := CreateService(&Service{
+	service, err := registry.CreateService(&Service{
 		Name: "name",
 		Path: "path_a",
 		Upstreams: []*Upstream{
@@ -56,16 +42,15 @@ func TestCreateService(t *testing.T) {
 	assert.Equal(t, 1, len(service.Upstreams), "there should be 1 upstream")
 	assert.Equal(t, "a", service.Upstreams[0].URL, "upstream service[0].url should be 'a'")
 
-	services, err := ListServices()
+	services, err := registry.ListServices()
 	require.NoError(t, err)
 	assert.Equal(t, 1, len(services), "there should be 1 service")
 }
 
 func TestUpdateService(t *testing.T) {
-	dbPath := setup(t)
-	defer teardown(dbPath)
+	registry := setup(t)
 
-	service, err := CreateService(&Service{
+	service, err := registry.CreateService(&Service{
 		Name: "name_2",
 		Path: "path_2",
 		Upstreams: []*Upstream{
@@ -74,7 +59,7 @@ func TestUpdateService(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	service, err = CreateService(&Service{
+	service, err = registry.CreateService(&Service{
 		Name: "name_2",
 		Path: "path_2",
 		Upstreams: []*Upstream{
@@ -89,7 +74,7 @@ func TestUpdateService(t *testing.T) {
 	assert.Equal(t, "1", service.Upstreams[0].URL, "upstream service[0] should be '1'")
 	assert.Equal(t, "2", service.Upstreams[1].URL, "upstream service[1] should be '2'")
 
-	services, err := ListServices()
+	services, err := registry.ListServices()
 	require.NoError(t, err)
 	assert.Equal(t, 1, len(services), "there should be 1 service1")
 
@@ -106,14 +91,13 @@ func TestUpdateService(t *testing.T) {
 }
 
 func TestDeleteServiceByName(t *testing.T) {
-	dbPath := setup(t)
-	defer teardown(dbPath)
+	registry := setup(t)
 
-	deleted, err := DeleteServiceByName([]byte("test"))
+	deleted, err := registry.DeleteServiceByName([]byte("test"))
 	require.NoError(t, err)
 	assert.Equal(t, false, deleted)
 
-	_, err = CreateService(&Service{
+	_, err = registry.CreateService(&Service{
 		Name: "test",
 		Path: "path",
 		Upstreams: []*Upstream{
@@ -122,7 +106,264 @@ func TestDeleteServiceByName(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	deleted, err = DeleteServiceByName([]byte("test"))
+	deleted, err = registry.DeleteServiceByName([]byte("test"))
 	require.NoError(t, err)
 	assert.Equal(t, true, deleted)
 }
+
+func TestServicesWithTheSameUpstream(t *testing.T) {
+	registry := setup(t)
+
+	for _, name := range []string{"a", "b"} {
+		_, err := registry.CreateService(&Service{
+			Name: name,
+			Path: name,
+			Upstreams: []*Upstream{
+				&Upstream{URL: "http://shared"},
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	// Changing the upstream of one service does not change the other
+	_, err := registry.AddUpstream([]byte("a"), &Upstream{URL: "http://shared", IncludeServicePath: true}, "")
+	require.NoError(t, err)
+
+	b, err := registry.getServiceByName([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(b.Upstreams))
+	assert.False(t, b.Upstreams[0].IncludeServicePath)
+
+	// Deleting one service leaves the upstream of the other
+	deleted, err := registry.DeleteServiceByName([]byte("a"))
+	require.NoError(t, err)
+	assert.True(t, deleted)
+
+	b, err = registry.getServiceByName([]byte("b"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(b.Upstreams))
+
+	upstream, err := registry.maybeGetUpstream([]byte("a"), []byte("http://shared"))
+	require.NoError(t, err)
+	assert.Nil(t, upstream)
+}
+
+func TestCreateServiceWithMatch(t *testing.T) {
+	registry := setup(t)
+
+	_, err := registry.CreateService(&Service{
+		Name:     "grpc",
+		Path:     "api",
+		Priority: 10,
+		Match: &Match{
+			Headers: []*Predicate{
+				&Predicate{Name: "Accept", Regex: "^application/grpc-web"},
+			},
+		},
+		Upstreams: []*Upstream{
+			&Upstream{URL: "a"},
+		},
+	})
+	require.NoError(t, err)
+
+	service, err := registry.getServiceByName([]byte("grpc"))
+	require.NoError(t, err)
+	assert.Equal(t, 10, service.Priority)
+	require.NotNil(t, service.Match)
+	require.Equal(t, 1, len(service.Match.Headers))
+	assert.Equal(t, "Accept", service.Match.Headers[0].Name)
+
+	_, err = registry.CreateService(&Service{
+		Name:  "invalid",
+		Path:  "api",
+		Match: &Match{Headers: []*Predicate{&Predicate{Name: "Accept", Regex: "("}}},
+	})
+	assert.Error(t, err)
+}
+
+func TestCreateServiceWithHeaders(t *testing.T) {
+	registry := setup(t)
+
+	_, err := registry.CreateService(&Service{
+		Name: "headers",
+		Path: "headers",
+		Headers: &Headers{
+			Request: &HeaderRules{
+				Set: map[string]string{"X-Real-Ip": "{{.ClientIP}}"},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	service, err := registry.getServiceByName([]byte("headers"))
+	require.NoError(t, err)
+	require.NotNil(t, service.Headers)
+	assert.Nil(t, service.Headers.Response)
+	assert.Equal(t, "{{.ClientIP}}", service.Headers.Request.Set["X-Real-Ip"])
+
+	_, err = registry.CreateService(&Service{
+		Name: "invalid",
+		Path: "headers",
+		Headers: &Headers{
+			Response: &HeaderRules{Add: map[string]string{"X-Broken": "{{.ClientIP"}},
+		},
+	})
+	assert.Error(t, err)
+}
+
+func TestCreateServiceKinds(t *testing.T) {
+	registry := setup(t)
+
+	_, err := registry.CreateService(&Service{
+		Name: "maintenance",
+		Path: "/",
+		Kind: ServiceKindStatic,
+		Response: &StaticResponse{
+			Status: 503,
+			Body:   "down for maintenance",
+		},
+	})
+	require.NoError(t, err)
+
+	service, err := registry.getServiceByName([]byte("maintenance"))
+	require.NoError(t, err)
+	assert.Equal(t, ServiceKindStatic, service.Kind)
+	require.NotNil(t, service.Response)
+	assert.Equal(t, 503, service.Response.Status)
+	assert.Nil(t, service.Redirect)
+
+	_, err = registry.CreateService(&Service{Name: "invalid", Kind: ServiceKindRedirect})
+	assert.Error(t, err)
+
+	_, err = registry.CreateService(&Service{
+		Name:      "invalid",
+		Kind:      ServiceKindStatic,
+		Response:  &StaticResponse{},
+		Upstreams: []*Upstream{&Upstream{URL: "a"}},
+	})
+	assert.Error(t, err)
+
+	_, err = registry.CreateService(&Service{Name: "invalid", Kind: "unknown"})
+	assert.Error(t, err)
+
+	// Merging with the upstreams of the current service must still be valid
+	_, err = registry.CreateService(&Service{Name: "proxy", Path: "proxy", Upstreams: []*Upstream{&Upstream{URL: "a"}}})
+	require.NoError(t, err)
+	_, err = registry.CreateService(&Service{Name: "proxy", Path: "proxy", Kind: ServiceKindStatic, Response: &StaticResponse{}})
+	assert.Error(t, err)
+
+	service, err = registry.getServiceByName([]byte("proxy"))
+	require.NoError(t, err)
+	assert.Equal(t, "", service.Kind)
+	assert.Equal(t, 1, len(service.Upstreams))
+
+	_, err = registry.AddUpstream([]byte("maintenance"), &Upstream{URL: "a"}, "")
+	assert.Equal(t, ErrNotProxy, err)
+}
+
+func TestRedirectStatusCode(t *testing.T) {
+	assert.Equal(t, 302, (&Redirect{}).StatusCode())
+	assert.Equal(t, 301, (&Redirect{Permanent: true}).StatusCode())
+	assert.Equal(t, 307, (&Redirect{PreserveMethod: true}).StatusCode())
+	assert.Equal(t, 308, (&Redirect{Permanent: true, PreserveMethod: true}).StatusCode())
+}
+
+func TestRedirectTemplate(t *testing.T) {
+	redirect := &Redirect{URL: "https://example.com{{.Path}}"}
+
+	parsed, err := redirect.Template()
+	require.NoError(t, err)
+
+	again, err := (&Redirect{URL: redirect.URL}).Template()
+	require.NoError(t, err)
+	assert.Same(t, parsed, again)
+
+	_, err = (&Redirect{URL: "https://example.com{{.Path"}).Template()
+	assert.Error(t, err)
+}
+
+func TestCreateServiceWithResolvedUpstream(t *testing.T) {
+	registry := setup(t)
+
+	_, err := registry.CreateService(&Service{
+		Name: "resolved",
+		Path: "resolved",
+		Upstreams: []*Upstream{
+			&Upstream{URL: "http://_http._tcp.ui.local", Resolve: ResolveSRV, ResolveInterval: 10},
+		},
+	})
+	require.NoError(t, err)
+
+	service, err := registry.getServiceByName([]byte("resolved"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(service.Upstreams))
+	assert.Equal(t, ResolveSRV, service.Upstreams[0].Resolve)
+	assert.Equal(t, 10, service.Upstreams[0].ResolveInterval)
+
+	_, err = registry.CreateService(&Service{
+		Name:      "invalid",
+		Path:      "invalid",
+		Upstreams: []*Upstream{&Upstream{URL: "http://ui.local", Resolve: "mdns"}},
+	})
+	assert.Error(t, err)
+}
+
+func TestRegisterAndDeregisterManagedService(t *testing.T) {
+	registry := setup(t)
+
+	_, err := registry.CreateService(&Service{Name: "managed", Path: "managed", Source: SourceFile})
+	require.NoError(t, err)
+
+	_, err = registry.RegisterService(&Service{Name: "managed", Path: "other", Source: SourceAPI}, true, "")
+	assert.Equal(t, ErrManagedService, err)
+
+	_, err = registry.DeregisterService([]byte("managed"), nil, "")
+	assert.Equal(t, ErrManagedService, err)
+
+	_, err = registry.AddUpstream([]byte("managed"), &Upstream{URL: "a"}, "")
+	assert.Equal(t, ErrManagedService, err)
+
+	// Only the source that manages the service deletes it
+	deleted, err := registry.DeleteServiceFromSource([]byte("managed"), SourceDocker)
+	require.NoError(t, err)
+	assert.False(t, deleted)
+
+	deleted, err = registry.DeleteServiceFromSource([]byte("managed"), SourceFile)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+}
+
+func TestReplaceService(t *testing.T) {
+	registry := setup(t)
+
+	_, err := registry.RegisterService(&Service{
+		Name:      "test",
+		Path:      "test",
+		Upstreams: []*Upstream{&Upstream{URL: "a"}},
+	}, false, "")
+	require.NoError(t, err)
+
+	_, err = registry.ReplaceService(&Service{
+		Name:      "test",
+		Path:      "replaced",
+		Upstreams: []*Upstream{&Upstream{URL: "b"}},
+	})
+	require.NoError(t, err)
+
+	service, err := registry.getServiceByName([]byte("test"))
+	require.NoError(t, err)
+	assert.Equal(t, "replaced", service.Path)
+	require.Equal(t, 1, len(service.Upstreams))
+	assert.Equal(t, "b", service.Upstreams[0].URL)
+
+	found, err := registry.DeregisterService([]byte("test"), []byte("b"), "")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	found, err = registry.DeregisterService([]byte("test"), []byte("b"), "")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	_, err = registry.ReplaceService(&Service{Name: "test", Path: "managed", Source: SourceFile})
+	assert.Equal(t, ErrOtherSource, err)
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"github.com/premkit/premkit/events"
+)
+
+// Store persists the registry of services and upstreams.  Every read and write is made through a
+// Tx, so an operation that reads and then writes sees a consistent registry.
+type Store interface {
+	// View runs fn in a read-only transaction.
+	View(fn func(tx Tx) error) error
+
+	// Update runs fn in a read-write transaction, which is committed if fn returns nil and
+	// rolled back otherwise.
+	Update(fn func(tx Tx) error) error
+}
+
+// Tx reads and writes the registry within a transaction of a Store.  Upstreams belong to their
+// service: GetService returns them in URL order, PutService replaces them, and DeleteService
+// deletes them with the service.
+type Tx interface {
+	ServiceNames() ([]string, error)
+	GetService(name string) (*Service, error)
+	PutService(service *Service) error
+	DeleteService(name string) (bool, error)
+
+	// PutUpstream adds or replaces an upstream of an existing service.
+	PutUpstream(serviceName string, upstream *Upstream) error
+	DeleteUpstream(serviceName string, url string) (bool, error)
+
+	// AppendRevision adds the revision to the history of its service, numbering it.
+	AppendRevision(revision *Revision) error
+	Revisions(serviceName string) ([]*Revision, error)
+
+	// HistoryNames returns the names of the services with a history, including deleted services.
+	HistoryNames() ([]string, error)
+
+	// DeleteRevisions deletes the revisions of a service numbered up to and including number.
+	DeleteRevisions(serviceName string, number int) error
+}
+
+// Registry reads and changes the services and upstreams in a Store.  It is safe for concurrent
+// use, and every change made through it is published to its events bus once it is committed.
+type Registry struct {
+	store  Store
+	events *events.Bus
+}
+
+// NewRegistry returns a registry of the services in store, with a new events bus.
+func NewRegistry(store Store) *Registry {
+	return &Registry{store: store, events: events.NewBus()}
+}
+
+// Store returns the store of the registry.
+func (r *Registry) Store() Store {
+	return r.store
+}
+
+// Events returns the bus on which the changes to the registry are published.  Other changes to the
+// services, like the health of their endpoints, are published on it too.
+func (r *Registry) Events() *events.Bus {
+	return r.events
+}
+
+func (r *Registry) view(fn func(tx Tx) error) error {
+	return r.store.View(fn)
+}
+
+// update runs fn in a read-write transaction, and publishes the changes recorded by fn once
+// they are committed.
+func (r *Registry) update(fn func(tx Tx) error) error {
+	var changes []*Revision
+	err := r.store.Update(func(tx Tx) error {
+		recording := &revisionTx{Tx: tx}
+		if err := fn(recording); err != nil {
+			return err
+		}
+
+		changes = recording.revisions
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.publishChanges(changes)
+	return nil
+}
@@ -0,0 +1,263 @@
+package models
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStores runs the test against each store implementation.
+func testStores(t *testing.T, test func(t *testing.T, s Store)) {
+	t.Run("bolt", func(t *testing.T) {
+		t.Parallel()
+
+		dirName, err := ioutil.TempDir("", "premkit-test")
+		require.NoError(t, err)
+		defer os.RemoveAll(dirName)
+
+		db, err := bolt.Open(path.Join(dirName, "test.db"), 0600, nil)
+		require.NoError(t, err)
+		defer db.Close()
+
+		test(t, NewBoltStore(db, nil))
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		t.Parallel()
+		test(t, NewMemoryStore())
+	})
+}
+
+func TestStoreServices(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		expires := time.Now().Add(time.Minute).UTC()
+
+		err := s.Update(func(tx Tx) error {
+			return tx.PutService(&Service{
+				Name:     "test",
+				Path:     "test",
+				Priority: 2,
+				Match:    &Match{Methods: []string{"GET"}},
+				Expires:  &expires,
+				Source:   SourceFile,
+				Upstreams: []*Upstream{
+					&Upstream{URL: "http://b"},
+					&Upstream{URL: "http://a"},
+				},
+			})
+		})
+		require.NoError(t, err)
+
+		err = s.View(func(tx Tx) error {
+			names, err := tx.ServiceNames()
+			require.NoError(t, err)
+			assert.Equal(t, []string{"test"}, names)
+
+			service, err := tx.GetService("test")
+			require.NoError(t, err)
+			require.NotNil(t, service)
+			assert.Equal(t, 2, service.Priority)
+			assert.Equal(t, []string{"GET"}, service.Match.Methods)
+			assert.True(t, expires.Equal(*service.Expires))
+			assert.Equal(t, SourceFile, service.Source)
+
+			// Upstreams are in URL order
+			require.Equal(t, 2, len(service.Upstreams))
+			assert.Equal(t, "http://a", service.Upstreams[0].URL)
+			assert.Equal(t, "http://b", service.Upstreams[1].URL)
+
+			missing, err := tx.GetService("missing")
+			require.NoError(t, err)
+			assert.Nil(t, missing)
+
+			return nil
+		})
+		require.NoError(t, err)
+
+		// Putting the service again replaces its upstreams
+		err = s.Update(func(tx Tx) error {
+			service, err := tx.GetService("test")
+			if err != nil {
+				return err
+			}
+			service.Upstreams = service.Upstreams[:1]
+			return tx.PutService(service)
+		})
+		require.NoError(t, err)
+
+		err = s.Update(func(tx Tx) error {
+			service, err := tx.GetService("test")
+			require.NoError(t, err)
+			assert.Equal(t, 1, len(service.Upstreams))
+
+			deleted, err := tx.DeleteService("test")
+			require.NoError(t, err)
+			assert.True(t, deleted)
+
+			deleted, err = tx.DeleteService("test")
+			require.NoError(t, err)
+			assert.False(t, deleted)
+
+			return nil
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestStoreUpstreams(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		err := s.Update(func(tx Tx) error {
+			return tx.PutUpstream("missing", &Upstream{URL: "http://a"})
+		})
+		assert.Error(t, err, "upstreams can only be added to a service")
+
+		err = s.Update(func(tx Tx) error {
+			if err := tx.PutService(&Service{Name: "test", Path: "test"}); err != nil {
+				return err
+			}
+			if err := tx.PutService(&Service{Name: "other", Path: "other"}); err != nil {
+				return err
+			}
+
+			return tx.PutUpstream("test", &Upstream{
+				URL:                "http://a",
+				IncludeServicePath: true,
+				TTL:                30,
+				Resolve:            ResolveDNS,
+				Draining:           true,
+			})
+		})
+		require.NoError(t, err)
+
+		err = s.Update(func(tx Tx) error {
+			service, err := tx.GetService("test")
+			require.NoError(t, err)
+			require.Equal(t, 1, len(service.Upstreams))
+
+			upstream := service.Upstreams[0]
+			assert.Equal(t, "http://a", upstream.URL)
+			assert.True(t, upstream.IncludeServicePath)
+			assert.Equal(t, 30, upstream.TTL)
+			assert.Equal(t, ResolveDNS, upstream.Resolve)
+			assert.True(t, upstream.Draining)
+
+			// Upstreams belong to their service
+			deleted, err := tx.DeleteUpstream("other", "http://a")
+			require.NoError(t, err)
+			assert.False(t, deleted)
+
+			deleted, err = tx.DeleteUpstream("test", "http://a")
+			require.NoError(t, err)
+			assert.True(t, deleted)
+
+			service, err = tx.GetService("test")
+			require.NoError(t, err)
+			assert.Empty(t, service.Upstreams)
+
+			return nil
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestStoreRevisions(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		err := s.Update(func(tx Tx) error {
+			for _, path := range []string{"a", "b"} {
+				revision := Revision{
+					Service: "test",
+					Source:  SourceAPI,
+					After:   &Service{Name: "test", Path: path},
+				}
+				if err := tx.AppendRevision(&revision); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		err = s.View(func(tx Tx) error {
+			revisions, err := tx.Revisions("test")
+			require.NoError(t, err)
+			require.Equal(t, 2, len(revisions))
+			assert.Equal(t, 1, revisions[0].Number)
+			assert.Equal(t, "a", revisions[0].After.Path)
+			assert.Equal(t, 2, revisions[1].Number)
+			assert.Equal(t, "b", revisions[1].After.Path)
+
+			revisions, err = tx.Revisions("missing")
+			require.NoError(t, err)
+			assert.Empty(t, revisions)
+
+			return nil
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestStoreDeleteRevisions(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		err := s.Update(func(tx Tx) error {
+			for _, name := range []string{"a", "a", "a", "b"} {
+				if err := tx.AppendRevision(&Revision{Service: name, After: &Service{Name: name}}); err != nil {
+					return err
+				}
+			}
+			if err := tx.DeleteRevisions("a", 2); err != nil {
+				return err
+			}
+			if err := tx.DeleteRevisions("b", 1); err != nil {
+				return err
+			}
+
+			// Numbering continues after the deleted revisions
+			return tx.AppendRevision(&Revision{Service: "a", After: &Service{Name: "a"}})
+		})
+		require.NoError(t, err)
+
+		err = s.View(func(tx Tx) error {
+			names, err := tx.HistoryNames()
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a"}, names)
+
+			revisions, err := tx.Revisions("a")
+			require.NoError(t, err)
+			require.Equal(t, 2, len(revisions))
+			assert.Equal(t, 3, revisions[0].Number)
+			assert.Equal(t, 4, revisions[1].Number)
+
+			return nil
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestStoreRollback(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		err := s.Update(func(tx Tx) error {
+			if err := tx.PutService(&Service{Name: "test", Path: "test"}); err != nil {
+				return err
+			}
+			return errors.New("rollback")
+		})
+		assert.Error(t, err)
+
+		err = s.View(func(tx Tx) error {
+			service, err := tx.GetService("test")
+			require.NoError(t, err)
+			assert.Nil(t, service)
+
+			assert.Error(t, tx.PutService(&Service{Name: "test"}), "view transactions are read-only")
+			return nil
+		})
+		require.NoError(t, err)
+	})
+}
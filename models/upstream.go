@@ -1,14 +1,21 @@
 package models
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
+	"time"
 
 	"github.com/premkit/premkit/log"
-	"github.com/premkit/premkit/persistence"
+)
+
+const (
+	// ResolveDNS resolves the A and AAAA records of the upstream hostname.  Each address becomes
+	// an endpoint with the port of the URL.  Requests are only connected to the address, and keep
+	// the hostname in their Host header and to verify the certificate of https upstreams.
+	ResolveDNS = "dns"
 
-	"github.com/boltdb/bolt"
+	// ResolveSRV resolves the SRV records of the upstream hostname, for example
+	// http://_http._tcp.service.local.  Each target and port becomes an endpoint.
+	ResolveSRV = "srv"
 )
 
 // Upstream represents a single upstream that will be added to a service.
@@ -18,117 +25,192 @@ type Upstream struct {
 
 	IncludeServicePath bool `json:"include_service_path"`
 	InsecureSkipVerify bool `json:"insecure_skip_verify"`
-}
 
-// SaveUpstream will persist an upstream to the database. This will check the
-// database and update the upstream, if it already exists. Upstreams are unique by URL, so
-// if the upstream was added to a different service, saving a change will update all services
-// that shared this backend.
-func SaveUpstream(upstream *Upstream, tx *bolt.Tx) error {
-	log.Debugf("Creating or updating upstream %q", upstream.URL)
+	// TTL is the lease duration, in seconds, of this upstream.  When set, the upstream is
+	// removed if the lease is not renewed (see RenewLease) before it Expires.
+	TTL     int        `json:"ttl,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
 
-	existing, err := maybeGetUpstreamByURL([]byte(upstream.URL))
-	if err != nil {
-		return err
-	}
+	// Resolve, when set to ResolveDNS or ResolveSRV, expands the hostname of the URL into one
+	// endpoint per address it resolves to, re-resolved every ResolveInterval seconds.
+	Resolve         string `json:"resolve,omitempty"`
+	ResolveInterval int    `json:"resolve_interval,omitempty"`
 
-	if existing != nil {
-		// Update the current upstream
-		upstreamBucket := tx.Bucket([]byte(fmt.Sprintf("upstream:%s", upstream.URL)))
-		if upstreamBucket == nil {
-			err := errors.New("Bucket not found")
-			log.Error(err)
-			return err
-		}
+	// Draining upstreams are kept registered, but no new requests are forwarded to them.
+	Draining bool `json:"draining,omitempty"`
+}
 
-		// Write the fields
-		if err := upstreamBucket.Put([]byte("url"), []byte(upstream.URL)); err != nil {
-			log.Error(err)
-			return err
-		}
+// Expired returns true if the upstream has a lease that expired before now.
+func (u *Upstream) Expired(now time.Time) bool {
+	return u.Expires != nil && now.After(*u.Expires)
+}
 
-		if err := upstreamBucket.Put([]byte("include.service.path"), []byte(strconv.FormatBool(upstream.IncludeServicePath))); err != nil {
-			log.Error(err)
-			return err
-		}
+// maybeGetUpstream returns the upstream of the service, or nil if the service does not have it.
+func (r *Registry) maybeGetUpstream(serviceName []byte, url []byte) (*Upstream, error) {
+	service, err := r.maybeGetServiceByName(serviceName)
+	if err != nil || service == nil {
+		return nil, err
+	}
 
-		if err := upstreamBucket.Put([]byte("insecure.skip.verify"), []byte(strconv.FormatBool(upstream.InsecureSkipVerify))); err != nil {
-			log.Error(err)
-			return err
+	for _, upstream := range service.Upstreams {
+		if upstream.URL == string(url) {
+			return upstream, nil
 		}
-
-		return nil
 	}
 
-	// Register a new upstream
-	upstreamBucket, err := tx.CreateBucket([]byte(fmt.Sprintf("upstream:%s", upstream.URL)))
-	if err != nil {
-		log.Error(err)
-		return err
-	}
+	return nil, nil
+}
 
-	// Write the fields
-	if err := upstreamBucket.Put([]byte("url"), []byte(upstream.URL)); err != nil {
-		log.Error(err)
-		return err
+func validateUpstream(upstream *Upstream) error {
+	if upstream.TTL < 0 {
+		return fmt.Errorf("Invalid ttl %d for upstream %q", upstream.TTL, upstream.URL)
 	}
 
-	if err := upstreamBucket.Put([]byte("include.service.path"), []byte(strconv.FormatBool(upstream.IncludeServicePath))); err != nil {
-		log.Error(err)
-		return err
+	switch upstream.Resolve {
+	case "", ResolveDNS, ResolveSRV:
+	default:
+		return fmt.Errorf("Unknown resolve mode %q for upstream %q", upstream.Resolve, upstream.URL)
 	}
 
-	if err := upstreamBucket.Put([]byte("insecure.skip.verify"), []byte(strconv.FormatBool(upstream.InsecureSkipVerify))); err != nil {
-		log.Error(err)
-		return err
+	if upstream.ResolveInterval < 0 {
+		return fmt.Errorf("Invalid resolve interval %d for upstream %q", upstream.ResolveInterval, upstream.URL)
 	}
 
 	return nil
 }
 
-func maybeGetUpstreamByURL(url []byte) (*Upstream, error) {
-	db, err := persistence.GetDB()
-	if err != nil {
-		return nil, err
+// AddUpstream adds an upstream to an existing service registered through the API by actor,
+// without changing the rest of the service.  Returns nil if there is no such service,
+// ErrManagedService if the service is managed by another source, and ErrNotProxy if it is not a
+// proxy.
+func (r *Registry) AddUpstream(serviceName []byte, upstream *Upstream, actor string) (*Service, error) {
+	if err := validateUpstream(upstream); err != nil {
+		log.Error(err)
+		return nil, &ValidationError{Err: err}
 	}
 
-	upstream := Upstream{
-		URL: string(url),
+	if upstream.TTL > 0 {
+		upstream.Expires = leaseExpiration(upstream.TTL, time.Now())
 	}
 
-	ok := false
-	err = db.View(func(tx *bolt.Tx) error {
-		upstreamBucket := tx.Bucket([]byte(fmt.Sprintf("upstream:%s", url)))
-		if upstreamBucket == nil {
+	var service *Service
+	err := r.update(func(tx Tx) error {
+		current, err := tx.GetService(string(serviceName))
+		if err != nil {
+			return err
+		}
+		if current == nil {
 			return nil
 		}
 
-		ok = true
+		if current.Managed() {
+			log.Errorf("Refusing to add an upstream to service %q managed by %q", current.Name, current.Source)
+			return ErrManagedService
+		}
+
+		if current.Kind != "" && current.Kind != ServiceKindProxy {
+			log.Errorf("Refusing to add an upstream to service %q of kind %q", current.Name, current.Kind)
+			return ErrNotProxy
+		}
+
+		if err := tx.PutUpstream(current.Name, upstream); err != nil {
+			return err
+		}
+
+		service, err = touchService(tx, current.Name)
+		if err != nil {
+			return err
+		}
+
+		return recordRevision(tx, current.Name, current, service, SourceAPI, actor)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return service, nil
+}
 
-		b, err := strconv.ParseBool(string(upstreamBucket.Get([]byte("include.service.path"))))
+// SetDraining starts or stops draining an upstream of a service, or all of its upstreams when
+// upstreamURL is empty, on behalf of actor.  Returns nil if the service or upstream is not found,
+// and ErrManagedService if the service is managed by another source.
+func (r *Registry) SetDraining(serviceName []byte, upstreamURL []byte, draining bool, actor string) (*Service, error) {
+	var service *Service
+	err := r.update(func(tx Tx) error {
+		current, err := tx.GetService(string(serviceName))
 		if err != nil {
-			log.Error(err)
 			return err
 		}
-		upstream.IncludeServicePath = b
+		if current == nil {
+			return nil
+		}
+
+		// Reconciling ignores draining, so a drained managed service would stay drained
+		if current.Managed() {
+			log.Errorf("Refusing to drain service %q managed by %q", current.Name, current.Source)
+			return ErrManagedService
+		}
+
+		found := false
+		for _, upstream := range current.Upstreams {
+			if len(upstreamURL) > 0 && upstream.URL != string(upstreamURL) {
+				continue
+			}
+
+			found = true
+			drained := *upstream
+			drained.Draining = draining
+			if err := tx.PutUpstream(current.Name, &drained); err != nil {
+				return err
+			}
+		}
 
-		b, err = strconv.ParseBool(string(upstreamBucket.Get([]byte("insecure.skip.verify"))))
+		if len(upstreamURL) > 0 && !found {
+			return nil
+		}
+
+		service, err = touchService(tx, current.Name)
 		if err != nil {
-			log.Error(err)
 			return err
 		}
-		upstream.InsecureSkipVerify = b
 
-		return nil
+		return recordRevision(tx, current.Name, current, service, SourceAPI, actor)
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	if !ok {
-		return nil, nil
+	return service, nil
+}
+
+// RemoveUpstream removes an upstream from a service.  Returns false if the service did not have
+// the upstream.
+func (r *Registry) RemoveUpstream(serviceName []byte, upstreamURL []byte) (bool, error) {
+	removed := false
+	err := r.update(func(tx Tx) error {
+		current, err := tx.GetService(string(serviceName))
+		if err != nil || current == nil {
+			return err
+		}
+
+		removed, err = tx.DeleteUpstream(current.Name, string(upstreamURL))
+		if err != nil || !removed {
+			return err
+		}
+
+		after, err := touchService(tx, current.Name)
+		if err != nil {
+			return err
+		}
+
+		return recordRevision(tx, current.Name, current, after, current.Source, "")
+	})
+
+	if err != nil {
+		return false, err
 	}
 
-	return &upstream, nil
+	return removed, nil
 }
@@ -0,0 +1,113 @@
+package persistence
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/premkit/premkit/log"
+
+	"github.com/boltdb/bolt"
+)
+
+// openTimeout is how long Open waits for the lock on a data file that is in use.
+const openTimeout = time.Second
+
+// Open opens the data file for the offline commands.  An error is returned if the file is locked
+// by a running daemon, which should be backed up through its backup endpoint instead.
+func Open(path string) (*bolt.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	conn, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err == bolt.ErrTimeout {
+		err = fmt.Errorf("Data file %s is locked, is the daemon running?", path)
+	}
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// Backup writes a consistent copy of the database to w, while it continues to be used.  Returns
+// the number of bytes written.
+func Backup(db *bolt.DB, w io.Writer) (int64, error) {
+	var n int64
+	err := db.View(func(tx *bolt.Tx) error {
+		written, err := tx.WriteTo(w)
+		n = written
+		return err
+	})
+	if err != nil {
+		log.Error(err)
+		return n, err
+	}
+
+	return n, nil
+}
+
+// Check verifies the consistency of the database pages, and returns every problem found.
+func Check(db *bolt.DB) ([]error, error) {
+	problems := make([]error, 0, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			problems = append(problems, err)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return problems, nil
+}
+
+// Compact copies every bucket of the database into a new database at dst, which leaves behind the
+// free pages that bolt never returns to the filesystem.
+func Compact(db *bolt.DB, dst string) error {
+	compacted, err := bolt.Open(dst, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	defer compacted.Close()
+
+	err = db.View(func(srcTx *bolt.Tx) error {
+		return compacted.Update(func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				bucket, err := dstTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(b, bucket)
+			})
+		})
+	})
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+func copyBucket(src *bolt.Bucket, dst *bolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		// A nil value is a nested bucket
+		if v == nil {
+			nested, err := dst.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			return copyBucket(src.Bucket(k), nested)
+		}
+
+		return dst.Put(k, v)
+	})
+}
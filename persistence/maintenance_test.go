@@ -0,0 +1,75 @@
+package persistence
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestDB(t *testing.T, dirName string) *bolt.DB {
+	conn, err := bolt.Open(path.Join(dirName, "test.db"), 0600, nil)
+	require.NoError(t, err)
+
+	err = conn.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("service:test"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("path"), []byte("test")); err != nil {
+			return err
+		}
+
+		nested, err := b.CreateBucket([]byte("nested"))
+		if err != nil {
+			return err
+		}
+		return nested.Put([]byte("key"), []byte("value"))
+	})
+	require.NoError(t, err)
+
+	return conn
+}
+
+func TestBackupAndCompact(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "premkit-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dirName)
+
+	db := createTestDB(t, dirName)
+	defer db.Close()
+
+	var buf bytes.Buffer
+	n, err := Backup(db, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+
+	require.NoError(t, Compact(db, path.Join(dirName, "compacted.db")))
+
+	compacted, err := bolt.Open(path.Join(dirName, "compacted.db"), 0600, nil)
+	require.NoError(t, err)
+	defer compacted.Close()
+
+	err = compacted.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("service:test"))
+		require.NotNil(t, b)
+		assert.Equal(t, "test", string(b.Get([]byte("path"))))
+		assert.Equal(t, "value", string(b.Bucket([]byte("nested")).Get([]byte("key"))))
+		return nil
+	})
+	require.NoError(t, err)
+
+	problems, err := Check(compacted)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+}
+
+func TestOpenMissing(t *testing.T) {
+	_, err := Open("/tmp/premkit-missing.db")
+	assert.Error(t, err)
+}
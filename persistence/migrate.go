@@ -0,0 +1,240 @@
+package persistence
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/premkit/premkit/log"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	metaBucket       = []byte("meta")
+	schemaVersionKey = []byte("schema.version")
+)
+
+// Migration upgrades the database from the previous schema version to Version.  Migrations work
+// on the raw buckets, and must not use the models, which only know the latest layout.
+type Migration struct {
+	Version     int
+	Description string
+	Migrate     func(tx *bolt.Tx) error
+}
+
+// migrations are applied in order.  Append new migrations to the end; never change or remove one
+// that has been released.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "write defaults for the service and upstream fields added since the original layout",
+		Migrate:     migrateFieldDefaults,
+	},
+	{
+		Version:     2,
+		Description: "move upstreams into the services that reference them",
+		Migrate:     migrateScopeUpstreams,
+	},
+}
+
+// SchemaVersion returns the latest schema version.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// GetSchemaVersion returns the schema version of the database.  Databases written before the
+// version was recorded are version 0.
+func GetSchemaVersion(db *bolt.DB) (int, error) {
+	version := 0
+	err := db.View(func(tx *bolt.Tx) error {
+		v, err := readSchemaVersion(tx)
+		version = v
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+func readSchemaVersion(tx *bolt.Tx) (int, error) {
+	meta := tx.Bucket(metaBucket)
+	if meta == nil {
+		return 0, nil
+	}
+
+	v := meta.Get(schemaVersionKey)
+	if v == nil {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(string(v))
+	if err != nil {
+		log.Error(err)
+		return 0, err
+	}
+
+	return version, nil
+}
+
+func writeSchemaVersion(tx *bolt.Tx, version int) error {
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if err := meta.Put(schemaVersionKey, []byte(strconv.Itoa(version))); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Migrate upgrades the database to the latest schema version.  When there is anything to migrate,
+// a copy of the database is first written next to the data file, named after its old version.
+// Each migration is applied in its own transaction, together with the new version, so a failed
+// migration leaves the database at the last version that succeeded.
+func Migrate(db *bolt.DB) error {
+	version, err := GetSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	latest := SchemaVersion()
+	if version > latest {
+		err := fmt.Errorf("Database schema version %d is newer than %d, which is supported by this version of premkit", version, latest)
+		log.Error(err)
+		return err
+	}
+	if version == latest {
+		return nil
+	}
+
+	empty, err := isEmpty(db)
+	if err != nil {
+		return err
+	}
+
+	if !empty {
+		backup, err := backupBeforeMigrate(db, version)
+		if err != nil {
+			return err
+		}
+		log.Infof("Backed up the database to %s before migrating from schema version %d", backup, version)
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= version {
+			continue
+		}
+
+		err := db.Update(func(tx *bolt.Tx) error {
+			if err := migration.Migrate(tx); err != nil {
+				return err
+			}
+			return writeSchemaVersion(tx, migration.Version)
+		})
+		if err != nil {
+			log.Errorf("Failed to migrate the database to schema version %d: %v", migration.Version, err)
+			return err
+		}
+
+		log.Infof("Migrated the database to schema version %d: %s", migration.Version, migration.Description)
+	}
+
+	return nil
+}
+
+func isEmpty(db *bolt.DB) (bool, error) {
+	empty := true
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			empty = false
+			return nil
+		})
+	})
+	if err != nil {
+		log.Error(err)
+		return false, err
+	}
+
+	return empty, nil
+}
+
+func backupBeforeMigrate(db *bolt.DB, version int) (string, error) {
+	backup := fmt.Sprintf("%s.v%d.%s.bak", db.Path(), version, time.Now().UTC().Format("20060102T150405Z"))
+
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(backup, 0600)
+	})
+	if err != nil {
+		log.Error(err)
+		return "", err
+	}
+
+	return filepath.Clean(backup), nil
+}
+
+// migrateFieldDefaults writes every key that the original layout did not have, so the layout of
+// every service and upstream is the same.  Services from before sources were recorded were
+// registered through the API.
+func migrateFieldDefaults(tx *bolt.Tx) error {
+	serviceDefaults := map[string]string{
+		"priority": "0",
+		"kind":     "",
+		"ttl":      "0",
+		"source":   "api",
+	}
+	upstreamDefaults := map[string]string{
+		"include.service.path": "false",
+		"insecure.skip.verify": "false",
+		"ttl":                  "0",
+		"resolve":              "",
+		"resolve.interval":     "0",
+		"draining":             "false",
+	}
+
+	return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		defaults := map[string]string(nil)
+		switch {
+		case strings.HasPrefix(string(name), "service:"):
+			defaults = serviceDefaults
+		case strings.HasPrefix(string(name), "upstream:"):
+			defaults = upstreamDefaults
+		default:
+			return nil
+		}
+
+		for key, value := range defaults {
+			if b.Get([]byte(key)) != nil {
+				continue
+			}
+			if err := b.Put([]byte(key), []byte(value)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// migrateScopeUpstreams stores a copy of each upstream in every service that references it, so
+// changing or deleting one service can no longer change another.
+func migrateScopeUpstreams(tx *bolt.Tx) error {
+	repairs, err := scopeUpstreams(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, repair := range repairs {
+		log.Infof("%s", repair)
+	}
+
+	return nil
+}
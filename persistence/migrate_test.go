@@ -0,0 +1,242 @@
+package persistence
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixture is a database layout written by an older version of premkit, as bucket name to keys.
+type fixture map[string]map[string]string
+
+var (
+	// fixtureOriginal is the layout before any fields were added to services and upstreams.
+	fixtureOriginal = fixture{
+		"service:ui": {
+			"path":                    "ui",
+			"upstream:http://ui:3000": "http://ui:3000",
+		},
+		"upstream:http://ui:3000": {
+			"url":                  "http://ui:3000",
+			"include.service.path": "true",
+			"insecure.skip.verify": "false",
+		},
+	}
+
+	// fixtureUnversioned is the layout with some of the fields added before the schema version
+	// was recorded.
+	fixtureUnversioned = fixture{
+		"service:api": {
+			"path":                     "api",
+			"priority":                 "10",
+			"source":                   "docker",
+			"upstream:http://api:3000": "http://api:3000",
+		},
+		"upstream:http://api:3000": {
+			"url":                  "http://api:3000",
+			"include.service.path": "false",
+			"insecure.skip.verify": "true",
+			"ttl":                  "30",
+		},
+	}
+)
+
+func openFixture(t *testing.T, f fixture) (*bolt.DB, string) {
+	dirName, err := ioutil.TempDir("", "premkit-test")
+	require.NoError(t, err)
+
+	db, err := bolt.Open(path.Join(dirName, "premkit.db"), 0600, nil)
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for name, keys := range f {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			for k, v := range keys {
+				if err := b.Put([]byte(k), []byte(v)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	return db, dirName
+}
+
+func getKey(t *testing.T, db *bolt.DB, bucket string, key string) string {
+	value := ""
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		require.NotNil(t, b, bucket)
+		v := b.Get([]byte(key))
+		require.NotNil(t, v, "%s %s", bucket, key)
+		value = string(v)
+		return nil
+	})
+	require.NoError(t, err)
+
+	return value
+}
+
+// getUpstreamKey reads a key of an upstream bucket nested in a service bucket.
+func getUpstreamKey(t *testing.T, db *bolt.DB, service string, upstream string, key string) string {
+	value := ""
+	err := db.View(func(tx *bolt.Tx) error {
+		s := tx.Bucket([]byte(service))
+		require.NotNil(t, s, service)
+		b := s.Bucket([]byte(upstream))
+		require.NotNil(t, b, "%s %s", service, upstream)
+		v := b.Get([]byte(key))
+		require.NotNil(t, v, "%s %s %s", service, upstream, key)
+		value = string(v)
+		return nil
+	})
+	require.NoError(t, err)
+
+	return value
+}
+
+func hasBucket(t *testing.T, db *bolt.DB, bucket string) bool {
+	found := false
+	err := db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket([]byte(bucket)) != nil
+		return nil
+	})
+	require.NoError(t, err)
+
+	return found
+}
+
+func TestMigrateOriginalLayout(t *testing.T) {
+	db, dirName := openFixture(t, fixtureOriginal)
+	defer os.RemoveAll(dirName)
+	defer db.Close()
+
+	require.NoError(t, Migrate(db))
+
+	version, err := GetSchemaVersion(db)
+	require.NoError(t, err)
+	assert.Equal(t, SchemaVersion(), version)
+
+	assert.Equal(t, "ui", getKey(t, db, "service:ui", "path"))
+	assert.Equal(t, "0", getKey(t, db, "service:ui", "priority"))
+	assert.Equal(t, "api", getKey(t, db, "service:ui", "source"))
+	assert.Equal(t, "true", getUpstreamKey(t, db, "service:ui", "upstream:http://ui:3000", "include.service.path"))
+	assert.Equal(t, "0", getUpstreamKey(t, db, "service:ui", "upstream:http://ui:3000", "ttl"))
+	assert.Equal(t, "false", getUpstreamKey(t, db, "service:ui", "upstream:http://ui:3000", "draining"))
+	assert.False(t, hasBucket(t, db, "upstream:http://ui:3000"), "the shared upstream should be removed")
+
+	backups, err := filepath.Glob(filepath.Join(dirName, "premkit.db.v0.*.bak"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(backups))
+
+	// The backup has the original layout
+	backup, err := bolt.Open(backups[0], 0600, nil)
+	require.NoError(t, err)
+	defer backup.Close()
+
+	backupVersion, err := GetSchemaVersion(backup)
+	require.NoError(t, err)
+	assert.Equal(t, 0, backupVersion)
+}
+
+func TestMigrateUnversionedLayout(t *testing.T) {
+	db, dirName := openFixture(t, fixtureUnversioned)
+	defer os.RemoveAll(dirName)
+	defer db.Close()
+
+	require.NoError(t, Migrate(db))
+
+	// Values that were already written are kept
+	assert.Equal(t, "10", getKey(t, db, "service:api", "priority"))
+	assert.Equal(t, "docker", getKey(t, db, "service:api", "source"))
+	assert.Equal(t, "true", getUpstreamKey(t, db, "service:api", "upstream:http://api:3000", "insecure.skip.verify"))
+	assert.Equal(t, "30", getUpstreamKey(t, db, "service:api", "upstream:http://api:3000", "ttl"))
+	assert.Equal(t, "0", getUpstreamKey(t, db, "service:api", "upstream:http://api:3000", "resolve.interval"))
+
+	// Migrating again does nothing, and takes no backup
+	require.NoError(t, Migrate(db))
+	backups, err := filepath.Glob(filepath.Join(dirName, "*.bak"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(backups))
+}
+
+func TestMigrateEmpty(t *testing.T) {
+	db, dirName := openFixture(t, fixture{})
+	defer os.RemoveAll(dirName)
+	defer db.Close()
+
+	require.NoError(t, Migrate(db))
+
+	version, err := GetSchemaVersion(db)
+	require.NoError(t, err)
+	assert.Equal(t, SchemaVersion(), version)
+
+	backups, err := filepath.Glob(filepath.Join(dirName, "*.bak"))
+	require.NoError(t, err)
+	assert.Empty(t, backups)
+}
+
+func TestMigrateNewerVersion(t *testing.T) {
+	db, dirName := openFixture(t, fixture{
+		"meta": {"schema.version": strconv.Itoa(SchemaVersion() + 1)},
+	})
+	defer os.RemoveAll(dirName)
+	defer db.Close()
+
+	assert.Error(t, Migrate(db))
+}
+
+func TestMigrateSharedUpstreams(t *testing.T) {
+	db, dirName := openFixture(t, fixture{
+		"service:a": {
+			"path":                       "a",
+			"upstream:http://shared:80":  "http://shared:80",
+			"upstream:http://missing:80": "http://missing:80",
+		},
+		"service:b": {
+			"path":                      "b",
+			"upstream:http://shared:80": "http://shared:80",
+		},
+		"upstream:http://shared:80": {
+			"url":                  "http://shared:80",
+			"include.service.path": "true",
+			"insecure.skip.verify": "false",
+		},
+		"upstream:http://orphan:80": {
+			"url":                  "http://orphan:80",
+			"include.service.path": "false",
+			"insecure.skip.verify": "false",
+		},
+	})
+	defer os.RemoveAll(dirName)
+	defer db.Close()
+
+	require.NoError(t, Migrate(db))
+
+	// Each service has its own copy of the shared upstream
+	assert.Equal(t, "true", getUpstreamKey(t, db, "service:a", "upstream:http://shared:80", "include.service.path"))
+	assert.Equal(t, "true", getUpstreamKey(t, db, "service:b", "upstream:http://shared:80", "include.service.path"))
+
+	err := db.View(func(tx *bolt.Tx) error {
+		a := tx.Bucket([]byte("service:a"))
+		assert.Nil(t, a.Get([]byte("upstream:http://missing:80")), "the dangling reference should be removed")
+		assert.Nil(t, a.Bucket([]byte("upstream:http://missing:80")))
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.False(t, hasBucket(t, db, "upstream:http://shared:80"))
+	assert.False(t, hasBucket(t, db, "upstream:http://orphan:80"))
+}
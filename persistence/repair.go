@@ -0,0 +1,119 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/premkit/premkit/log"
+
+	"github.com/boltdb/bolt"
+)
+
+// errDryRun rolls back the transaction of a dry run.
+var errDryRun = errors.New("Dry run")
+
+// RepairUpstreams moves upstreams that are still stored in the shared layout into the services
+// that reference them, and deletes the ones no service references.  Upstreams were shared by URL
+// before schema version 2, and an older premkit can still write that layout to a migrated
+// database, so this is run at every startup.  Returns a description of each repair.  When dryRun
+// is set, the repairs are described but not made.
+func RepairUpstreams(db *bolt.DB, dryRun bool) ([]string, error) {
+	var repairs []string
+	err := db.Update(func(tx *bolt.Tx) error {
+		r, err := scopeUpstreams(tx)
+		if err != nil {
+			return err
+		}
+
+		repairs = r
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && err != errDryRun {
+		log.Error(err)
+		return nil, err
+	}
+
+	return repairs, nil
+}
+
+// scopeUpstreams replaces each upstream:<url> reference key of a service bucket with a nested
+// upstream:<url> bucket holding a copy of the shared upstream:<url> bucket, then deletes the
+// shared buckets.  References to missing upstreams are dropped.
+func scopeUpstreams(tx *bolt.Tx) ([]string, error) {
+	repairs := make([]string, 0, 0)
+
+	var serviceNames, upstreamNames [][]byte
+	err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		switch {
+		case strings.HasPrefix(string(name), "service:"):
+			serviceNames = append(serviceNames, append([]byte(nil), name...))
+		case strings.HasPrefix(string(name), "upstream:"):
+			upstreamNames = append(upstreamNames, append([]byte(nil), name...))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]bool)
+	for _, serviceName := range serviceNames {
+		service := tx.Bucket(serviceName)
+
+		// Nested buckets have a nil value, references have the URL.  Keys can't be changed
+		// while iterating.
+		var references [][]byte
+		err := service.ForEach(func(k, v []byte) error {
+			if strings.HasPrefix(string(k), "upstream:") && v != nil {
+				references = append(references, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, reference := range references {
+			referenced[string(reference)] = true
+
+			if err := service.Delete(reference); err != nil {
+				return nil, err
+			}
+
+			shared := tx.Bucket(reference)
+			if shared == nil {
+				repairs = append(repairs, fmt.Sprintf("Remove the reference of %s to missing %s", serviceName, reference))
+				continue
+			}
+
+			scoped, err := service.CreateBucketIfNotExists(reference)
+			if err != nil {
+				return nil, err
+			}
+			err = shared.ForEach(func(k, v []byte) error {
+				return scoped.Put(k, v)
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			repairs = append(repairs, fmt.Sprintf("Move %s into %s", reference, serviceName))
+		}
+	}
+
+	for _, upstreamName := range upstreamNames {
+		if err := tx.DeleteBucket(upstreamName); err != nil {
+			return nil, err
+		}
+
+		if !referenced[string(upstreamName)] {
+			repairs = append(repairs, fmt.Sprintf("Remove %s, which is not referenced by any service", upstreamName))
+		}
+	}
+
+	return repairs, nil
+}
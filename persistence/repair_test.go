@@ -0,0 +1,36 @@
+package persistence
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairUpstreams(t *testing.T) {
+	db, dirName := openFixture(t, fixtureOriginal)
+	defer os.RemoveAll(dirName)
+	defer db.Close()
+
+	expected := []string{
+		"Move upstream:http://ui:3000 into service:ui",
+	}
+
+	// A dry run changes nothing
+	repairs, err := RepairUpstreams(db, true)
+	require.NoError(t, err)
+	assert.Equal(t, expected, repairs)
+	assert.True(t, hasBucket(t, db, "upstream:http://ui:3000"))
+
+	repairs, err = RepairUpstreams(db, false)
+	require.NoError(t, err)
+	assert.Equal(t, expected, repairs)
+	assert.False(t, hasBucket(t, db, "upstream:http://ui:3000"))
+	assert.Equal(t, "true", getUpstreamKey(t, db, "service:ui", "upstream:http://ui:3000", "include.service.path"))
+
+	// There is nothing left to repair
+	repairs, err = RepairUpstreams(db, false)
+	require.NoError(t, err)
+	assert.Empty(t, repairs)
+}
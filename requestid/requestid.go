@@ -0,0 +1,132 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/utils"
+)
+
+// Header is the request and response header of the request ID.
+const Header = "X-Request-Id"
+
+// maxLength is the longest request ID accepted from a trusted source.
+const maxLength = 128
+
+// idKey is the context key of the request ID.
+type idKey struct{}
+
+// FromContext returns the ID of the request with the context, or an empty string if it has none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
+
+// NewContext returns ctx with the request ID.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// ParseNetworks parses the trusted sources, which are addresses or CIDR networks.
+func ParseNetworks(sources []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(sources))
+	for _, source := range sources {
+		if !strings.Contains(source, "/") {
+			ip := net.ParseIP(source)
+			if ip == nil {
+				err := fmt.Errorf("Invalid address %q", source)
+				log.Error(err)
+				return nil, err
+			}
+
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(source)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// Handler gives every request to next an ID.  The ID in the Header of the request is kept when
+// the request comes from one of the trusted networks, and a new one is generated otherwise.  The
+// ID is set in the request header, so it is forwarded to upstreams, in the response header, and
+// in the request context.
+func Handler(trusted []*net.IPNet, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		id := request.Header.Get(Header)
+		if !valid(id) || !Trusted(trusted, request.RemoteAddr) {
+			id = New()
+		}
+
+		request.Header.Set(Header, id)
+
+		// The ID of the request replaces any ID the upstream answered with
+		writer := utils.NewResponseRecorder(response)
+		writer.BeforeHeader = func(header http.Header) {
+			header.Set(Header, id)
+		}
+		next.ServeHTTP(writer, request.WithContext(NewContext(request.Context(), id)))
+	})
+}
+
+// New returns a random request ID.
+func New() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Error(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Trusted returns true if remoteAddr, the address of a request, is in one of the trusted networks.
+func Trusted(trusted []*net.IPNet, remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,80 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks([]string{"10.0.0.0/8", "192.168.1.1", "::1"})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(networks))
+	assert.Equal(t, "10.0.0.0/8", networks[0].String())
+	assert.Equal(t, "192.168.1.1/32", networks[1].String())
+	assert.Equal(t, "::1/128", networks[2].String())
+
+	_, err = ParseNetworks([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+
+	_, err = ParseNetworks([]string{"localhost"})
+	assert.Error(t, err)
+}
+
+func TestHandler(t *testing.T) {
+	trusted, err := ParseNetworks([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	var forwarded, fromContext string
+	handler := Handler(trusted, http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		forwarded = request.Header.Get(Header)
+		fromContext = FromContext(request.Context())
+
+		// The id of the request replaces any id an upstream answers with
+		response.Header().Set(Header, "upstream")
+		response.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		id         string
+		kept       bool
+	}{
+		{name: "trusted", remoteAddr: "10.1.2.3:5000", id: "abc-123", kept: true},
+		{name: "untrusted", remoteAddr: "192.168.1.1:5000", id: "abc-123"},
+		{name: "missing", remoteAddr: "10.1.2.3:5000"},
+		{name: "invalid", remoteAddr: "10.1.2.3:5000", id: "abc 123"},
+		{name: "too long", remoteAddr: "10.1.2.3:5000", id: strings.Repeat("a", maxLength+1)},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.RemoteAddr = test.remoteAddr
+		if test.id != "" {
+			request.Header.Set(Header, test.id)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		id := recorder.Header().Get(Header)
+		if test.kept {
+			assert.Equal(t, test.id, id, test.name)
+		} else {
+			assert.NotEqual(t, test.id, id, test.name)
+			assert.Equal(t, 32, len(id), test.name)
+		}
+		assert.Equal(t, id, forwarded, test.name)
+		assert.Equal(t, id, fromContext, test.name)
+		assert.Equal(t, 1, len(recorder.Header()[Header]), test.name)
+	}
+}
+
+func TestNew(t *testing.T) {
+	assert.NotEqual(t, New(), New())
+	assert.Empty(t, FromContext(httptest.NewRequest("GET", "/", nil).Context()))
+}
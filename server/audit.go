@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/premkit/premkit/audit"
+	"github.com/premkit/premkit/models"
+	"github.com/premkit/premkit/requestid"
+	"github.com/premkit/premkit/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// auditErrorLength is the most of a failed response that is kept as the error of an entry.
+const auditErrorLength = 256
+
+// auditHandler writes an entry for every call to next to the audit logger, or returns next when
+// there is no logger.
+func auditHandler(logger *audit.Logger, operation string, next http.HandlerFunc) http.Handler {
+	if logger == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+
+		entry := audit.Entry{
+			Time:         start,
+			Caller:       auditCaller(request),
+			ClaimedActor: request.Header.Get(models.ActorHeader),
+			SourceIP:     request.RemoteAddr,
+			RequestID:    requestid.FromContext(request.Context()),
+			Operation:    operation,
+			Method:       request.Method,
+			Path:         request.URL.Path,
+			Service:      mux.Vars(request)["name"],
+			Upstream:     request.URL.Query().Get("upstream"),
+		}
+		if host, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+			entry.SourceIP = host
+		}
+		if request.Method == "POST" {
+			service, upstream := auditBody(request)
+			if entry.Service == "" {
+				entry.Service = service
+			}
+			if entry.Upstream == "" {
+				entry.Upstream = upstream
+			}
+		}
+
+		recorder := &auditRecorder{ResponseRecorder: utils.NewResponseRecorder(response)}
+		next.ServeHTTP(recorder, request)
+
+		entry.Status = recorder.Status
+		entry.Result = audit.ResultSuccess
+		if recorder.Status >= 400 {
+			entry.Result = audit.ResultFailure
+			entry.Error = strings.TrimSpace(recorder.body.String())
+		}
+		entry.DurationMS = time.Since(start).Nanoseconds() / int64(time.Millisecond)
+
+		logger.Log(&entry)
+	})
+}
+
+// auditCaller identifies the caller by the common name of its client certificate, when the
+// certificate was verified, which the https listener only does with a TLSClientCAFile.  The actor
+// header is set by the caller, so it is not trusted here.
+func auditCaller(request *http.Request) string {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 || len(request.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	return request.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// auditBody reads the target service and upstream from the body of a registration, or of an
+// added upstream, and puts the body back for the handler.
+func auditBody(request *http.Request) (string, string) {
+	if request.Body == nil {
+		return "", ""
+	}
+
+	body, err := ioutil.ReadAll(request.Body)
+	request.Body.Close()
+	request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "", ""
+	}
+
+	params := struct {
+		Service  *models.Service  `json:"service"`
+		Upstream *models.Upstream `json:"upstream"`
+	}{}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return "", ""
+	}
+
+	service, upstream := "", ""
+	if params.Service != nil {
+		service = params.Service.Name
+		if len(params.Service.Upstreams) > 0 && params.Service.Upstreams[0] != nil {
+			upstream = params.Service.Upstreams[0].URL
+		}
+	}
+	if params.Upstream != nil {
+		upstream = params.Upstream.URL
+	}
+
+	return service, upstream
+}
+
+// auditRecorder records the response like utils.ResponseRecorder, and the start of the body of
+// failed responses.
+type auditRecorder struct {
+	*utils.ResponseRecorder
+	body bytes.Buffer
+}
+
+func (r *auditRecorder) Write(b []byte) (int, error) {
+	if r.Status >= 400 && r.body.Len() < auditErrorLength {
+		remaining := auditErrorLength - r.body.Len()
+		if len(b) < remaining {
+			remaining = len(b)
+		}
+		r.body.Write(b[:remaining])
+	}
+
+	return r.ResponseRecorder.Write(b)
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/premkit/premkit/audit"
+	"github.com/premkit/premkit/models"
+	"github.com/premkit/premkit/utils"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bufferSink is a sink that is safe for concurrent use, as the audit logger requires.
+type bufferSink struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *bufferSink) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buffer.Write(p)
+}
+
+func (b *bufferSink) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buffer.String()
+}
+
+func (b *bufferSink) Close() error {
+	return nil
+}
+
+func TestAuditHandler(t *testing.T) {
+	sink := &bufferSink{}
+	logger := audit.New(sink)
+
+	var handledBody string
+	router := mux.NewRouter()
+	router.Handle("/service", auditHandler(logger, "registerService", func(response http.ResponseWriter, request *http.Request) {
+		body := new(bytes.Buffer)
+		body.ReadFrom(request.Body)
+		handledBody = body.String()
+		response.WriteHeader(http.StatusCreated)
+	})).Methods("POST")
+	router.Handle("/service/{name}", auditHandler(logger, "deregisterService", func(response http.ResponseWriter, request *http.Request) {
+		http.Error(response, "Service not found", http.StatusNotFound)
+	})).Methods("DELETE")
+
+	registration := `{"service":{"name":"app","path":"app","upstreams":[{"url":"http://localhost:3000"}]}}`
+	request := httptest.NewRequest("POST", "/service", strings.NewReader(registration))
+	request.RemoteAddr = "10.0.0.1:5000"
+	request.Header.Set(models.ActorHeader, "alice")
+	request.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{&x509.Certificate{Subject: pkix.Name{CommonName: "deployer"}}},
+		VerifiedChains:   [][]*x509.Certificate{{&x509.Certificate{Subject: pkix.Name{CommonName: "deployer"}}}},
+	}
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	assert.Equal(t, registration, handledBody, "the handler should read the whole body")
+
+	request = httptest.NewRequest("DELETE", "/service/other?upstream=http://localhost:4000", nil)
+	request.RemoteAddr = "10.0.0.2:5000"
+	request.Header.Set(models.ActorHeader, "mallory")
+	request.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{&x509.Certificate{Subject: pkix.Name{CommonName: "unverified"}}},
+	}
+	router.ServeHTTP(httptest.NewRecorder(), request)
+
+	lines := strings.Split(strings.TrimSpace(sink.String()), "\n")
+	require.Equal(t, 2, len(lines))
+
+	entry := audit.Entry{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "deployer", entry.Caller)
+	assert.Equal(t, "alice", entry.ClaimedActor)
+	assert.Equal(t, "10.0.0.1", entry.SourceIP)
+	assert.Equal(t, "registerService", entry.Operation)
+	assert.Equal(t, "POST", entry.Method)
+	assert.Equal(t, "app", entry.Service)
+	assert.Equal(t, "http://localhost:3000", entry.Upstream)
+	assert.Equal(t, http.StatusCreated, entry.Status)
+	assert.Equal(t, audit.ResultSuccess, entry.Result)
+	assert.Empty(t, entry.Error)
+
+	entry = audit.Entry{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Empty(t, entry.Caller, "unverified certificates should not identify the caller")
+	assert.Equal(t, "mallory", entry.ClaimedActor)
+	assert.Equal(t, "10.0.0.2", entry.SourceIP)
+	assert.Equal(t, "deregisterService", entry.Operation)
+	assert.Equal(t, "other", entry.Service)
+	assert.Equal(t, "http://localhost:4000", entry.Upstream)
+	assert.Equal(t, http.StatusNotFound, entry.Status)
+	assert.Equal(t, audit.ResultFailure, entry.Result)
+	assert.Equal(t, "Service not found", entry.Error)
+}
+
+func TestAuditHandlerDisabled(t *testing.T) {
+	called := false
+	handler := auditHandler(nil, "listServices", func(response http.ResponseWriter, request *http.Request) {
+		called = true
+	})
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/service", nil))
+	assert.True(t, called)
+}
+
+func TestAuditCallerTLS(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "premkit test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err = x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	clientCertificate := func(signer *x509.Certificate, signerKey *ecdsa.PrivateKey) tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "deployer"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		if signer == nil {
+			signer, signerKey = template, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+		require.NoError(t, err)
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	sink := &bufferSink{}
+	server := httptest.NewUnstartedServer(auditHandler(audit.New(sink), "listServices", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = getTLSConfig(nil, clientCAs)
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	// Every call is a new connection with its own certificate, which is sent even when it is not
+	// signed by a ca the server asks for
+	get := func(certificates ...tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs: roots,
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				if len(certificates) == 0 {
+					return &tls.Certificate{}, nil
+				}
+				return &certificates[0], nil
+			},
+		}}}
+		response, err := client.Get(server.URL + "/service")
+		if err == nil {
+			response.Body.Close()
+		}
+		return err
+	}
+
+	require.NoError(t, get(clientCertificate(ca, caKey)))
+	require.NoError(t, get())
+	assert.Error(t, get(clientCertificate(nil, nil)), "certificates of other cas should be refused")
+
+	lines := strings.Split(strings.TrimSpace(sink.String()), "\n")
+	require.Equal(t, 2, len(lines))
+
+	entry := audit.Entry{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "deployer", entry.Caller)
+
+	entry = audit.Entry{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Empty(t, entry.Caller)
+}
+
+func TestAuditRecorder(t *testing.T) {
+	recorder := &auditRecorder{ResponseRecorder: utils.NewResponseRecorder(httptest.NewRecorder())}
+
+	// Only the first status is kept, like the response writer does
+	recorder.WriteHeader(http.StatusBadRequest)
+	recorder.WriteHeader(http.StatusOK)
+	recorder.Write([]byte(strings.Repeat("e", 2*auditErrorLength)))
+	assert.Equal(t, http.StatusBadRequest, recorder.Status)
+	assert.Equal(t, auditErrorLength, recorder.body.Len())
+
+	// Websockets on the admin api pass through the recorder
+	_, ok := interface{}(recorder).(http.Hijacker)
+	assert.True(t, ok)
+}
@@ -1,10 +1,93 @@
 package server
 
+const (
+	// StoreBolt keeps the registry in the bolt database in the data_file.
+	StoreBolt = "bolt"
+
+	// StoreMemory keeps the registry in memory, for ephemeral deployments that register every
+	// service at startup.
+	StoreMemory = "memory"
+)
+
 // Config represents the config to use to start the web server.
 type Config struct {
 	HTTPPort  int
 	HTTPSPort int
 
+	// AdminPort, when not zero, is a port for a separate http listener that serves the admin api
+	// and /metrics, but does not forward to services.  Metrics and the backup of the database are
+	// only served on this listener.
+	AdminPort int
+
 	TLSKeyFile  string
 	TLSCertFile string
+
+	// TLSClientCAFile, when set, is a PEM file of the CAs that sign client certificates.  The https
+	// listener then verifies the client certificates it is given, and audits the common name of a
+	// verified certificate as the caller of the admin api.
+	TLSClientCAFile string
+
+	// HTTPSRedirect makes the http listener redirect to the https listener, except for ACME
+	// challenges and paths in HTTPSRedirectExclude.  HTTPSRedirectPort is the port used in the
+	// redirect, when it differs from HTTPSPort (for example when the port is mapped).
+	HTTPSRedirect        bool
+	HTTPSRedirectPort    int
+	HTTPSRedirectExclude []string
+
+	// HSTSMaxAge, when not zero, adds a Strict-Transport-Security header to https responses.
+	HSTSMaxAge int
+
+	// ServicesFile is the path of a YAML or JSON file of services to keep registered.
+	ServicesFile string
+
+	// DockerSocket, when set, is the path of the docker engine socket to discover labeled
+	// containers from.
+	DockerSocket string
+
+	// Kubernetes, when set, discovers annotated Services from the cluster premkit runs in, in
+	// KubernetesNamespace or all namespaces if it is empty.
+	Kubernetes          bool
+	KubernetesNamespace string
+
+	// AccessLog, when set, is where every request is logged: accesslog.Stdout, or a file that is
+	// rotated when it grows past AccessLogMaxSize megabytes with AccessLogMaxBackups kept.
+	// AccessLogFormat is one of the accesslog formats, and AccessLogTemplate the template of
+	// accesslog.FormatTemplate.
+	AccessLog           string
+	AccessLogFormat     string
+	AccessLogTemplate   string
+	AccessLogMaxSize    int
+	AccessLogMaxBackups int
+
+	// OTLPEndpoint, when set, is the url of an OTLP/HTTP collector to export a trace span of every
+	// proxied request to.  TraceSampleRatio of the traces that are not sampled by the caller are
+	// exported.
+	OTLPEndpoint     string
+	TraceSampleRatio float64
+
+	// AuditLog, when set, is where every call to the admin API is audited: a file, rotated when it
+	// grows past AuditLogMaxSize megabytes with AuditLogMaxBackups kept, or a unix socket given
+	// as "unix:/path".
+	AuditLog           string
+	AuditLogMaxSize    int
+	AuditLogMaxBackups int
+
+	// WebhookURLs receive a signed POST for every event of a type in WebhookEvents, or every event
+	// when it is empty.  Deliveries that fail are written to the WebhookDeadLetter file.
+	WebhookURLs       []string
+	WebhookSecret     string
+	WebhookEvents     []string
+	WebhookDeadLetter string
+
+	// HistoryMaxRevisions is the number of revisions kept of each service, and HistoryMaxAge the
+	// number of days the history of a deleted service is kept.  Zero keeps them all.
+	HistoryMaxRevisions int
+	HistoryMaxAge       int
+
+	// RequestIDTrusted are the addresses and CIDR networks whose X-Request-Id header, and trace
+	// context, are kept.  Every other request is given a new ID, and starts a new trace.
+	RequestIDTrusted []string
+
+	// Store is StoreBolt (the default) or StoreMemory.
+	Store string
 }
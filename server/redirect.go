@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// acmeChallengePath is always served over http, so certificates can be issued with the http-01
+// challenge while the http listener is redirecting everything else.
+const acmeChallengePath = "/.well-known/acme-challenge/"
+
+// httpsRedirectHandler answers requests on the http listener with a redirect to the same url on
+// the https listener, except for ACME challenges and the excluded service paths, which are passed
+// to next.
+func httpsRedirectHandler(config *Config, next http.Handler) http.Handler {
+	port := config.HTTPSRedirectPort
+	if port == 0 {
+		port = config.HTTPSPort
+	}
+
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if !shouldRedirectToHTTPS(request.URL.Path, config.HTTPSRedirectExclude) {
+			next.ServeHTTP(response, request)
+			return
+		}
+
+		host := request.Host
+		if h, _, err := net.SplitHostPort(request.Host); err == nil {
+			host = h
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, fmt.Sprintf("%d", port))
+		}
+
+		target := fmt.Sprintf("https://%s%s", host, request.URL.RequestURI())
+
+		// 301 responses allow clients to change the method to GET, so use 308 for anything else
+		code := http.StatusMovedPermanently
+		if request.Method != "GET" && request.Method != "HEAD" {
+			code = http.StatusPermanentRedirect
+		}
+
+		http.Redirect(response, request, target, code)
+	})
+}
+
+func shouldRedirectToHTTPS(requestPath string, exclude []string) bool {
+	if strings.HasPrefix(requestPath, acmeChallengePath) {
+		return false
+	}
+
+	requestPath = strings.TrimPrefix(requestPath, "/")
+	for _, servicePath := range exclude {
+		servicePath = strings.TrimPrefix(servicePath, "/")
+		if servicePath == "" {
+			continue
+		}
+		if strings.HasPrefix(requestPath, servicePath) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hstsHandler adds a Strict-Transport-Security header to every response.
+func hstsHandler(maxAge int, next http.Handler) http.Handler {
+	value := fmt.Sprintf("max-age=%d", maxAge)
+
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(response, request)
+	})
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	next := http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusTeapot)
+	})
+
+	config := Config{
+		HTTPSPort:            2443,
+		HTTPSRedirect:        true,
+		HTTPSRedirectExclude: []string{"/health"},
+	}
+	handler := httpsRedirectHandler(&config, next)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://example.com:2080/app/page?a=b", nil))
+	assert.Equal(t, http.StatusMovedPermanently, recorder.Code)
+	assert.Equal(t, "https://example.com:2443/app/page?a=b", recorder.Header().Get("Location"))
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "http://example.com/app", nil))
+	assert.Equal(t, http.StatusPermanentRedirect, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://example.com/.well-known/acme-challenge/token", nil))
+	assert.Equal(t, http.StatusTeapot, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://example.com/health/ready", nil))
+	assert.Equal(t, http.StatusTeapot, recorder.Code)
+}
+
+func TestHTTPSRedirectHandlerDefaultPort(t *testing.T) {
+	config := Config{
+		HTTPSPort:         2443,
+		HTTPSRedirect:     true,
+		HTTPSRedirectPort: 443,
+	}
+	handler := httpsRedirectHandler(&config, http.NotFoundHandler())
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://example.com/", nil))
+	assert.Equal(t, "https://example.com/", recorder.Header().Get("Location"))
+}
+
+func TestHSTSHandler(t *testing.T) {
+	handler := hstsHandler(31536000, http.NotFoundHandler())
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "https://example.com/", nil))
+	assert.Equal(t, "max-age=31536000", recorder.Header().Get("Strict-Transport-Security"))
+}
@@ -1,35 +1,231 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/premkit/premkit/accesslog"
+	"github.com/premkit/premkit/audit"
+	"github.com/premkit/premkit/certs"
+	"github.com/premkit/premkit/discovery"
 	v1 "github.com/premkit/premkit/handlers/v1"
 	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/metrics"
+	"github.com/premkit/premkit/models"
+	"github.com/premkit/premkit/persistence"
+	"github.com/premkit/premkit/requestid"
+	"github.com/premkit/premkit/tracing"
+	"github.com/premkit/premkit/utils"
+	"github.com/premkit/premkit/webhooks"
 )
 
-// Run is the main entrypoint of this daemon.
+// leaseCheckInterval is how often services and upstreams are checked for expired leases.
+const leaseCheckInterval = 5 * time.Second
+
+// historyPruneInterval is how often old revisions are removed from the history of services.
+const historyPruneInterval = time.Hour
+
+// webhookTimeout is how long a webhook target has to answer a delivery.
+const webhookTimeout = 10 * time.Second
+
+// servicesFileInterval is how often the services file is checked for changes.
+const servicesFileInterval = 5 * time.Second
+
+// shutdownTimeout is how long the listeners have to finish the requests in flight on shutdown.
+const shutdownTimeout = 10 * time.Second
+
+// Run is the main entrypoint of this daemon.  It returns when SIGINT or SIGTERM is received, or when
+// a listener fails, after shutting down the listeners.
 func Run(config *Config) error {
-	router := mux.NewRouter()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	internal := router.PathPrefix("/premkit").Subrouter()
-	internalV1 := internal.PathPrefix("/v1").Subrouter()
-	internalV1.HandleFunc("/service", v1.RegisterService).Methods("POST")
+	var store models.Store
+	switch config.Store {
+	case "", StoreBolt:
+		db, err := persistence.GetDB()
+		if err != nil {
+			return err
+		}
 
-	// TODO serve the swagger.json using a gorilla static handlers
+		defer db.Close()
 
-	forward := router.PathPrefix("/").Subrouter()
-	forward.HandleFunc("/{path:.*}", v1.ForwardService)
+		if err := persistence.Migrate(db); err != nil {
+			return err
+		}
 
-	if config.HTTPPort != 0 {
+		repairs, err := persistence.RepairUpstreams(db, false)
+		if err != nil {
+			return err
+		}
+		for _, repair := range repairs {
+			log.Infof("Database repair: %s", repair)
+		}
+
+		store = models.NewBoltStore(db, metrics.ObserveTransaction)
+
+	case StoreMemory:
+		log.Infof("Keeping registered services in memory, they will be lost when premkit exits")
+		store = models.NewMemoryStore()
+
+	default:
+		err := fmt.Errorf("Unknown store %q", config.Store)
+		log.Error(err)
+		return err
+	}
+	registry := models.NewRegistry(store)
+
+	if config.ServicesFile != "" {
+		provider := discovery.NewFileProvider(registry, config.ServicesFile, servicesFileInterval)
+		if err := provider.Load(); err != nil {
+			log.Errorf("Failed to load services file %s: %v", config.ServicesFile, err)
+			return err
+		}
+		go provider.Watch(ctx)
+	}
+
+	if config.DockerSocket != "" {
+		log.Infof("Discovering services from docker containers on %s", config.DockerSocket)
+		go discovery.NewDockerProvider(registry, config.DockerSocket).Watch(ctx)
+	}
+
+	if config.Kubernetes {
+		provider, err := discovery.NewInClusterKubernetesProvider(registry, config.KubernetesNamespace)
+		if err != nil {
+			return err
+		}
+
+		log.Infof("Discovering services from kubernetes endpoint slices")
 		go func() {
-			log.Infof("Listening on port %d for http connections", config.HTTPPort)
-			log.Error(http.ListenAndServe(fmt.Sprintf(":%d", config.HTTPPort), router))
+			if err := provider.Watch(ctx); err != nil {
+				log.Errorf("Stopped discovering kubernetes services: %v", err)
+			}
 		}()
 	}
 
+	if len(config.WebhookURLs) > 0 {
+		dispatcher := &webhooks.Dispatcher{
+			URLs:   config.WebhookURLs,
+			Secret: config.WebhookSecret,
+			Events: config.WebhookEvents,
+			Client: &http.Client{Timeout: webhookTimeout},
+		}
+
+		if config.WebhookDeadLetter != "" {
+			deadLetter, err := utils.OpenRotatingFile(config.WebhookDeadLetter, 0, 0)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+			defer deadLetter.Close()
+			dispatcher.DeadLetter = deadLetter
+		}
+
+		if config.WebhookSecret == "" {
+			log.Warningf("Webhook deliveries are not signed because no webhook secret is set")
+		}
+		log.Infof("Delivering events to %d webhooks", len(config.WebhookURLs))
+		go dispatcher.Run(ctx, registry.Events())
+	}
+
+	if config.OTLPEndpoint != "" {
+		shutdown, err := tracing.Setup(context.Background(), config.OTLPEndpoint, config.TraceSampleRatio)
+		if err != nil {
+			return err
+		}
+		defer shutdown(context.Background())
+
+		log.Infof("Exporting traces to %s", config.OTLPEndpoint)
+	}
+
+	var auditor *audit.Logger
+	if config.AuditLog != "" {
+		logger, err := audit.Open(config.AuditLog, int64(config.AuditLogMaxSize)*1024*1024, config.AuditLogMaxBackups)
+		if err != nil {
+			return err
+		}
+		defer logger.Close()
+
+		log.Infof("Auditing admin api calls to %s", config.AuditLog)
+		auditor = logger
+	}
+
+	if err := metrics.RegisterRegistrySize(registry.RegistrySize); err != nil {
+		log.Error(err)
+		return err
+	}
+	go metrics.Run(ctx, registry.Events())
+
+	trusted, err := requestid.ParseNetworks(config.RequestIDTrusted)
+	if err != nil {
+		return err
+	}
+
+	api := v1.NewAPI(registry, trusted)
+	go api.RunBalancer(ctx)
+	router := publicRouter(api, auditor)
+
+	var accessLogger *accesslog.Logger
+	if config.AccessLog != "" {
+		logger, err := accesslog.Open(config.AccessLog, config.AccessLogFormat, config.AccessLogTemplate,
+			int64(config.AccessLogMaxSize)*1024*1024, config.AccessLogMaxBackups)
+		if err != nil {
+			return err
+		}
+		defer logger.Close()
+
+		log.Infof("Logging requests to %s", config.AccessLog)
+		accessLogger = logger
+	}
+
+	httpHandler := http.Handler(router)
+	if config.HTTPSRedirect {
+		if config.HTTPSPort == 0 {
+			log.Warningf("Not redirecting http connections to https because https is disabled")
+		} else {
+			httpHandler = httpsRedirectHandler(config, router)
+		}
+	}
+	httpHandler = requestid.Handler(trusted, accessLogger.Handler(httpHandler))
+
+	httpsHandler := http.Handler(router)
+	if config.HSTSMaxAge != 0 {
+		httpsHandler = hstsHandler(config.HSTSMaxAge, router)
+	}
+	httpsHandler = requestid.Handler(trusted, accessLogger.Handler(httpsHandler))
+
+	var servers []*http.Server
+	failed := make(chan error, 3)
+	serve := func(srv *http.Server, listen func() error) {
+		servers = append(servers, srv)
+		go func() {
+			if err := listen(); !errors.Is(err, http.ErrServerClosed) {
+				log.Error(err)
+				failed <- err
+			}
+		}()
+	}
+
+	if config.HTTPPort != 0 {
+		log.Infof("Listening on port %d for http connections", config.HTTPPort)
+		srv := &http.Server{
+			Addr:    fmt.Sprintf(":%d", config.HTTPPort),
+			Handler: httpHandler,
+		}
+		serve(srv, srv.ListenAndServe)
+	}
+
 	if config.HTTPSPort != 0 {
 		pair, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
 		if err != nil {
@@ -37,23 +233,158 @@ func Run(config *Config) error {
 			return err
 		}
 
-		go func() {
-			log.Infof("Listening on port %d for https connections", config.HTTPSPort)
-			srv := &http.Server{
-				Addr:      fmt.Sprintf(":%d", config.HTTPSPort),
-				Handler:   router,
-				TLSConfig: getTLSConfig([]tls.Certificate{pair}),
+		if certificate, err := x509.ParseCertificate(pair.Certificate[0]); err != nil {
+			log.Errorf("Failed to parse certificate %s: %v", config.TLSCertFile, err)
+		} else {
+			metrics.SetCertificateExpiry(config.TLSCertFile, certificate)
+		}
+
+		var clientCAs *x509.CertPool
+		if config.TLSClientCAFile != "" {
+			pool, err := certs.LoadCertPool(config.TLSClientCAFile)
+			if err != nil {
+				return err
 			}
-			log.Error(srv.ListenAndServeTLS("", ""))
-		}()
+
+			log.Infof("Verifying client certificates signed by %s", config.TLSClientCAFile)
+			clientCAs = pool
+		}
+
+		log.Infof("Listening on port %d for https connections", config.HTTPSPort)
+		srv := &http.Server{
+			Addr:      fmt.Sprintf(":%d", config.HTTPSPort),
+			Handler:   httpsHandler,
+			TLSConfig: getTLSConfig([]tls.Certificate{pair}, clientCAs),
+		}
+		serve(srv, func() error { return srv.ListenAndServeTLS("", "") })
+	}
+
+	if config.AdminPort != 0 {
+		log.Infof("Listening on port %d for admin connections", config.AdminPort)
+		srv := &http.Server{
+			Addr:    fmt.Sprintf(":%d", config.AdminPort),
+			Handler: requestid.Handler(trusted, adminRouter(api, auditor)),
+		}
+		serve(srv, srv.ListenAndServe)
+	}
+
+	if config.AdminPort == 0 {
+		log.Infof("The backup endpoint is disabled because no admin port is set")
+	}
+
+	go expireLeases(ctx, registry, leaseCheckInterval)
+	go pruneHistory(ctx, registry, historyPruneInterval, config.HistoryMaxRevisions, time.Duration(config.HistoryMaxAge)*24*time.Hour)
+
+	select {
+	case <-ctx.Done():
+		log.Infof("Shutting down")
+		return shutdown(servers, shutdownTimeout, nil)
+	case err := <-failed:
+		return shutdown(servers, shutdownTimeout, err)
+	}
+}
+
+// shutdown gracefully shuts down the listeners, waiting up to timeout for the requests in flight,
+// and returns err.
+func shutdown(servers []*http.Server, timeout time.Duration, err error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, srv := range servers {
+		if shutdownErr := srv.Shutdown(ctx); shutdownErr != nil {
+			log.Errorf("Failed to shut down the listener on %s: %v", srv.Addr, shutdownErr)
+		}
 	}
 
-	<-make(chan struct{})
-	return nil
+	return err
 }
 
-func getTLSConfig(certs []tls.Certificate) *tls.Config {
-	return &tls.Config{
+// publicRouter returns the router of the http and https listeners, which serve the admin api
+// under /premkit and forward every other request to the services.
+func publicRouter(api *v1.API, auditor *audit.Logger) *mux.Router {
+	router := mux.NewRouter()
+
+	internal := router.PathPrefix("/premkit").Subrouter()
+	adminRoutes(internal, api, auditor)
+
+	// TODO serve the swagger.json using a gorilla static handlers
+
+	forward := router.PathPrefix("/").Subrouter()
+	forward.HandleFunc("/{path:.*}", api.ForwardService)
+
+	return router
+}
+
+// adminRouter returns the router of the admin listener.  Metrics and the backup of the database
+// are only served there, because the admin listener is not exposed with the services.
+func adminRouter(api *v1.API, auditor *audit.Logger) *mux.Router {
+	admin := mux.NewRouter()
+
+	internal := admin.PathPrefix("/premkit").Subrouter()
+	adminRoutes(internal, api, auditor)
+	internal.Handle("/v1/backup", auditHandler(auditor, "backup", api.Backup)).Methods("GET")
+
+	admin.Handle("/metrics", metrics.Handler()).Methods("GET")
+
+	return admin
+}
+
+// adminRoutes adds the routes of the admin api to the /premkit router, auditing every call when
+// auditor is set.
+func adminRoutes(internal *mux.Router, api *v1.API, auditor *audit.Logger) {
+	internalV1 := internal.PathPrefix("/v1").Subrouter()
+	internalV1.Handle("/service", auditHandler(auditor, "registerService", api.RegisterService)).Methods("POST")
+	internalV1.Handle("/service", auditHandler(auditor, "listServices", api.ListServices)).Methods("GET")
+	internalV1.Handle("/service/{name}", auditHandler(auditor, "getService", api.GetService)).Methods("GET")
+	internalV1.Handle("/service/{name}", auditHandler(auditor, "deregisterService", api.DeregisterService)).Methods("DELETE")
+	internalV1.Handle("/service/{name}/heartbeat", auditHandler(auditor, "heartbeat", api.Heartbeat)).Methods("PUT")
+	internalV1.Handle("/service/{name}/drain", auditHandler(auditor, "drain", api.Drain)).Methods("PUT")
+	internalV1.Handle("/service/{name}/upstream", auditHandler(auditor, "addUpstream", api.AddUpstream)).Methods("POST")
+	internalV1.Handle("/service/{name}/history", auditHandler(auditor, "listRevisions", api.ListRevisions)).Methods("GET")
+	internalV1.Handle("/service/{name}/rollback", auditHandler(auditor, "rollback", api.Rollback)).Methods("POST")
+	internalV1.Handle("/events", auditHandler(auditor, "events", api.Events)).Methods("GET")
+}
+
+// expireLeases periodically removes services and upstreams with expired leases, until ctx is done.
+func expireLeases(ctx context.Context, registry *models.Registry, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := registry.ExpireLeases(now); err != nil {
+				log.Errorf("Failed to expire leases: %v", err)
+			}
+		}
+	}
+}
+
+// pruneHistory periodically keeps at most maxRevisions revisions of each service, and deletes the
+// history of services that were deleted more than maxAge before, until ctx is done.  A zero limit
+// is not applied.
+func pruneHistory(ctx context.Context, registry *models.Registry, interval time.Duration, maxRevisions int, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := registry.PruneHistory(now, maxRevisions, maxAge); err != nil {
+				log.Errorf("Failed to prune the history of services: %v", err)
+			}
+		}
+	}
+}
+
+// getTLSConfig returns the config of the https listener.  When clientCAs is set, the client
+// certificates that are given are verified with it, so they can identify the caller.
+func getTLSConfig(certs []tls.Certificate, clientCAs *x509.CertPool) *tls.Config {
+	config := &tls.Config{
 		MinVersion:               tls.VersionTLS12,
 		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
 		PreferServerCipherSuites: true,
@@ -65,4 +396,11 @@ func getTLSConfig(certs []tls.Certificate) *tls.Config {
 		},
 		Certificates: certs,
 	}
+
+	if clientCAs != nil {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		config.ClientCAs = clientCAs
+	}
+
+	return config
 }
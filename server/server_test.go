@@ -1 +1,38 @@
 package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v1 "github.com/premkit/premkit/handlers/v1"
+	"github.com/premkit/premkit/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupOnlyOnAdminRouter(t *testing.T) {
+	api := v1.NewAPI(models.NewRegistry(models.NewMemoryStore()), nil)
+
+	// The public router forwards the request like any other, and no service is registered for it
+	recorder := httptest.NewRecorder()
+	publicRouter(api, nil).ServeHTTP(recorder, httptest.NewRequest("GET", "/premkit/v1/backup", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	// The memory store cannot be backed up, but the admin router has the endpoint
+	recorder = httptest.NewRecorder()
+	adminRouter(api, nil).ServeHTTP(recorder, httptest.NewRequest("GET", "/premkit/v1/backup", nil))
+	assert.Equal(t, http.StatusNotImplemented, recorder.Code)
+}
+
+func TestRunReturnsWhenListenerFails(t *testing.T) {
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// The port is taken, so Run shuts down and returns instead of blocking forever
+	err = Run(&Config{Store: StoreMemory, HTTPPort: listener.Addr().(*net.TCPAddr).Port})
+	assert.Error(t, err)
+}
@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/premkit/premkit/log"
+	"github.com/premkit/premkit/version"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer of premkit spans.
+const instrumentationName = "github.com/premkit/premkit"
+
+// Setup exports spans over OTLP/HTTP to the collector at endpoint (e.g.
+// http://collector:4318), sampling sampleRatio of the traces that are not already sampled by the
+// caller.  The returned function flushes the spans that are not exported yet, and stops exporting.
+func Setup(ctx context.Context, endpoint string, sampleRatio float64) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "premkit"),
+			attribute.String("service.version", version.Version()),
+		)),
+	)
+
+	SetProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// SetProvider makes premkit record spans with provider, and propagate W3C trace context.  Tests
+// can set a provider with an in-process exporter.
+func SetProvider(provider trace.TracerProvider) {
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Tracer returns the tracer of premkit spans.  Spans are not recorded until a provider is set.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Extract returns ctx with the trace context of the headers of an incoming request.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Strip removes the trace context headers, including baggage, of an incoming request that is not
+// trusted, so they are neither continued nor forwarded to upstreams.
+func Strip(header http.Header) {
+	for _, field := range otel.GetTextMapPropagator().Fields() {
+		header.Del(field)
+	}
+}
+
+// Inject sets the traceparent, and the other trace context headers, of an outgoing request to the
+// span of ctx.
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
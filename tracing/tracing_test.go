@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	SetProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer SetProvider(noop.NewTracerProvider())
+
+	incoming := http.Header{}
+	incoming.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	ctx, span := Tracer().Start(Extract(context.Background(), incoming), "proxy")
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+	span.End()
+
+	spans := recorder.Ended()
+	require.Equal(t, 1, len(spans))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+
+	expected := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + spans[0].SpanContext().SpanID().String() + "-01"
+	assert.Equal(t, expected, outgoing.Get("traceparent"))
+}
+
+func TestStrip(t *testing.T) {
+	SetProvider(noop.NewTracerProvider())
+
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	header.Set("tracestate", "vendor=value")
+	header.Set("baggage", "user=mallory")
+	header.Set("Accept", "text/html")
+	Strip(header)
+
+	assert.Equal(t, http.Header{"Accept": []string{"text/html"}}, header)
+}
+
+func TestSetup(t *testing.T) {
+	shutdown, err := Setup(context.Background(), "http://localhost:4318", 0.5)
+	require.NoError(t, err)
+	defer SetProvider(noop.NewTracerProvider())
+
+	_, span := Tracer().Start(context.Background(), "span")
+	span.End()
+
+	// Nothing is listening, so the span is dropped, but shutting down should not hang
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	shutdown(ctx)
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// ResponseRecorder is a http.ResponseWriter that records the status and size of the response it
+// passes through.
+type ResponseRecorder struct {
+	http.ResponseWriter
+
+	// Status is the status of the response, http.StatusOK until the header is written.  Bytes is
+	// the size of the body written so far.
+	Status int
+	Bytes  int64
+
+	// BeforeHeader, when set, is called once, just before the header is written, so the header
+	// can still be changed.
+	BeforeHeader func(header http.Header)
+
+	wroteHeader bool
+}
+
+// NewResponseRecorder returns a recorder of the response written to w.
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *ResponseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.wroteHeader = true
+		r.Status = status
+		if r.BeforeHeader != nil {
+			r.BeforeHeader(r.ResponseWriter.Header())
+		}
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *ResponseRecorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+
+	n, err := r.ResponseWriter.Write(b)
+	r.Bytes += int64(n)
+	return n, err
+}
+
+// Flush allows streaming responses to pass through the recorder.
+func (r *ResponseRecorder) Flush() {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack allows websocket connections to pass through the recorder.  The upstream answers them
+// with 101 Switching Protocols on the hijacked connection.
+func (r *ResponseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Response writer does not support hijacking")
+	}
+
+	r.wroteHeader = true
+	r.Status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
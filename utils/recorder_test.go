@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseRecorder(t *testing.T) {
+	response := httptest.NewRecorder()
+	recorder := NewResponseRecorder(response)
+
+	headers := 0
+	recorder.BeforeHeader = func(header http.Header) {
+		headers++
+		header.Set("X-Test", "set")
+	}
+
+	recorder.WriteHeader(http.StatusNotFound)
+	recorder.WriteHeader(http.StatusOK)
+	recorder.Write([]byte("not "))
+	recorder.Write([]byte("found"))
+
+	assert.Equal(t, http.StatusNotFound, recorder.Status)
+	assert.Equal(t, int64(9), recorder.Bytes)
+	assert.Equal(t, 1, headers)
+	assert.Equal(t, "set", response.Header().Get("X-Test"))
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestResponseRecorderImplicitHeader(t *testing.T) {
+	response := httptest.NewRecorder()
+	recorder := NewResponseRecorder(response)
+
+	recorder.Flush()
+
+	assert.Equal(t, http.StatusOK, recorder.Status)
+	assert.True(t, response.Flushed)
+
+	_, _, err := recorder.Hijack()
+	assert.Error(t, err)
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "audit.log")
+
+	r, err := OpenRotatingFile(filePath, 10, 2)
+	require.NoError(t, err)
+	defer r.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := r.Write([]byte(line))
+		require.NoError(t, err)
+	}
+
+	contents, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, "fourth\n", string(contents))
+
+	contents, err = ioutil.ReadFile(filePath + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, "third\n", string(contents))
+
+	contents, err = ioutil.ReadFile(filePath + ".2")
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", string(contents))
+
+	_, err = os.Stat(filePath + ".3")
+	assert.True(t, os.IsNotExist(err), "only 2 backups should be kept")
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "audit.log")
+	require.NoError(t, ioutil.WriteFile(filePath, []byte("existing\n"), 0600))
+
+	r, err := OpenRotatingFile(filePath, 0, 0)
+	require.NoError(t, err)
+
+	_, err = r.Write([]byte("appended\n"))
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	contents, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, "existing\nappended\n", string(contents))
+
+	_, err = r.Write([]byte("closed\n"))
+	assert.Error(t, err)
+}
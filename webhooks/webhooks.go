@@ -0,0 +1,236 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/premkit/premkit/events"
+	"github.com/premkit/premkit/log"
+)
+
+const (
+	// EventHeader is the type of the event in a delivery.
+	EventHeader = "X-Premkit-Event"
+
+	// DeliveryHeader is the ID of the event in a delivery, which is the same for every attempt.
+	DeliveryHeader = "X-Premkit-Delivery"
+
+	// SignatureHeader is the signature of the body of a delivery, see Sign.
+	SignatureHeader = "X-Premkit-Signature-256"
+)
+
+const (
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Second
+	maxBackoff         = time.Minute
+
+	// queueSize is the number of events that can wait for delivery to a target before new events
+	// are sent to the dead letter log.
+	queueSize = 256
+)
+
+// Dispatcher delivers events to webhook targets.
+type Dispatcher struct {
+	// URLs are the targets, which each receive every event in a POST.
+	URLs []string
+
+	// Secret, when set, signs every delivery.
+	Secret string
+
+	// Events are the types of events delivered, or all events when empty.
+	Events []string
+
+	// MaxAttempts is the number of times a delivery is tried, waiting Backoff after the first
+	// failure and twice as long after each one that follows.
+	MaxAttempts int
+	Backoff     time.Duration
+
+	// DeadLetter receives a line of JSON for every event that could not be delivered.  Failures
+	// are only logged when it is nil.
+	DeadLetter io.Writer
+
+	Client *http.Client
+
+	deadLetterMu sync.Mutex
+}
+
+// DeadLetter is an event that could not be delivered to a target.
+type DeadLetter struct {
+	Time     time.Time     `json:"time"`
+	URL      string        `json:"url"`
+	Attempts int           `json:"attempts"`
+	Error    string        `json:"error"`
+	Event    *events.Event `json:"event"`
+}
+
+// Sign returns the signature of body with secret, as "sha256=" and the hex encoded HMAC-SHA256.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Run delivers the events published on bus until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context, bus *events.Bus) {
+	wanted := make(map[string]bool)
+	for _, eventType := range d.Events {
+		wanted[eventType] = true
+	}
+
+	queues := make([]chan *events.Event, 0, len(d.URLs))
+	for _, url := range d.URLs {
+		queue := make(chan *events.Event, queueSize)
+		queues = append(queues, queue)
+		go d.deliverQueue(ctx, url, queue)
+	}
+
+	bus.Watch(ctx, func(event *events.Event) {
+		if len(wanted) > 0 && !wanted[event.Type] {
+			return
+		}
+
+		for i, queue := range queues {
+			select {
+			case queue <- event:
+			default:
+				d.deadLetter(d.URLs[i], event, 0, fmt.Errorf("Delivery queue is full"))
+			}
+		}
+	})
+}
+
+// deliverQueue delivers the events queued for a target, in order.
+func (d *Dispatcher) deliverQueue(ctx context.Context, url string, queue <-chan *events.Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-queue:
+			d.Deliver(ctx, url, event)
+		}
+	}
+}
+
+// Deliver sends the event to a target, trying again after failures.  The event is sent to the
+// dead letter log when every attempt fails.  Returns true if the event was delivered.
+func (d *Dispatcher) Deliver(ctx context.Context, url string, event *events.Event) bool {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Error(err)
+		return false
+	}
+
+	maxAttempts := d.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	backoff := d.Backoff
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+
+	attempt := 0
+	for {
+		attempt++
+
+		retry, err := d.post(ctx, url, event, body)
+		if err == nil {
+			return true
+		}
+
+		if !retry || attempt >= maxAttempts {
+			d.deadLetter(url, event, attempt, err)
+			return false
+		}
+
+		log.Warningf("Failed to deliver event %s to %s, attempt %d of %d: %v", event.ID, url, attempt, maxAttempts, err)
+
+		select {
+		case <-ctx.Done():
+			d.deadLetter(url, event, attempt, err)
+			return false
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// post makes one attempt to deliver the event, and returns whether a failure could succeed if it
+// is tried again.
+func (d *Dispatcher) post(ctx context.Context, url string, event *events.Event, body []byte) (bool, error) {
+	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request = request.WithContext(ctx)
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventHeader, event.Type)
+	request.Header.Set(DeliveryHeader, event.ID)
+	if d.Secret != "" {
+		request.Header.Set(SignatureHeader, Sign(d.Secret, body))
+	}
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	ioutil.ReadAll(io.LimitReader(response.Body, 4096))
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("Unexpected status %d", response.StatusCode)
+
+	// Other client errors will fail the same way again
+	retry := response.StatusCode >= 500 || response.StatusCode == http.StatusRequestTimeout || response.StatusCode == http.StatusTooManyRequests
+	return retry, err
+}
+
+func (d *Dispatcher) deadLetter(url string, event *events.Event, attempts int, err error) {
+	log.Errorf("Failed to deliver event %s to %s after %d attempts: %v", event.ID, url, attempts, err)
+
+	if d.DeadLetter == nil {
+		return
+	}
+
+	b, marshalErr := json.Marshal(DeadLetter{
+		Time:     time.Now(),
+		URL:      url,
+		Attempts: attempts,
+		Error:    err.Error(),
+		Event:    event,
+	})
+	if marshalErr != nil {
+		log.Error(marshalErr)
+		return
+	}
+
+	d.deadLetterMu.Lock()
+	defer d.deadLetterMu.Unlock()
+
+	if _, err := d.DeadLetter.Write(append(b, '\n')); err != nil {
+		log.Errorf("Failed to write to the webhook dead letter log: %v", err)
+	}
+}
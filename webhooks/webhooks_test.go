@@ -0,0 +1,148 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/premkit/premkit/events"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// target is a webhook target that fails the first failures requests.
+type target struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	failures  int
+	status    int
+	received  []*http.Request
+	bodies    [][]byte
+	delivered chan struct{}
+}
+
+func newTarget(failures int, status int) *target {
+	t := &target{failures: failures, status: status, delivered: make(chan struct{}, 10)}
+	t.Server = httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		body, _ := ioutil.ReadAll(request.Body)
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		t.received = append(t.received, request)
+		t.bodies = append(t.bodies, body)
+		if len(t.received) <= t.failures {
+			response.WriteHeader(t.status)
+			return
+		}
+
+		response.WriteHeader(http.StatusNoContent)
+		t.delivered <- struct{}{}
+	}))
+	return t
+}
+
+func TestDeliver(t *testing.T) {
+	server := newTarget(2, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	dispatcher := Dispatcher{
+		Secret:  "secret",
+		Backoff: time.Millisecond,
+	}
+
+	event := &events.Event{ID: "epoch-7", Type: events.HealthChanged, Service: "service", Upstream: "http://localhost:3000"}
+	require.True(t, dispatcher.Deliver(context.Background(), server.URL, event))
+
+	require.Equal(t, 3, len(server.received), "the delivery should be retried until it succeeds")
+	request := server.received[2]
+	assert.Equal(t, events.HealthChanged, request.Header.Get(EventHeader))
+	assert.Equal(t, "epoch-7", request.Header.Get(DeliveryHeader))
+	assert.Equal(t, Sign("secret", server.bodies[2]), request.Header.Get(SignatureHeader))
+	assert.True(t, strings.HasPrefix(request.Header.Get(SignatureHeader), "sha256="))
+
+	delivered := events.Event{}
+	require.NoError(t, json.Unmarshal(server.bodies[2], &delivered))
+	assert.Equal(t, *event, delivered)
+}
+
+func TestDeliverDeadLetter(t *testing.T) {
+	deadLetters := &bytes.Buffer{}
+
+	server := newTarget(10, http.StatusInternalServerError)
+	defer server.Close()
+
+	dispatcher := Dispatcher{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		DeadLetter:  deadLetters,
+	}
+
+	event := &events.Event{ID: "epoch-8", Type: events.ServiceRemoved, Service: "service"}
+	require.False(t, dispatcher.Deliver(context.Background(), server.URL, event))
+	assert.Equal(t, 3, len(server.received))
+	assert.Empty(t, server.received[0].Header.Get(SignatureHeader), "deliveries are not signed without a secret")
+
+	deadLetter := DeadLetter{}
+	require.NoError(t, json.Unmarshal(deadLetters.Bytes(), &deadLetter))
+	assert.Equal(t, server.URL, deadLetter.URL)
+	assert.Equal(t, 3, deadLetter.Attempts)
+	assert.Equal(t, "Unexpected status 500", deadLetter.Error)
+	require.NotNil(t, deadLetter.Event)
+	assert.Equal(t, "epoch-8", deadLetter.Event.ID)
+
+	// Client errors are not retried
+	server = newTarget(10, http.StatusBadRequest)
+	defer server.Close()
+
+	require.False(t, dispatcher.Deliver(context.Background(), server.URL, event))
+	assert.Equal(t, 1, len(server.received))
+}
+
+func TestRun(t *testing.T) {
+	server := newTarget(0, 0)
+	defer server.Close()
+
+	dispatcher := Dispatcher{
+		URLs:   []string{server.URL},
+		Events: []string{events.ServiceAdded},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Wait for the dispatcher to subscribe
+	bus := events.NewBus()
+	done := make(chan struct{})
+	go func() {
+		dispatcher.Run(ctx, bus)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	bus.Publish(&events.Event{Type: events.ServiceUpdated, Service: "ignored"})
+	bus.Publish(&events.Event{Type: events.ServiceAdded, Service: "service"})
+
+	select {
+	case <-server.delivered:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the event was not delivered")
+	}
+
+	server.mu.Lock()
+	require.Equal(t, 1, len(server.received), "only the selected events should be delivered")
+	assert.Equal(t, events.ServiceAdded, server.received[0].Header.Get(EventHeader))
+	server.mu.Unlock()
+
+	cancel()
+	<-done
+}